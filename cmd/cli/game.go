@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/wingscam-server/server"
+	"github.com/google/uuid"
+)
+
+// game is what the player knows about the match or game they're in,
+// pieced together from the responses the same way a bot does it.
+type game struct {
+	mutex sync.Mutex
+
+	match       uuid.UUID
+	id          uuid.UUID
+	player      uuid.UUID
+	choosing    bool
+	turn        bool
+	mana        int
+	health      int
+	enemyHealth int
+	hand        []server.CardView
+	board       map[string]server.CardView
+	enemy       map[string]server.CardView
+}
+
+func newGame() *game {
+	game := &game{}
+	game.reset()
+	return game
+}
+
+func (g *game) reset() {
+	g.match = uuid.Nil
+	g.id = uuid.Nil
+	g.player = uuid.Nil
+	g.choosing = false
+	g.turn = false
+	g.mana = 0
+	g.health = 0
+	g.enemyHealth = 0
+	g.hand = nil
+	g.board = make(map[string]server.CardView)
+	g.enemy = make(map[string]server.CardView)
+}
+
+// update applies a response and describes it for the player.
+func (g *game) update(response server.Response) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	switch response.Type {
+	case server.Welcome:
+		// servers from before the handshake don't say which version
+		// they speak
+		welcome, ok := response.Payload.(server.WelcomePayload)
+		if !ok {
+			return "Connected to an older server, some things may not work"
+		}
+		if welcome.Version < server.ProtocolVersion {
+			return fmt.Sprintf("Connected to an older server speaking protocol version %v, some things may not work", welcome.Version)
+		}
+		return "Connected"
+	case server.LoggedIn:
+		identity := response.Payload.(server.Identity)
+		return fmt.Sprintf("Logged in as %v", identity.Name)
+	case server.WaitForMatch:
+		return fmt.Sprintf("Looking for a %v game...", response.Payload)
+	case server.Dequeued:
+		return "Stopped looking for a game"
+	case server.MatchFound:
+		g.match = response.Payload.(uuid.UUID)
+		return "Match found, type confirm or decline"
+	case server.MatchCanceled:
+		g.match = uuid.Nil
+		if payload, _ := response.Payload.(server.MatchCanceledPayload); payload.Requeued {
+			return "Match canceled, looking for another game"
+		}
+		return "Match canceled"
+	case server.StartingHand:
+		payload := response.Payload.(server.StartingHandView)
+
+		g.reset()
+		g.id = payload.GameId
+		g.player = payload.Id
+		g.choosing = true
+		g.health = payload.Health
+		g.enemyHealth = payload.Health
+		g.hand = payload.Cards
+
+		return "Game started, keep your hand or mulligan the cards to put back\n" + g.render()
+	case server.WaitOtherPlayers:
+		if hand, ok := response.Payload.([]server.CardView); ok {
+			g.choosing = false
+			g.hand = hand
+		}
+		return "Waiting for the other player..."
+	case server.StartTurn:
+		payload := response.Payload.(server.TurnView)
+
+		g.choosing = false
+		g.turn = true
+		g.mana = payload.Mana
+		if payload.Card != nil {
+			g.hand = append(g.hand, *payload.Card)
+		}
+
+		return "Your turn\n" + g.render()
+	case server.WaitTurn:
+		g.choosing = false
+		g.turn = false
+		return "Enemy turn"
+	case server.CardPlayed:
+		payload := response.Payload.(server.CardPlayedView)
+		if payload.Card == nil {
+			return ""
+		}
+
+		if payload.Player != g.player {
+			g.enemy[payload.Card.Id.String()] = *payload.Card
+			return fmt.Sprintf("Enemy played %v", formatCard(*payload.Card))
+		}
+
+		for idx, card := range g.hand {
+			if card.Id == payload.Card.Id {
+				g.hand = append(g.hand[:idx], g.hand[idx+1:]...)
+				break
+			}
+		}
+		g.mana = payload.Mana
+		g.board[payload.Card.Id.String()] = *payload.Card
+
+		return g.render()
+	case server.AttackResult:
+		boards := response.Payload.([]server.BoardView)
+
+		g.board = boards[0].Defenders
+		g.enemy = boards[1].Defenders
+
+		return g.render()
+	case server.DamageTaken:
+		payload := response.Payload.(server.DamageTakenPayload)
+
+		if payload.PlayerId == g.player.String() {
+			g.health = payload.Health
+			return fmt.Sprintf("You are at %v health", g.health)
+		}
+
+		g.enemyHealth = payload.Health
+		return fmt.Sprintf("Enemy is at %v health", g.enemyHealth)
+	case server.GameOver:
+		payload := response.Payload.(server.GameOverView)
+
+		won := payload.Winner.Id == g.player
+		g.reset()
+
+		if won {
+			return "You won!"
+		}
+		return "You lost"
+	case server.GameResumed:
+		payload := response.Payload.(server.GameResumedView)
+
+		g.reset()
+		g.id = payload.GameId
+		g.player = payload.Id
+		g.choosing = payload.Choosing
+		g.turn = payload.Current
+		g.mana = payload.Mana
+		g.health = payload.Health
+		g.enemyHealth = payload.EnemyHealth
+		g.hand = payload.Hand
+		if payload.Board.Defenders != nil {
+			g.board = payload.Board.Defenders
+		}
+		if payload.EnemyBoard.Defenders != nil {
+			g.enemy = payload.EnemyBoard.Defenders
+		}
+
+		if g.choosing {
+			return "Back in your game, keep your hand or mulligan the cards to put back\n" + g.render()
+		}
+		return "Back in your game\n" + g.render()
+	case server.OpponentLeft:
+		payload := response.Payload.(server.OpponentLeftPayload)
+		return fmt.Sprintf("Enemy disconnected, they forfeit unless they're back within %v", payload.Grace)
+	case server.OpponentReturned:
+		return "Enemy is back"
+	case server.GameAborted:
+		g.reset()
+		return "The game was aborted after a server error"
+	case server.Maintenance:
+		payload := response.Payload.(server.MaintenancePayload)
+		if payload.Deadline.IsZero() {
+			return "The server is going down for maintenance"
+		}
+		return fmt.Sprintf("The server is going down for maintenance, games end at %v", payload.Deadline.Format(time.Kitchen))
+	case server.Error:
+		return fmt.Sprintf("Error: %v", response.Payload)
+	}
+
+	return ""
+}
+
+func (g *game) render() string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "Enemy: %v health\n", g.enemyHealth)
+	for idx, card := range sortedCards(g.enemy) {
+		fmt.Fprintf(&out, "  %v) %v\n", idx+1, formatCard(card))
+	}
+
+	fmt.Fprintf(&out, "You: %v health, %v mana\n", g.health, g.mana)
+	for idx, card := range sortedCards(g.board) {
+		fmt.Fprintf(&out, "  %v) %v\n", idx+1, formatCard(card))
+	}
+
+	out.WriteString("Hand:\n")
+	for idx, card := range g.hand {
+		fmt.Fprintf(&out, "  %v) [%v] %v\n", idx+1, card.ManaCost, formatCard(card))
+	}
+
+	return strings.TrimRight(out.String(), "\n")
+}
+
+func (g *game) handCard(n int) (string, error) {
+	if n < 1 || n > len(g.hand) {
+		return "", fmt.Errorf("no card %v in hand", n)
+	}
+	return g.hand[n-1].Id.String(), nil
+}
+
+func (g *game) minion(board map[string]server.CardView, n int) (string, error) {
+	cards := sortedCards(board)
+	if n < 1 || n > len(cards) {
+		return "", fmt.Errorf("no minion %v on the board", n)
+	}
+	return cards[n-1].Id.String(), nil
+}
+
+// sortedCards keeps the numbers of minions on a board stable.
+func sortedCards(cards map[string]server.CardView) []server.CardView {
+	sorted := make([]server.CardView, 0, len(cards))
+	for _, card := range cards {
+		sorted = append(sorted, card)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id.String() < sorted[j].Id.String()
+	})
+	return sorted
+}
+
+func formatCard(card server.CardView) string {
+	return fmt.Sprintf("%v/%v", card.Damage, card.Health)
+}
@@ -0,0 +1,188 @@
+// Command cli plays the game from a terminal, for trying out the server
+// by hand.
+//
+//	cli -addr localhost:8080 -token <token>
+//
+// Type help once connected to list the commands.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/wingscam-server/server"
+	"github.com/google/uuid"
+)
+
+const help = `queue [mode]        look for a game, casual by default
+dequeue             stop looking for a game
+confirm, decline    answer a match that was found
+keep                keep the starting hand
+mulligan <n>...     put cards back from the starting hand
+play <n>            play card n from the hand
+attack <a> <t>      attack enemy minion t with minion a
+face <a>            attack the enemy with minion a
+end                 end the turn
+concede             give up the game
+show                show the game
+login <token>       log in when connected without a token
+quit                leave`
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "server address")
+	token := flag.String("token", os.Getenv("WINGSCAM_TOKEN"), "token to log in with")
+	flag.Parse()
+
+	target := *addr
+	if *token != "" {
+		target += "/?token=" + *token
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	client, err := server.Connect(ctx, target)
+	cancel()
+
+	if err != nil {
+		log.Fatalf("Could not connect to %v: %v", *addr, err)
+	}
+	defer client.Close()
+
+	game := newGame()
+
+	go func() {
+		for response := range client.Incoming {
+			if message := game.update(response); message != "" {
+				fmt.Println(message)
+			}
+		}
+
+		fmt.Printf("Disconnected: %v\n", client.Err())
+		os.Exit(1)
+	}()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+
+		if args[0] == "quit" {
+			return
+		}
+
+		if err := run(client, game, args[0], args[1:]); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func run(client *server.Client, game *game, command string, args []string) error {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+
+	numbers := make([]int, 0, len(args))
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+
+	switch command {
+	case "help":
+		fmt.Println(help)
+		return nil
+	case "login":
+		if len(args) != 1 {
+			return errors.New("usage: login <token>")
+		}
+		return client.Login(args[0])
+	case "queue":
+		mode := server.CasualMode.Name
+		if len(args) > 0 {
+			mode = args[0]
+		}
+		return client.QueueUp(mode)
+	case "dequeue":
+		return client.Dequeue()
+	case "confirm":
+		return client.ConfirmMatch(game.match)
+	case "decline":
+		return client.DeclineMatch(game.match)
+	case "show":
+		fmt.Println(game.render())
+		return nil
+	}
+
+	if game.id == uuid.Nil {
+		return errors.New("not in a game, type help for commands")
+	}
+
+	switch command {
+	case "keep":
+		return client.Discard(game.id, []string{})
+	case "mulligan":
+		if !game.choosing {
+			return errors.New("the starting hand was already chosen")
+		}
+
+		cards := make([]string, 0)
+		for _, n := range numbers {
+			card, err := game.handCard(n)
+			if err != nil {
+				return err
+			}
+			cards = append(cards, card)
+		}
+		return client.Discard(game.id, cards)
+	case "play":
+		if len(numbers) != 1 {
+			return errors.New("usage: play <n>")
+		}
+
+		card, err := game.handCard(numbers[0])
+		if err != nil {
+			return err
+		}
+		return client.PlayCard(game.id, card)
+	case "attack":
+		if len(numbers) != 2 {
+			return errors.New("usage: attack <a> <t>")
+		}
+
+		attacker, err := game.minion(game.board, numbers[0])
+		if err != nil {
+			return err
+		}
+
+		target, err := game.minion(game.enemy, numbers[1])
+		if err != nil {
+			return err
+		}
+		return client.Attack(game.id, attacker, target)
+	case "face":
+		if len(numbers) != 1 {
+			return errors.New("usage: face <a>")
+		}
+
+		attacker, err := game.minion(game.board, numbers[0])
+		if err != nil {
+			return err
+		}
+		return client.AttackPlayer(game.id, attacker)
+	case "end":
+		return client.EndTurn(game.id)
+	case "concede":
+		return client.Concede(game.id)
+	}
+
+	return fmt.Errorf("unknown command %v, type help for commands", command)
+}
@@ -0,0 +1,127 @@
+// Command loadtest connects many simulated clients to a server and has
+// them play full games, then reports response latencies, errors,
+// goroutines and memory.
+//
+//	loadtest -clients 500 -games 2
+//
+// By default the server runs in the same process on localhost, so the
+// goroutine and memory figures include it.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"example.com/wingscam-server/server"
+)
+
+func main() {
+	clients := flag.Int("clients", 100, "simulated clients")
+	games := flag.Int("games", 1, "games each client plays")
+	mode := flag.String("mode", server.CasualMode.Name, "game mode to queue for")
+	addr := flag.String("addr", "127.0.0.1:18080", "address to run the server on")
+	external := flag.Bool("external", false, "use a server already running at addr")
+	secret := flag.String("secret", os.Getenv("WINGSCAM_SECRET"), "secret of an external server, to sign tokens")
+	ramp := flag.Duration("ramp", 2*time.Millisecond, "pause between connecting clients")
+	timeout := flag.Duration("timeout", 5*time.Minute, "give up on clients still playing after this long")
+	codecName := flag.String("codec", server.JSONCodec.Subprotocol(), "subprotocol clients ask the server to speak")
+	flag.Parse()
+
+	var codec server.Codec
+	for _, known := range server.Codecs {
+		if known.Subprotocol() == *codecName {
+			codec = known
+		}
+	}
+	if codec == nil {
+		log.Fatalf("Unknown codec %v", *codecName)
+	}
+
+	if *external && *secret == "" {
+		log.Fatal("An external server needs -secret to sign tokens")
+	}
+
+	usage := sampleUsage(100 * time.Millisecond)
+
+	if !*external {
+		key := make([]byte, 32)
+		rand.Read(key)
+		*secret = string(key)
+
+		listen(*addr, server.NewHMACAuthenticator(key))
+	}
+
+	authenticator := server.NewHMACAuthenticator([]byte(*secret))
+	stats := newStats()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	started := time.Now()
+
+	var players sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		token, err := authenticator.Sign(server.Identity{
+			Id:   fmt.Sprintf("load-%v", i),
+			Name: fmt.Sprintf("Load %v", i),
+		}, *timeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		client, err := server.ConnectWith(ctx, *addr+"/?token="+token, codec)
+		if err != nil {
+			stats.fail(fmt.Sprintf("connect: %v", err))
+			continue
+		}
+
+		players.Add(1)
+		go func() {
+			defer players.Done()
+			defer client.Close()
+
+			newPlayer(client, *mode, *games, stats).run(ctx)
+		}()
+
+		time.Sleep(*ramp)
+	}
+
+	players.Wait()
+	elapsed := time.Since(started)
+	usage.stop()
+
+	fmt.Printf(
+		"%v clients, %v game overs in %v\n\n",
+		*clients,
+		stats.finished,
+		elapsed.Round(time.Millisecond),
+	)
+
+	stats.write(os.Stdout)
+	usage.write(os.Stdout)
+}
+
+// listen runs a server with everything main registers, keeping the data
+// in memory.
+func listen(addr string, authenticator server.Authenticator) {
+	store := server.NewMemoryStore()
+	dispatcher := server.NewDispatcher()
+
+	dispatcher.Register <- server.NewAccountManager(store)
+	dispatcher.Register <- server.NewQueueManager()
+	dispatcher.Register <- server.NewLobbyManager(store)
+	dispatcher.Register <- server.NewMatchmaker()
+	dispatcher.Register <- server.NewGameManager()
+
+	// simulated clients play far faster than people can, so only what
+	// they send is limited, not how often
+	srv := server.NewServer(dispatcher, authenticator)
+	srv.Limits = server.Limits{MaxMessageSize: server.DefaultLimits.MaxMessageSize}
+	srv.ListenQuietly(addr)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"example.com/wingscam-server/server"
+	"github.com/google/uuid"
+)
+
+// player is a simulated client. It plays like a server bot, but over a
+// real connection, and times how long each of its events takes to get
+// an answer.
+type player struct {
+	client   *server.Client
+	strategy server.Strategy
+	stats    *stats
+	mode     string
+	games    int
+
+	gameId    uuid.UUID
+	id        uuid.UUID
+	turn      bool
+	mana      int
+	health    int
+	enemy     int
+	hand      []server.CardView
+	board     map[string]server.CardView
+	opponent  map[string]server.CardView
+	exhausted map[string]bool
+
+	// when the last event went out, zero once something came back
+	sent time.Time
+}
+
+func newPlayer(client *server.Client, mode string, games int, stats *stats) *player {
+	return &player{
+		client:   client,
+		strategy: server.NewGreedyStrategy(),
+		stats:    stats,
+		mode:     mode,
+		games:    games,
+	}
+}
+
+func (p *player) run(ctx context.Context) {
+	for {
+		response, err := p.client.Next(ctx)
+		if err != nil {
+			p.stats.fail(fmt.Sprintf("connection: %v", err))
+			return
+		}
+
+		if !p.sent.IsZero() {
+			p.stats.observe(response.Type, time.Since(p.sent))
+			p.sent = time.Time{}
+		}
+
+		if !p.handle(response) {
+			return
+		}
+	}
+}
+
+func (p *player) send(event func() error) {
+	p.sent = time.Now()
+
+	if err := event(); err != nil {
+		p.stats.fail(fmt.Sprintf("send: %v", err))
+	}
+}
+
+// handle reacts to a response, returning false once the player has
+// played all of its games.
+func (p *player) handle(response server.Response) bool {
+	switch response.Type {
+	case server.LoggedIn:
+		p.send(func() error {
+			return p.client.QueueUp(p.mode)
+		})
+	case server.MatchFound:
+		matchId := response.Payload.(uuid.UUID)
+		p.send(func() error {
+			return p.client.ConfirmMatch(matchId)
+		})
+	case server.MatchCanceled:
+		// the server puts those who didn't let the match down back in
+		// the queue itself
+		if payload, _ := response.Payload.(server.MatchCanceledPayload); !payload.Requeued {
+			p.send(func() error {
+				return p.client.QueueUp(p.mode)
+			})
+		}
+	case server.StartingHand:
+		payload := response.Payload.(server.StartingHandView)
+
+		p.gameId = payload.GameId
+		p.id = payload.Id
+		p.turn = false
+		p.health = payload.Health
+		p.enemy = payload.Health
+		p.hand = payload.Cards
+		p.board = make(map[string]server.CardView)
+		p.opponent = make(map[string]server.CardView)
+		p.exhausted = make(map[string]bool)
+
+		discarded := p.strategy.Mulligan(p.state().Hand)
+		p.send(func() error {
+			return p.client.Discard(p.gameId, discarded)
+		})
+	case server.WaitOtherPlayers:
+		if hand, ok := response.Payload.([]server.CardView); ok {
+			p.hand = hand
+		}
+	case server.StartTurn:
+		payload := response.Payload.(server.TurnView)
+
+		p.turn = true
+		p.mana = payload.Mana
+		p.exhausted = make(map[string]bool)
+		if payload.Card != nil {
+			p.hand = append(p.hand, *payload.Card)
+		}
+
+		p.act()
+	case server.WaitTurn:
+		p.turn = false
+	case server.CardPlayed:
+		payload := response.Payload.(server.CardPlayedView)
+		if payload.Card == nil {
+			break
+		}
+
+		id := payload.Card.Id.String()
+
+		if payload.Player != p.id {
+			p.opponent[id] = *payload.Card
+			break
+		}
+
+		for idx, card := range p.hand {
+			if card.Id == payload.Card.Id {
+				p.hand = append(p.hand[:idx], p.hand[idx+1:]...)
+				break
+			}
+		}
+
+		p.mana = payload.Mana
+		p.board[id] = *payload.Card
+		p.exhausted[id] = true
+
+		if p.turn {
+			p.act()
+		}
+	case server.AttackResult:
+		boards := response.Payload.([]server.BoardView)
+
+		p.board = boards[0].Defenders
+		p.opponent = boards[1].Defenders
+
+		if p.turn {
+			p.act()
+		}
+	case server.DamageTaken:
+		payload := response.Payload.(server.DamageTakenPayload)
+
+		if payload.PlayerId == p.id.String() {
+			p.health = payload.Health
+			break
+		}
+
+		p.enemy = payload.Health
+
+		if p.turn {
+			p.act()
+		}
+	case server.GameOver, server.GameAborted:
+		if response.Type == server.GameAborted {
+			p.stats.fail("game aborted")
+		} else {
+			p.stats.finish()
+		}
+
+		p.games--
+		if p.games == 0 {
+			return false
+		}
+
+		p.send(func() error {
+			return p.client.QueueUp(p.mode)
+		})
+	case server.Error:
+		p.stats.fail(fmt.Sprintf("error: %v", response.Payload))
+
+		if p.turn {
+			p.endTurn()
+		}
+	}
+
+	return true
+}
+
+func (p *player) act() {
+	action := p.strategy.Act(p.state())
+
+	switch action.Type {
+	case server.PlayCard:
+		p.send(func() error {
+			return p.client.PlayCard(p.gameId, action.Card)
+		})
+	case server.Attack:
+		p.exhausted[action.Attacker] = true
+		p.send(func() error {
+			return p.client.Attack(p.gameId, action.Attacker, action.Target)
+		})
+	case server.AttackPlayer:
+		p.exhausted[action.Attacker] = true
+		p.send(func() error {
+			return p.client.AttackPlayer(p.gameId, action.Attacker)
+		})
+	default:
+		p.endTurn()
+	}
+}
+
+func (p *player) endTurn() {
+	p.turn = false
+	p.send(func() error {
+		return p.client.EndTurn(p.gameId)
+	})
+}
+
+// state rebuilds what the strategy needs from the views the client gets.
+func (p *player) state() server.BotState {
+	state := server.BotState{
+		GameId:      p.gameId,
+		Id:          p.id,
+		Mana:        p.mana,
+		Health:      p.health,
+		EnemyHealth: p.enemy,
+		Board:       make(map[string]server.ActiveDefender),
+		Enemy:       make(map[string]server.ActiveDefender),
+	}
+
+	for _, card := range p.hand {
+		state.Hand = append(state.Hand, minion(card))
+	}
+
+	for id, card := range p.board {
+		state.Board[id] = defender(card, !p.exhausted[id])
+	}
+
+	for id, card := range p.opponent {
+		state.Enemy[id] = defender(card, true)
+	}
+
+	return state
+}
+
+func minion(card server.CardView) *server.MinionCard {
+	return &server.MinionCard{
+		Card: server.Card{
+			Id:       card.Id,
+			ManaCost: card.ManaCost,
+		},
+		Damage: card.Damage,
+		Health: card.Health,
+	}
+}
+
+func defender(card server.CardView, ready bool) server.ActiveDefender {
+	var status server.Status = &server.Exhausted{}
+	if ready {
+		status = &server.Ready{}
+	}
+
+	return &server.ActiveMinion{
+		Defender: minion(card),
+		Status:   status,
+	}
+}
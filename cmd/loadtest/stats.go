@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/wingscam-server/server"
+)
+
+type stats struct {
+	mutex     sync.Mutex
+	latencies map[server.ResponseType][]time.Duration
+	errors    map[string]int
+	finished  int
+}
+
+func newStats() *stats {
+	return &stats{
+		latencies: make(map[server.ResponseType][]time.Duration),
+		errors:    make(map[string]int),
+	}
+}
+
+// observe records how long a response took to answer an event.
+func (s *stats) observe(kind server.ResponseType, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.latencies[kind] = append(s.latencies[kind], latency)
+}
+
+func (s *stats) fail(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.errors[reason]++
+}
+
+// finish counts a game over seen by one player, so a game between two
+// clients counts twice.
+func (s *stats) finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.finished++
+}
+
+func (s *stats) write(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kinds := make([]string, 0, len(s.latencies))
+	for kind := range s.latencies {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+
+	fmt.Fprintf(w, "%-20v %8v %10v %10v %10v %10v\n", "response", "count", "p50", "p90", "p99", "max")
+
+	for _, kind := range kinds {
+		latencies := s.latencies[server.ResponseType(kind)]
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+
+		fmt.Fprintf(
+			w,
+			"%-20v %8v %10v %10v %10v %10v\n",
+			kind,
+			len(latencies),
+			percentile(latencies, 0.5),
+			percentile(latencies, 0.9),
+			percentile(latencies, 0.99),
+			percentile(latencies, 1),
+		)
+	}
+
+	reasons := make([]string, 0, len(s.errors))
+	for reason := range s.errors {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	fmt.Fprintf(w, "\nerrors: %v\n", len(reasons))
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %6v  %v\n", s.errors[reason], reason)
+	}
+}
+
+// percentile expects latencies to be sorted.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p*float64(len(latencies)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return latencies[idx].Round(time.Microsecond)
+}
+
+// usage samples the goroutines and heap of the process, server included
+// when it runs in-process.
+type usage struct {
+	mutex          sync.Mutex
+	goroutines     int
+	peakGoroutines int
+	peakHeap       uint64
+
+	done chan bool
+}
+
+func sampleUsage(interval time.Duration) *usage {
+	usage := &usage{
+		goroutines: runtime.NumGoroutine(),
+		done:       make(chan bool),
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			usage.sample()
+
+			select {
+			case <-ticker.C:
+			case <-usage.done:
+				return
+			}
+		}
+	}()
+
+	return usage
+}
+
+func (u *usage) sample() {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	goroutines := runtime.NumGoroutine()
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if goroutines > u.peakGoroutines {
+		u.peakGoroutines = goroutines
+	}
+	if memory.HeapAlloc > u.peakHeap {
+		u.peakHeap = memory.HeapAlloc
+	}
+}
+
+func (u *usage) stop() {
+	close(u.done)
+	u.sample()
+}
+
+func (u *usage) write(w io.Writer) {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	fmt.Fprintf(
+		w,
+		"\ngoroutines: %v at start, %v at peak, %v at end\n",
+		u.goroutines,
+		u.peakGoroutines,
+		runtime.NumGoroutine(),
+	)
+	fmt.Fprintf(
+		w,
+		"memory: %.1f MiB peak heap, %.1f MiB from the OS, %.1f MiB allocated in total, %v GC runs\n",
+		mebibytes(u.peakHeap),
+		mebibytes(memory.Sys),
+		mebibytes(memory.TotalAlloc),
+		memory.NumGC,
+	)
+}
+
+func mebibytes(bytes uint64) float64 {
+	return float64(bytes) / (1 << 20)
+}
@@ -0,0 +1,151 @@
+// Command simulate plays bots against each other in-process and reports
+// how decks and cards perform, for trying out card changes before they
+// ship.
+//
+//	simulate -decks decks.json -games 5000 -seed 42 -format csv
+//
+// The decks file holds a JSON array of deck lists. Without one, two
+// random decks are generated from the seed.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"example.com/wingscam-server/server"
+)
+
+func main() {
+	decksPath := flag.String("decks", "", "JSON file with the deck lists to play")
+	games := flag.Int("games", 1000, "games per pairing of decks")
+	seed := flag.Int64("seed", 1, "seed for shuffles and random strategies")
+	strategy := flag.String("strategy", "greedy", "bot strategy, greedy or random")
+	format := flag.String("format", "json", "output format, json or csv")
+	workers := flag.Int("workers", 0, "games played at once, defaults to the number of CPUs")
+	turn := flag.Duration("turn", 5*time.Second, "turn timer, only reached if a bot stalls")
+	flag.Parse()
+
+	mode := server.CasualMode
+	mode.Rules.TurnDuration = *turn
+
+	decks, err := loadDecks(*decksPath, *seed, mode.Rules.DeckSize)
+	if err != nil {
+		log.Fatalf("Could not load decks: %v", err)
+	}
+
+	report, err := server.Simulate(server.SimulationConfig{
+		Decks:    decks,
+		Games:    *games,
+		Seed:     *seed,
+		Strategy: *strategy,
+		Mode:     mode,
+		Workers:  *workers,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *format {
+	case "json":
+		err = writeJSON(os.Stdout, report)
+	case "csv":
+		err = writeCSV(os.Stdout, report)
+	default:
+		err = fmt.Errorf("unknown format %v", *format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadDecks(path string, seed int64, size int) ([]server.DeckList, error) {
+	if path == "" {
+		random := rand.New(rand.NewSource(seed))
+		return []server.DeckList{
+			randomDeck("random-1", size, random),
+			randomDeck("random-2", size, random),
+		}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var decks []server.DeckList
+	if err := json.NewDecoder(file).Decode(&decks); err != nil {
+		return nil, err
+	}
+
+	for idx := range decks {
+		if decks[idx].Name == "" && decks[idx].Id == "" {
+			decks[idx].Name = fmt.Sprintf("deck-%v", idx+1)
+		}
+	}
+
+	return decks, nil
+}
+
+func randomDeck(name string, size int, random *rand.Rand) server.DeckList {
+	deck := server.DeckList{Name: name}
+	for i := 0; i < size; i++ {
+		deck.Cards = append(deck.Cards, server.CardSpec{
+			ManaCost: random.Intn(10),
+			Damage:   random.Intn(10),
+			Health:   random.Intn(10),
+		})
+	}
+	return deck
+}
+
+func writeJSON(w io.Writer, report server.SimulationReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// writeCSV puts decks and cards in one table, told apart by the first
+// column. The "all" row sums up every game.
+func writeCSV(w io.Writer, report server.SimulationReport) error {
+	writer := csv.NewWriter(w)
+
+	writer.Write([]string{
+		"kind", "deck", "card", "games", "wins", "win_rate", "average_turns",
+		"played", "play_rate", "wins_when_played", "win_rate_when_played",
+	})
+
+	writer.Write([]string{
+		"all", "", "", fmt.Sprint(report.Games), "", "",
+		formatFloat(report.AverageTurns), "", "", "", "",
+	})
+
+	for _, deck := range report.Decks {
+		writer.Write([]string{
+			"deck", deck.Deck, "", fmt.Sprint(deck.Games), fmt.Sprint(deck.Wins),
+			formatFloat(deck.WinRate), formatFloat(deck.AverageTurns), "", "", "", "",
+		})
+	}
+
+	for _, card := range report.Cards {
+		writer.Write([]string{
+			"card", card.Deck, card.Card, fmt.Sprint(card.Games), "", "", "",
+			fmt.Sprint(card.Played), formatFloat(card.PlayRate),
+			fmt.Sprint(card.WinsWhenPlayed), formatFloat(card.WinRateWhenPlayed),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatFloat(value float64) string {
+	return fmt.Sprintf("%.4f", value)
+}
@@ -1,9 +1,11 @@
 module example.com/wingscam-server
 
-go 1.17
+go 1.18
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
-	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.0
+	go.etcd.io/bbolt v1.3.6
 )
+
+require golang.org/x/sys v0.0.0-20200923182605-d9f96fdee20d // indirect
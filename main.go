@@ -1,14 +1,69 @@
 package main
 
-import "example.com/wingscam-server/server"
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"example.com/wingscam-server/server"
+)
 
 func main() {
-    dispatcher := server.NewDispatcher()
+	config, err := server.LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
+
+	store, err := server.NewBoltStore(config.Store)
+	if err != nil {
+		log.Fatalf("Could not open store at %v: %v", config.Store, err)
+	}
+	defer store.Close()
+
+	metrics := server.NewMetrics()
+
+	middlewares := []server.Middleware{
+		server.CountEvents(metrics.Events),
+	}
+	if config.LogEvents {
+		middlewares = append(middlewares, server.LogEvents())
+	}
+
+	dispatcher := server.NewDispatcherWithMetrics(metrics, middlewares...)
+
+	dispatcher.Register <- server.NewAccountManagerWithClock(store, server.RealClock)
+	dispatcher.Register <- server.NewQueueManagerWithStore(store, config.Queue, server.RealClock)
+	dispatcher.Register <- server.NewLobbyManager(store, config.Queue.Modes...)
+	dispatcher.Register <- server.NewMatchmakerWithConfig(config.Matchmaker, server.RealClock)
+	dispatcher.Register <- server.NewGameManagerWithConfig(config.Game, server.RealClock)
+
+	authenticator := server.NewHMACAuthenticator([]byte(config.Secret))
+
+	server := server.NewServerWithConfig(dispatcher, authenticator, config.Server)
+	go server.Listen(config.Addr)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// games get a while to finish, but a second signal ends them now
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownGrace)
+	defer cancel()
 
-    dispatcher.Register <- server.NewQueueManager()
-    dispatcher.Register <- server.NewMatchmaker()
-    dispatcher.Register <- server.NewGameManager()
+	go func() {
+		<-stop
+		cancel()
+	}()
 
-	server := server.NewServer(dispatcher)
-	server.Listen("0.0.0.0:8080")
+	log.Println("Shutting down, waiting for games to finish")
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Games still running were aborted: %v", err)
+	}
 }
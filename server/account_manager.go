@@ -0,0 +1,194 @@
+package server
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+)
+
+type AccountManager struct {
+	store Store
+	clock Clock
+}
+
+func NewAccountManager(store Store) *AccountManager {
+	return NewAccountManagerWithClock(store, RealClock)
+}
+
+func NewAccountManagerWithClock(store Store, clock Clock) *AccountManager {
+	return &AccountManager{store: store, clock: clock}
+}
+
+func (am *AccountManager) Subscriptions() []Subscription {
+	return subscribe("", PlayerLoggedIn, SaveDeck, GetDecks, GetMatchHistory, GetReplay, GameFinished)
+}
+
+func (am *AccountManager) Process(event Event, dispatcher *Dispatcher) {
+	switch event.Type {
+	case PlayerLoggedIn:
+		account, err := am.store.GetAccount(event.Player.Id)
+
+		if err == ErrNotFound {
+			account = Account{
+				Id:      event.Player.Id,
+				Rating:  DefaultRating,
+				Created: am.clock.Now(),
+			}
+		} else if err != nil {
+			log.Printf("Could not load account %v: %v\n", event.Player.Id, err)
+			return
+		}
+
+		account.Name = event.Player.Name
+		event.Player.SetRating(account.Rating)
+
+		if err := am.store.SaveAccount(account); err != nil {
+			log.Printf("Could not save account %v: %v\n", account.Id, err)
+		}
+	case SaveDeck:
+		var deck DeckList
+
+		if err := event.Decode(&deck); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		if err := deck.Validate(); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		if deck.Id == "" {
+			deck.Id = uuid.New().String()
+		} else if saved, err := am.store.GetDeck(deck.Id); err == nil && saved.Owner != event.Player.Id {
+			event.Fail("Invalid deck")
+			return
+		}
+
+		deck.Owner = event.Player.Id
+
+		if err := am.store.SaveDeck(deck); err != nil {
+			log.Printf("Could not save deck %v: %v\n", deck.Id, err)
+			event.Fail("Could not save deck")
+			return
+		}
+
+		event.Ack(Response{
+			Type:    DeckSaved,
+			Payload: deck,
+		})
+	case GetDecks:
+		decks, err := am.store.GetDecks(event.Player.Id)
+
+		if err != nil {
+			log.Printf("Could not load decks for %v: %v\n", event.Player.Id, err)
+			event.Fail("Could not load decks")
+			return
+		}
+
+		event.Ack(Response{
+			Type:    Decks,
+			Payload: decks,
+		})
+	case GetMatchHistory:
+		var data MatchHistoryPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		if data.Limit <= 0 {
+			data.Limit = 20
+		}
+
+		history, err := am.store.GetMatchHistory(event.Player.Id, data.Limit)
+
+		if err != nil {
+			log.Printf("Could not load history for %v: %v\n", event.Player.Id, err)
+			event.Fail("Could not load match history")
+			return
+		}
+
+		event.Ack(Response{
+			Type:    MatchHistory,
+			Payload: history,
+		})
+	case GetReplay:
+		var id uuid.UUID
+		if err := event.Decode(&id); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		replay, err := am.store.GetReplay(id.String())
+		if err != nil && err != ErrNotFound {
+			log.Printf("Could not load replay %v: %v\n", id, err)
+			event.Fail("Could not load replay")
+			return
+		}
+
+		// only those who played the game get to watch it again
+		if err == ErrNotFound || !replay.playedBy(event.Player.Id) {
+			event.Fail("Replay not found")
+			return
+		}
+
+		event.Ack(Response{
+			Type:    GameReplay,
+			Payload: replay,
+		})
+	case GameFinished:
+		result := event.Payload.(GameResult)
+
+		record := GameRecord{
+			Id:       result.GameId.String(),
+			Mode:     result.Mode.Name,
+			Players:  []string{result.Winner.Id, result.Loser.Id},
+			Winner:   result.Winner.Id,
+			Started:  result.Started,
+			Duration: result.Duration,
+			Turns:    result.Turns,
+		}
+
+		if result.Replay != nil {
+			if err := am.store.SaveReplay(*result.Replay); err != nil {
+				log.Printf("Could not save replay of game %v: %v\n", record.Id, err)
+			} else {
+				record.Replay = result.Replay.Id
+			}
+		}
+
+		if err := am.store.SaveGame(record); err != nil {
+			log.Printf("Could not save game %v: %v\n", record.Id, err)
+		}
+
+		if result.Mode.Ranked {
+			am.updateRatings(result.Winner, result.Loser)
+		}
+	}
+}
+
+func (am *AccountManager) updateRatings(winner, loser *Player) {
+	won, err := am.store.GetAccount(winner.Id)
+	if err != nil {
+		log.Printf("Could not load account %v: %v\n", winner.Id, err)
+		return
+	}
+
+	lost, err := am.store.GetAccount(loser.Id)
+	if err != nil {
+		log.Printf("Could not load account %v: %v\n", loser.Id, err)
+		return
+	}
+
+	won.Rating, lost.Rating = Elo(won.Rating, lost.Rating)
+
+	for _, account := range []Account{won, lost} {
+		if err := am.store.SaveAccount(account); err != nil {
+			log.Printf("Could not save account %v: %v\n", account.Id, err)
+		}
+	}
+
+	winner.SetRating(won.Rating)
+	loser.SetRating(lost.Rating)
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCreatesAccountOnLogin(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "42", Name: "douglas"})
+
+	manager.Process(Event{
+		Type:   PlayerLoggedIn,
+		Player: player,
+	}, nil)
+
+	account, err := store.GetAccount("42")
+	if err != nil {
+		t.Fatalf("Expected account to be created, got %v", err)
+	}
+	if account.Name != "douglas" {
+		t.Errorf("Expected %v, got %v", "douglas", account.Name)
+	}
+}
+
+func TestAccountsAreCreatedByTheClock(t *testing.T) {
+	store := NewMemoryStore()
+	clock := newTestClock()
+	manager := NewAccountManagerWithClock(store, clock)
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "42"})
+
+	manager.Process(Event{
+		Type:   PlayerLoggedIn,
+		Player: player,
+	}, nil)
+
+	account, err := store.GetAccount("42")
+	if err != nil {
+		t.Fatalf("Expected account to be created, got %v", err)
+	}
+	if !account.Created.Equal(clock.Now()) {
+		t.Errorf("Expected %v, got %v", clock.Now(), account.Created)
+	}
+}
+
+func TestSaveDeck(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "42"})
+
+	go manager.Process(Event{
+		Type:   SaveDeck,
+		Player: player,
+		Payload: map[string]interface{}{
+			"Name": "aggro",
+			"Cards": []map[string]interface{}{
+				{"ManaCost": 1, "Damage": 2, "Health": 1},
+			},
+		},
+	}, nil)
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != DeckSaved {
+			t.Fatalf("Expected %v, got %v", DeckSaved, response.Type)
+		}
+
+		deck := response.Payload.(DeckList)
+		if deck.Owner != "42" {
+			t.Errorf("Expected %v, got %v", "42", deck.Owner)
+		}
+
+		decks, _ := store.GetDecks("42")
+		if len(decks) != 1 {
+			t.Errorf("Expected %v deck, got %v", 1, len(decks))
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected deck saved response")
+	}
+}
+
+func TestRejectsDecksWithCardsOutOfBounds(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "42"})
+
+	cases := map[string]CardSpec{
+		"mana cost": {ManaCost: -5, Damage: 1, Health: 1},
+		"damage":    {ManaCost: 1, Damage: -1, Health: 1},
+		"health":    {ManaCost: 1, Damage: 1, Health: MaxCardStat + 1},
+	}
+
+	for stat, card := range cases {
+		go manager.Process(Event{
+			Id:      "save",
+			Type:    SaveDeck,
+			Player:  player,
+			Payload: DeckList{Cards: []CardSpec{{ManaCost: 1, Damage: 1, Health: 1}, card}},
+		}, nil)
+
+		response := expectResponse(t, player, Nack)
+		if reason, _ := response.Payload.(string); !strings.HasPrefix(reason, "card 2: "+stat) {
+			t.Errorf("Expected the %v of card 2 to be rejected, got %v", stat, reason)
+		}
+	}
+
+	if decks, _ := store.GetDecks("42"); len(decks) != 0 {
+		t.Errorf("Expected no decks to be saved, got %v", len(decks))
+	}
+}
+
+func TestCannotOverwriteOthersDeck(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveDeck(DeckList{Id: "deck", Owner: "other", Cards: []CardSpec{{ManaCost: 1, Damage: 1, Health: 1}}})
+
+	manager := NewAccountManager(store)
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "42"})
+
+	go manager.Process(Event{
+		Type:   SaveDeck,
+		Player: player,
+		Payload: DeckList{
+			Id:    "deck",
+			Cards: []CardSpec{{ManaCost: 2, Damage: 2, Health: 2}},
+		},
+	}, nil)
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
+
+func TestRecordsFinishedGames(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	winner := NewTestPlayer()
+	winner.Identify(Identity{Id: "42"})
+
+	loser := NewTestPlayer()
+	loser.Identify(Identity{Id: "43"})
+
+	manager.Process(Event{
+		Type: GameFinished,
+		Payload: GameResult{
+			GameId:   uuid.New(),
+			Winner:   winner,
+			Loser:    loser,
+			Duration: time.Minute,
+			Turns:    12,
+		},
+	}, nil)
+
+	go manager.Process(Event{
+		Type:   GetMatchHistory,
+		Player: loser,
+	}, nil)
+
+	select {
+	case response := <-loser.Outgoing:
+		if response.Type != MatchHistory {
+			t.Fatalf("Expected %v, got %v", MatchHistory, response.Type)
+		}
+
+		history := response.Payload.([]GameRecord)
+		if len(history) != 1 {
+			t.Fatalf("Expected %v game, got %v", 1, len(history))
+		}
+		if history[0].Winner != "42" {
+			t.Errorf("Expected %v, got %v", "42", history[0].Winner)
+		}
+		if history[0].Turns != 12 {
+			t.Errorf("Expected %v, got %v", 12, history[0].Turns)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected match history response")
+	}
+}
+
+func TestUpdatesRatingsAfterGame(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	winner := NewTestPlayer()
+	winner.Identify(Identity{Id: "42"})
+
+	loser := NewTestPlayer()
+	loser.Identify(Identity{Id: "43"})
+
+	for _, player := range []*Player{winner, loser} {
+		manager.Process(Event{
+			Type:   PlayerLoggedIn,
+			Player: player,
+		}, nil)
+
+		if player.GetRating() != DefaultRating {
+			t.Errorf("Expected %v, got %v", DefaultRating, player.GetRating())
+		}
+	}
+
+	manager.Process(Event{
+		Type: GameFinished,
+		Payload: GameResult{
+			GameId: uuid.New(),
+			Mode:   RankedMode,
+			Winner: winner,
+			Loser:  loser,
+		},
+	}, nil)
+
+	expectedWinner, expectedLoser := Elo(DefaultRating, DefaultRating)
+
+	if winner.GetRating() != expectedWinner {
+		t.Errorf("Expected %v, got %v", expectedWinner, winner.GetRating())
+	}
+	if loser.GetRating() != expectedLoser {
+		t.Errorf("Expected %v, got %v", expectedLoser, loser.GetRating())
+	}
+
+	account, _ := store.GetAccount("42")
+	if account.Rating != expectedWinner {
+		t.Errorf("Expected %v, got %v", expectedWinner, account.Rating)
+	}
+}
+
+func TestCasualGamesDoNotChangeRatings(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	winner := NewTestPlayer()
+	winner.Identify(Identity{Id: "42"})
+
+	loser := NewTestPlayer()
+	loser.Identify(Identity{Id: "43"})
+
+	for _, player := range []*Player{winner, loser} {
+		manager.Process(Event{
+			Type:   PlayerLoggedIn,
+			Player: player,
+		}, nil)
+	}
+
+	manager.Process(Event{
+		Type: GameFinished,
+		Payload: GameResult{
+			GameId: uuid.New(),
+			Mode:   CasualMode,
+			Winner: winner,
+			Loser:  loser,
+		},
+	}, nil)
+
+	if winner.GetRating() != DefaultRating || loser.GetRating() != DefaultRating {
+		t.Errorf("Expected ratings to stay at %v, got %v and %v", DefaultRating, winner.GetRating(), loser.GetRating())
+	}
+
+	history, _ := store.GetMatchHistory("42", 0)
+	if len(history) != 1 || history[0].Mode != "casual" {
+		t.Errorf("Expected casual game to be recorded, got %+v", history)
+	}
+}
+
+func TestKeepsReplaysForThoseWhoPlayed(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewAccountManager(store)
+
+	winner := NewTestPlayer()
+	winner.Identify(Identity{Id: "42"})
+
+	loser := NewTestPlayer()
+	loser.Identify(Identity{Id: "43"})
+
+	outsider := NewTestPlayer()
+	outsider.Identify(Identity{Id: "44"})
+
+	replay := &Replay{
+		Id:      uuid.New().String(),
+		Players: []ReplayPlayer{{Id: "42"}, {Id: "43"}},
+		Actions: []ReplayAction{{Player: "43", Type: Concede}},
+	}
+
+	manager.Process(Event{
+		Type: GameFinished,
+		Payload: GameResult{
+			GameId: uuid.New(),
+			Mode:   CasualMode,
+			Winner: winner,
+			Loser:  loser,
+			Replay: replay,
+		},
+	}, nil)
+
+	history, _ := store.GetMatchHistory("42", 0)
+	if len(history) != 1 || history[0].Replay != replay.Id {
+		t.Fatalf("Expected the game to be recorded with replay %v, got %+v", replay.Id, history)
+	}
+
+	go manager.Process(Event{
+		Type:    GetReplay,
+		Player:  loser,
+		Payload: uuid.MustParse(history[0].Replay),
+	}, nil)
+
+	select {
+	case response := <-loser.Outgoing:
+		if response.Type != GameReplay {
+			t.Fatalf("Expected %v, got %v", GameReplay, response.Type)
+		}
+		if got := response.Payload.(Replay); got.Actions[0].Type != Concede {
+			t.Errorf("Expected the game's replay, got %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected replay response")
+	}
+
+	go manager.Process(Event{
+		Type:    GetReplay,
+		Player:  outsider,
+		Payload: uuid.MustParse(replay.Id),
+	}, nil)
+
+	select {
+	case response := <-outsider.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrExpiredToken    = errors.New("token expired")
+	ErrAlreadyLoggedIn = errors.New("already logged in")
+	// ErrLoggedInElsewhere is what players are told when their account
+	// logs in on another connection, which takes over from theirs
+	ErrLoggedInElsewhere = errors.New("logged in elsewhere")
+)
+
+type Identity struct {
+	Id   string
+	Name string
+}
+
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Name      string `json:"name"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// HMACAuthenticator verifies HS256 signed JWTs. The account id is read
+// from the "sub" claim and the display name from the "name" claim.
+// Tokens have to say when they expire in "exp", so a leaked one isn't
+// good forever.
+type HMACAuthenticator struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		secret: secret,
+		now:    time.Now,
+	}
+}
+
+func (a *HMACAuthenticator) Sign(identity Identity, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errors.New("tokens need a positive time to live")
+	}
+
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	now := a.now()
+	claims := tokenClaims{
+		Subject:   identity.Id,
+		Name:      identity.Name,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encodeSegment(header) + "." + encodeSegment(payload)
+	return unsigned + "." + encodeSegment(a.signature(unsigned)), nil
+}
+
+func (a *HMACAuthenticator) Authenticate(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, a.signature(parts[0]+"."+parts[1])) {
+		return Identity{}, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := unmarshalSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return Identity{}, ErrInvalidToken
+	}
+
+	var claims tokenClaims
+	if err := unmarshalSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if a.now().Unix() >= claims.ExpiresAt {
+		return Identity{}, ErrExpiredToken
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Subject
+	}
+
+	return Identity{Id: claims.Subject, Name: name}, nil
+}
+
+func (a *HMACAuthenticator) signature(unsigned string) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
+
+func unmarshalSegment(segment string, v interface{}) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticatesSignedToken(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+
+	token, err := auth.Sign(Identity{Id: "42", Name: "douglas"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Expected token to be valid, got %v", err)
+	}
+
+	if identity.Id != "42" {
+		t.Errorf("Expected %v, got %v", "42", identity.Id)
+	}
+	if identity.Name != "douglas" {
+		t.Errorf("Expected %v, got %v", "douglas", identity.Name)
+	}
+}
+
+func TestRejectsTokenSignedWithOtherSecret(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+	other := NewHMACAuthenticator([]byte("other"))
+
+	token, _ := other.Sign(Identity{Id: "42"}, time.Hour)
+
+	if _, err := auth.Authenticate(token); err != ErrInvalidToken {
+		t.Errorf("Expected %v, got %v", ErrInvalidToken, err)
+	}
+}
+
+func TestRejectsMalformedToken(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+
+	for _, token := range []string{"", "abc", "a.b.c", "a.b.c.d"} {
+		if _, err := auth.Authenticate(token); err != ErrInvalidToken {
+			t.Errorf("Expected %v for %q, got %v", ErrInvalidToken, token, err)
+		}
+	}
+}
+
+func TestRejectsExpiredToken(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+	token, _ := auth.Sign(Identity{Id: "42"}, time.Minute)
+
+	auth.now = func() time.Time {
+		return time.Now().Add(time.Hour)
+	}
+
+	if _, err := auth.Authenticate(token); err != ErrExpiredToken {
+		t.Errorf("Expected %v, got %v", ErrExpiredToken, err)
+	}
+}
+
+func TestNameDefaultsToAccountId(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+	token, _ := auth.Sign(Identity{Id: "42"}, time.Hour)
+
+	identity, err := auth.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Name != "42" {
+		t.Errorf("Expected %v, got %v", "42", identity.Name)
+	}
+}
+
+func TestRejectsTokenWithoutExpiry(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+
+	if _, err := auth.Sign(Identity{Id: "42"}, 0); err == nil {
+		t.Error("Expected tokens that never expire not to be signed")
+	}
+
+	unsigned := encodeSegment([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encodeSegment([]byte(`{"sub":"42"}`))
+	token := unsigned + "." + encodeSegment(auth.signature(unsigned))
+
+	if _, err := auth.Authenticate(token); err != ErrInvalidToken {
+		t.Errorf("Expected %v, got %v", ErrInvalidToken, err)
+	}
+}
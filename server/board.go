@@ -1,13 +1,59 @@
 package server
 
+// MaxBoardSize is how many minions a board holds unless the rules say
+// otherwise.
+const MaxBoardSize = 7
+
 type Board struct {
 	Defenders map[string]ActiveDefender
+
+	// size is how many minions fit, zero for MaxBoardSize
+	size int
 }
 
 func NewBoard() *Board {
+	return NewBoardOfSize(0)
+}
+
+func NewBoardOfSize(size int) *Board {
 	return &Board{
 		Defenders: make(map[string]ActiveDefender),
+		size:      size,
+	}
+}
+
+// Snapshot copies the board and its minions, so it can be sent to
+// players while the game keeps changing the original.
+func (b *Board) Snapshot() *Board {
+	defenders := make(map[string]ActiveDefender)
+	for id, defender := range b.Defenders {
+		defenders[id] = snapshotDefender(defender)
+	}
+	return &Board{Defenders: defenders, size: b.size}
+}
+
+// snapshotDefender copies a minion as it is now, so its health and
+// status don't change under whoever it's sent to.
+func snapshotDefender(defender ActiveDefender) ActiveDefender {
+	minion, ok := defender.(*ActiveMinion)
+	if !ok {
+		return defender
+	}
+
+	copied := *minion
+	if card, ok := minion.Defender.(*MinionCard); ok {
+		copiedCard := *card
+		copied.Defender = &copiedCard
+	}
+	return &copied
+}
+
+// Size is how many minions fit on the board.
+func (b *Board) Size() int {
+	if b.size == 0 {
+		return MaxBoardSize
 	}
+	return b.size
 }
 
 func (b *Board) Remove(minion Defender) {
@@ -97,7 +143,7 @@ func (t *ActiveMinion) Attack(defender ActiveDefender) {
 }
 
 func (b *Board) PlaceCard(card Defender) ActiveDefender {
-	if len(b.Defenders) >= 7 {
+	if len(b.Defenders) >= b.Size() {
 		return nil
 	}
 
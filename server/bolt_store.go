@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	accountsBucket = []byte("accounts")
+	decksBucket    = []byte("decks")
+	ownersBucket   = []byte("deck_owners")
+	gamesBucket    = []byte("games")
+	historyBucket  = []byte("history")
+	replaysBucket  = []byte("replays")
+)
+
+// BoltStore keeps everything in a single BoltDB file. Values are stored
+// as JSON, while deck owners and match history are kept as nested
+// buckets of keys pointing back to decks and games.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		buckets := [][]byte{
+			accountsBucket,
+			decksBucket,
+			ownersBucket,
+			gamesBucket,
+			historyBucket,
+			replaysBucket,
+		}
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) SaveAccount(account Account) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(accountsBucket), []byte(account.Id), account)
+	})
+}
+
+func (s *BoltStore) GetAccount(id string) (Account, error) {
+	var account Account
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(accountsBucket), []byte(id), &account)
+	})
+
+	return account, err
+}
+
+func (s *BoltStore) SaveDeck(deck DeckList) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx.Bucket(decksBucket), []byte(deck.Id), deck); err != nil {
+			return err
+		}
+
+		owner, err := tx.Bucket(ownersBucket).CreateBucketIfNotExists([]byte(deck.Owner))
+		if err != nil {
+			return err
+		}
+		return owner.Put([]byte(deck.Id), []byte{})
+	})
+}
+
+func (s *BoltStore) GetDeck(id string) (DeckList, error) {
+	var deck DeckList
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(decksBucket), []byte(id), &deck)
+	})
+
+	return deck, err
+}
+
+func (s *BoltStore) GetDecks(owner string) ([]DeckList, error) {
+	decks := make([]DeckList, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(ownersBucket).Bucket([]byte(owner))
+		if ids == nil {
+			return nil
+		}
+
+		return ids.ForEach(func(id, _ []byte) error {
+			var deck DeckList
+			if err := get(tx.Bucket(decksBucket), id, &deck); err != nil {
+				return err
+			}
+			decks = append(decks, deck)
+			return nil
+		})
+	})
+
+	return decks, err
+}
+
+func (s *BoltStore) SaveGame(record GameRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		games := tx.Bucket(gamesBucket)
+
+		seq, err := games.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		if err := put(games, key, record); err != nil {
+			return err
+		}
+
+		for _, player := range record.Players {
+			history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(player))
+			if err != nil {
+				return err
+			}
+			if err := history.Put(key, []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStore) GetMatchHistory(accountId string, limit int) ([]GameRecord, error) {
+	records := make([]GameRecord, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(accountId))
+		if history == nil {
+			return nil
+		}
+
+		// keys are sequential, so walking backwards gives most recent first
+		cursor := history.Cursor()
+		for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+			if limit > 0 && len(records) == limit {
+				break
+			}
+
+			var record GameRecord
+			if err := get(tx.Bucket(gamesBucket), key, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+
+		return nil
+	})
+
+	return records, err
+}
+
+func (s *BoltStore) SaveReplay(replay Replay) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(replaysBucket), []byte(replay.Id), replay)
+	})
+}
+
+func (s *BoltStore) GetReplay(id string) (Replay, error) {
+	var replay Replay
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(replaysBucket), []byte(id), &replay)
+	})
+
+	return replay, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func put(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+func get(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data := bucket.Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, value)
+}
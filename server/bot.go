@@ -0,0 +1,283 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type BotState struct {
+	GameId      uuid.UUID
+	Id          uuid.UUID
+	Mana        int
+	Health      int
+	EnemyHealth int
+	Hand        []HasManaCost
+	Board       map[string]ActiveDefender
+	Enemy       map[string]ActiveDefender
+	// BoardSize is how many minions fit on the board, zero for
+	// MaxBoardSize
+	BoardSize int
+}
+
+// Bot is a virtual player without a socket. It reads the same responses
+// a client would and answers them with events chosen by its strategy.
+type Bot struct {
+	Player *Player
+
+	strategy   Strategy
+	dispatcher *Dispatcher
+	state      BotState
+	turn       bool
+
+	// played is the ids of the cards the bot got onto its board
+	played []string
+
+	// responses are buffered until the bot gets to them, so whoever is
+	// sending never waits on the bot dispatching its own events
+	mutex   sync.Mutex
+	pending []Response
+	wake    chan bool
+	done    chan bool
+}
+
+func NewBotPlayer() *Player {
+	return &Player{
+		Id:   "bot-" + uuid.New().String(),
+		Name: "Bot",
+		Bot:  true,
+
+		Incoming: make(chan Event),
+		Outgoing: make(chan Response),
+
+		done: make(chan bool),
+	}
+}
+
+func NewBot(strategy Strategy, dispatcher *Dispatcher) *Bot {
+	bot := &Bot{
+		Player: NewBotPlayer(),
+
+		strategy:   strategy,
+		dispatcher: dispatcher,
+		state: BotState{
+			Board: make(map[string]ActiveDefender),
+			Enemy: make(map[string]ActiveDefender),
+		},
+
+		wake: make(chan bool, 1),
+		done: make(chan bool),
+	}
+
+	go bot.read()
+	go bot.run()
+
+	return bot
+}
+
+func (b *Bot) Done() <-chan bool {
+	return b.done
+}
+
+// Played waits for the bot to be done, and then lists the ids of the
+// cards it played.
+func (b *Bot) Played() []string {
+	<-b.done
+	return b.played
+}
+
+func (b *Bot) read() {
+	for {
+		select {
+		case response := <-b.Player.Outgoing:
+			b.mutex.Lock()
+			b.pending = append(b.pending, response)
+			b.mutex.Unlock()
+
+			select {
+			case b.wake <- true:
+			default:
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Bot) run() {
+	for {
+		select {
+		case <-b.wake:
+			b.mutex.Lock()
+			pending := b.pending
+			b.pending = nil
+			b.mutex.Unlock()
+
+			for _, response := range pending {
+				if !b.handle(response) {
+					close(b.done)
+					return
+				}
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// handle reacts to a single response, returning false once there's
+// nothing left for the bot to do.
+func (b *Bot) handle(response Response) bool {
+	switch response.Type {
+	case MatchFound:
+		id := response.Payload.(uuid.UUID)
+
+		b.dispatch(Event{
+			Type:    MatchConfirmed,
+			Payload: id.String(),
+		})
+	case MatchCanceled, GameOver, GameAborted:
+		return false
+	case StartingHand:
+		payload := response.Payload.(StartingHandPayload)
+
+		b.state.Id = payload.Id
+		b.state.GameId = payload.GameId
+		b.state.Hand = append([]HasManaCost{}, payload.Cards...)
+		b.state.BoardSize = payload.BoardSize
+
+		b.dispatch(Event{
+			Type: CardsDiscarded,
+			Payload: CardsDiscardedPayload{
+				GameId: payload.GameId.String(),
+				Cards:  b.strategy.Mulligan(b.state.Hand),
+			},
+		})
+	case WaitOtherPlayers:
+		if hand, ok := response.Payload.([]HasManaCost); ok {
+			b.state.Hand = append([]HasManaCost{}, hand...)
+		}
+	case StartTurn:
+		payload := response.Payload.(TurnPayload)
+
+		b.turn = true
+		b.state.GameId = payload.GameId
+		b.state.Mana = payload.Mana
+
+		if payload.Card != nil {
+			b.state.Hand = append(b.state.Hand, payload.Card)
+		}
+
+		// the minions sent are copies, so ready them as the game did
+		for _, minion := range b.state.Board {
+			minion.SetStatus(&Ready{})
+		}
+
+		b.act()
+	case WaitTurn:
+		b.turn = false
+	case CardPlayed:
+		payload := response.Payload.(CardPlayedPayload)
+
+		if payload.Card == nil {
+			break
+		}
+
+		if payload.Player != b.state.Id {
+			b.state.Enemy[payload.Card.GetId()] = payload.Card
+			break
+		}
+
+		for idx, card := range b.state.Hand {
+			if card.GetId() == payload.Card.GetId() {
+				b.state.Hand = append(b.state.Hand[:idx], b.state.Hand[idx+1:]...)
+				break
+			}
+		}
+
+		b.state.Mana = payload.Mana
+		b.state.Board[payload.Card.GetId()] = payload.Card
+		b.played = append(b.played, payload.Card.GetId())
+
+		if b.turn {
+			b.act()
+		}
+	case AttackResult:
+		boards := response.Payload.([]*Board)
+
+		b.state.Board = copyDefenders(boards[0].Defenders)
+		b.state.Enemy = copyDefenders(boards[1].Defenders)
+
+		if b.turn {
+			b.act()
+		}
+	case DamageTaken:
+		payload := response.Payload.(DamageTakenPayload)
+
+		if payload.PlayerId == b.state.Id.String() {
+			b.state.Health = payload.Health
+			break
+		}
+
+		b.state.EnemyHealth = payload.Health
+
+		if b.turn {
+			b.act()
+		}
+	case Error:
+		// whatever we tried didn't work, so don't try again this turn
+		if b.turn {
+			b.endTurn()
+		}
+	}
+
+	return true
+}
+
+func (b *Bot) act() {
+	action := b.strategy.Act(b.state)
+	gameId := b.state.GameId.String()
+
+	switch action.Type {
+	case PlayCard:
+		b.dispatch(Event{
+			Type: PlayCard,
+			Payload: PlayCardPayload{
+				GameId: gameId,
+				Card:   action.Card,
+			},
+		})
+	case Attack, AttackPlayer:
+		b.dispatch(Event{
+			Type: action.Type,
+			Payload: AttackPayload{
+				GameId:   gameId,
+				Attacker: action.Attacker,
+				Target:   action.Target,
+			},
+		})
+	default:
+		b.endTurn()
+	}
+}
+
+func (b *Bot) endTurn() {
+	b.turn = false
+	b.dispatch(Event{
+		Type:    EndTurn,
+		Payload: b.state.GameId.String(),
+	})
+}
+
+func (b *Bot) dispatch(event Event) {
+	event.Player = b.Player
+	b.dispatcher.Emit(event)
+}
+
+func copyDefenders(defenders map[string]ActiveDefender) map[string]ActiveDefender {
+	copied := make(map[string]ActiveDefender)
+	for id, defender := range defenders {
+		copied[id] = defender
+	}
+	return copied
+}
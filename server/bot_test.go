@@ -0,0 +1,189 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ResultHandler struct {
+	Results chan GameResult
+}
+
+func (h *ResultHandler) Process(event Event, dispatcher *Dispatcher) {
+	if event.Type == GameFinished {
+		h.Results <- event.Payload.(GameResult)
+	}
+}
+
+var quickMode = GameMode{
+	Name: "quick",
+	Rules: Rules{
+		DeckSize:       20,
+		StartingHealth: 10,
+		StartingHand:   3,
+		TurnDuration:   time.Minute,
+	},
+}
+
+func TestBotsPlayFullGame(t *testing.T) {
+	dispatcher := NewDispatcher()
+	results := &ResultHandler{Results: make(chan GameResult, 1)}
+
+	dispatcher.Register <- NewMatchmaker()
+	dispatcher.Register <- NewGameManager()
+	dispatcher.Register <- results
+
+	random := NewBot(NewRandomStrategy(1), dispatcher)
+	greedy := NewBot(NewGreedyStrategy(), dispatcher)
+
+	dispatcher.Dispatch <- Event{
+		Type: CreateMatch,
+		Payload: MatchPayload{
+			Players: []*Player{random.Player, greedy.Player},
+			Mode:    quickMode,
+		},
+	}
+
+	select {
+	case result := <-results.Results:
+		if result.Winner != random.Player && result.Winner != greedy.Player {
+			t.Errorf("Expected a bot to win, got %v", result.Winner)
+		}
+		if result.Turns == 0 {
+			t.Error("Expected turns to be played")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected game to finish")
+	}
+
+	for _, bot := range []*Bot{random, greedy} {
+		select {
+		case <-bot.Done():
+		case <-time.After(time.Second):
+			t.Error("Expected bot to stop after game over")
+		}
+	}
+}
+
+func TestPlayAgainstBot(t *testing.T) {
+	manager := NewQueueManager()
+	dispatcher := NewTestDispatcher()
+
+	player := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  player,
+		Payload: QueueUpPayload{Mode: AIMode.Name},
+	}, dispatcher)
+
+	<-player.Outgoing // wait for match
+
+	select {
+	case event := <-dispatcher.Dispatch:
+		payload := event.Payload.(MatchPayload)
+
+		if payload.Players[0] != player {
+			t.Errorf("Expected %v, got %v", player, payload.Players[0])
+		}
+		if !payload.Players[1].Bot {
+			t.Error("Expected opponent to be a bot")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected match against bot")
+	}
+}
+
+func TestBackfillsQueueWithBot(t *testing.T) {
+	backfilled := GameMode{
+		Name:     "backfilled",
+		Backfill: 10 * time.Millisecond,
+		Rules:    DefaultRules,
+	}
+
+	manager := NewQueueManager(backfilled)
+	dispatcher := NewTestDispatcher()
+
+	player := NewTestPlayer()
+	player.SetRating(1500)
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  player,
+		Payload: QueueUpPayload{Mode: backfilled.Name},
+	}, dispatcher)
+
+	<-player.Outgoing // wait for match
+
+	select {
+	case event := <-dispatcher.Dispatch:
+		payload := event.Payload.(MatchPayload)
+		bot := payload.Players[1]
+
+		if !bot.Bot {
+			t.Fatal("Expected opponent to be a bot")
+		}
+		if bot.GetRating() != 1500 {
+			t.Errorf("Expected bot to take player's rating, got %v", bot.GetRating())
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected queue to be backfilled")
+	}
+}
+
+func TestBotsAreNotRequeued(t *testing.T) {
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewQueueManager()
+
+	player := NewTestPlayer()
+	bot := NewBot(NewGreedyStrategy(), dispatcher)
+
+	match := NewMatch([]*Player{player, bot.Player}, CasualMode, time.Minute)
+	dispatcher.Register <- match
+
+	dispatcher.Dispatch <- Event{
+		Type:    AskConfirmation,
+		Payload: match.Id,
+	}
+
+	<-player.Outgoing // match found
+
+	dispatcher.Dispatch <- Event{
+		Type:    MatchDeclined,
+		Player:  player,
+		Payload: match.Id.String(),
+	}
+
+	<-player.Outgoing // match canceled
+
+	select {
+	case <-bot.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected bot to stop when match is canceled")
+	}
+}
+
+func TestBotsOnlyCountCardsThatReachTheBoard(t *testing.T) {
+	bot := NewBot(NewGreedyStrategy(), NewDispatcher())
+
+	id := uuid.New()
+	card := NewBoard().PlaceCard(NewMinion(1, 1, 1))
+
+	for _, response := range []Response{
+		{Type: StartingHand, Payload: StartingHandPayload{Id: id}},
+		// the board was full, so the card was burned
+		{Type: CardPlayed, Payload: CardPlayedPayload{Player: id}},
+		{Type: CardPlayed, Payload: CardPlayedPayload{Player: uuid.New(), Card: NewBoard().PlaceCard(NewMinion(1, 1, 1))}},
+		{Type: CardPlayed, Payload: CardPlayedPayload{Player: id, Card: card}},
+		{Type: GameOver},
+	} {
+		bot.Player.Outgoing <- response
+	}
+
+	played := bot.Played()
+	if len(played) != 1 || played[0] != card.GetId() {
+		t.Errorf("Expected only %v to be played, got %v", card.GetId(), played)
+	}
+}
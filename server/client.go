@@ -1,55 +1,387 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+var ErrClientClosed = errors.New("client closed")
+
+// NackError is why the server didn't apply a request.
+type NackError struct {
+	Reason string
+}
+
+func (e *NackError) Error() string {
+	return e.Reason
+}
+
+// Client is a connection to a server from the player's side. Responses
+// arrive on Incoming with their payloads decoded into the types listed
+// in DecodePayload, and Incoming is closed once the connection ends.
+// It has to be read even while waiting on a Request.
 type Client struct {
-	Incoming chan Response // incoming from server
-	Outgoing chan Event    // outgoing to server
+	Incoming <-chan Response
+
+	socket   *websocket.Conn
+	codec    Codec
+	incoming chan Response
+	done     chan bool
+
+	// pending are the requests waiting on their Ack or Nack, by id
+	pending      map[string]chan Response
+	pendingMutex sync.Mutex
+
+	writing  sync.Mutex
+	closing  sync.Once
+	errMutex sync.Mutex
+	err      error
 }
 
-func NewClient(addr string) *Client {
+// Connect dials the server at addr, which may carry a ?token= to log in
+// right away, announcing ProtocolVersion and Capabilities. ctx only
+// bounds dialing, use Close to hang up.
+func Connect(ctx context.Context, addr string) (*Client, error) {
+	return ConnectWith(ctx, addr, JSONCodec)
+}
+
+// ConnectWith is Connect asking the server to speak codec, which falls
+// back to JSON if the server doesn't know it.
+func ConnectWith(ctx context.Context, addr string, codec Codec) (*Client, error) {
+	target, err := url.Parse("ws://" + addr)
+	if err != nil {
+		return nil, err
+	}
+
+	query := target.Query()
+	Handshake{Version: ProtocolVersion, Capabilities: Capabilities}.Query(query)
+	target.RawQuery = query.Encode()
+
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{codec.Subprotocol()}
+
+	socket, response, err := dialer.DialContext(ctx, target.String(), nil)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusUpgradeRequired {
+			return nil, ErrUnsupportedVersion
+		}
+		return nil, err
+	}
+
+	incoming := make(chan Response)
+
 	client := &Client{
-		Incoming: make(chan Response),
-		Outgoing: make(chan Event),
+		Incoming: incoming,
+
+		socket:   socket,
+		codec:    codecFor(socket.Subprotocol()),
+		incoming: incoming,
+		done:     make(chan bool),
+		pending:  make(map[string]chan Response),
 	}
 
-	client.Connect(addr)
-	return client
+	go client.read()
+
+	return client, nil
 }
 
-func (c *Client) Connect(addr string) {
-	socket, _, err := websocket.DefaultDialer.Dial("ws://"+addr, nil)
+func (c *Client) read() {
+	defer close(c.incoming)
 
-	if err != nil {
-		log.Printf("Could not connect to server at %v\n", "ws://"+addr)
-		return
+	for {
+		_, data, err := c.socket.ReadMessage()
+		if err != nil {
+			c.fail(err)
+			c.Close()
+			return
+		}
+
+		response, err := c.codec.DecodeResponse(data)
+		if err != nil {
+			c.fail(err)
+			c.Close()
+			return
+		}
+
+		if c.answer(response) {
+			continue
+		}
+
+		select {
+		case c.incoming <- response:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// answer hands Acks and Nacks to the request waiting on them, if there
+// is one.
+func (c *Client) answer(response Response) bool {
+	if response.Type != Ack && response.Type != Nack {
+		return false
 	}
 
-	go func() {
-		defer socket.Close()
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
 
-		for {
-			var response Response
-			err := socket.ReadJSON(&response)
+	waiting, ok := c.pending[response.RequestId]
+	if !ok {
+		return false
+	}
 
-			if err != nil {
-				break
-			}
+	// only the first answer counts, the server repeats it for retries
+	select {
+	case waiting <- response:
+	default:
+	}
+	return true
+}
 
-			c.Incoming <- response
+// Next waits for the next response.
+func (c *Client) Next(ctx context.Context) (Response, error) {
+	select {
+	case response, ok := <-c.Incoming:
+		if !ok {
+			return Response{}, c.Err()
 		}
-	}()
+		return response, nil
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
+}
+
+// Err is why the connection ended, if it has.
+func (c *Client) Err() error {
+	c.errMutex.Lock()
+	defer c.errMutex.Unlock()
+
+	if c.err == nil {
+		select {
+		case <-c.done:
+			return ErrClientClosed
+		default:
+		}
+	}
+	return c.err
+}
+
+func (c *Client) fail(err error) {
+	c.errMutex.Lock()
+	defer c.errMutex.Unlock()
 
-	go func() {
-		for {
-			select {
-			case event := <-c.Outgoing:
-				socket.WriteJSON(event)
-			}
+	select {
+	case <-c.done:
+		// errors after closing are just the socket going away
+	default:
+		if c.err == nil {
+			c.err = err
 		}
+	}
+}
+
+func (c *Client) Close() error {
+	var err error
+	c.closing.Do(func() {
+		close(c.done)
+		err = c.socket.Close()
+	})
+	return err
+}
+
+// Send sends event without waiting for the server to answer. Writing it
+// takes no longer than ctx allows, nor than the WriteTimeout in
+// DefaultHeartbeat.
+func (c *Client) Send(ctx context.Context, event Event) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	data, err := c.codec.EncodeEvent(event)
+	if err != nil {
+		return err
+	}
+
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	deadline := time.Now().Add(DefaultHeartbeat.WriteTimeout)
+	if until, ok := ctx.Deadline(); ok && until.Before(deadline) {
+		deadline = until
+	}
+	c.socket.SetWriteDeadline(deadline)
+
+	return c.socket.WriteMessage(c.codec.MessageType(), data)
+}
+
+// Request sends event and waits for the server to apply it, giving it
+// an id first if it has none. It returns a *NackError if the server
+// didn't apply it. The Ack or Nack goes to Request rather than
+// Incoming, other responses to event still arrive on Incoming.
+func (c *Client) Request(ctx context.Context, event Event) error {
+	if event.Id == "" {
+		event.Id = uuid.NewString()
+	}
+
+	answer := make(chan Response, 1)
+
+	c.pendingMutex.Lock()
+	c.pending[event.Id] = answer
+	c.pendingMutex.Unlock()
+
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, event.Id)
+		c.pendingMutex.Unlock()
 	}()
+
+	if err := c.Send(ctx, event); err != nil {
+		return err
+	}
+
+	select {
+	case response := <-answer:
+		if response.Type == Nack {
+			reason, _ := response.Payload.(string)
+			return &NackError{Reason: reason}
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send is Send for the helpers below, bounded by the write timeout only.
+func (c *Client) send(event Event) error {
+	return c.Send(context.Background(), event)
+}
+
+func (c *Client) Login(token string) error {
+	return c.send(Event{Type: Login, Payload: token})
+}
+
+func (c *Client) QueueUp(mode string) error {
+	return c.send(Event{Type: QueueUp, Payload: QueueUpPayload{Mode: mode}})
+}
+
+func (c *Client) Dequeue() error {
+	return c.send(Event{Type: Dequeue})
+}
+
+func (c *Client) ConfirmMatch(matchId uuid.UUID) error {
+	return c.send(Event{Type: MatchConfirmed, Payload: matchId.String()})
+}
+
+func (c *Client) DeclineMatch(matchId uuid.UUID) error {
+	return c.send(Event{Type: MatchDeclined, Payload: matchId.String()})
+}
+
+func (c *Client) Discard(gameId uuid.UUID, cards []string) error {
+	return c.send(Event{
+		Type: CardsDiscarded,
+		Payload: CardsDiscardedPayload{
+			GameId: gameId.String(),
+			Cards:  cards,
+		},
+	})
+}
+
+func (c *Client) PlayCard(gameId uuid.UUID, card string) error {
+	return c.send(Event{
+		Type: PlayCard,
+		Payload: PlayCardPayload{
+			GameId: gameId.String(),
+			Card:   card,
+		},
+	})
+}
+
+func (c *Client) Attack(gameId uuid.UUID, attacker, target string) error {
+	return c.send(Event{
+		Type: Attack,
+		Payload: AttackPayload{
+			GameId:   gameId.String(),
+			Attacker: attacker,
+			Target:   target,
+		},
+	})
+}
+
+func (c *Client) AttackPlayer(gameId uuid.UUID, attacker string) error {
+	return c.send(Event{
+		Type: AttackPlayer,
+		Payload: AttackPayload{
+			GameId:   gameId.String(),
+			Attacker: attacker,
+		},
+	})
+}
+
+func (c *Client) EndTurn(gameId uuid.UUID) error {
+	return c.send(Event{Type: EndTurn, Payload: gameId.String()})
+}
+
+func (c *Client) Concede(gameId uuid.UUID) error {
+	return c.send(Event{Type: Concede, Payload: gameId.String()})
+}
+
+func (c *Client) SaveDeck(deck DeckList) error {
+	return c.send(Event{Type: SaveDeck, Payload: deck})
+}
+
+func (c *Client) GetDecks() error {
+	return c.send(Event{Type: GetDecks})
+}
+
+func (c *Client) GetMatchHistory(limit int) error {
+	return c.send(Event{Type: GetMatchHistory, Payload: MatchHistoryPayload{Limit: limit}})
+}
+
+func (c *Client) GetReplay(replayId string) error {
+	return c.send(Event{Type: GetReplay, Payload: replayId})
+}
+
+func (c *Client) CreateLobby(mode string) error {
+	return c.send(Event{Type: CreateLobby, Payload: CreateLobbyPayload{Mode: mode}})
+}
+
+func (c *Client) JoinLobby(code string) error {
+	return c.send(Event{Type: JoinLobby, Payload: JoinLobbyPayload{Code: code}})
+}
+
+func (c *Client) LeaveLobby() error {
+	return c.send(Event{Type: LeaveLobby})
+}
+
+func (c *Client) SelectDeck(deckId string) error {
+	return c.send(Event{Type: SelectDeck, Payload: SelectDeckPayload{DeckId: deckId}})
+}
+
+func (c *Client) LobbyReady() error {
+	return c.send(Event{Type: LobbyReady})
+}
+
+func (c *Client) ChallengePlayer(accountId, mode string) error {
+	return c.send(Event{Type: ChallengePlayer, Payload: ChallengePayload{AccountId: accountId, Mode: mode}})
+}
+
+func (c *Client) AcceptChallenge(challengeId string) error {
+	return c.send(Event{Type: AcceptChallenge, Payload: ChallengeAnswerPayload{ChallengeId: challengeId}})
+}
+
+func (c *Client) DeclineChallenge(challengeId string) error {
+	return c.send(Event{Type: DeclineChallenge, Payload: ChallengeAnswerPayload{ChallengeId: challengeId}})
 }
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The views below are how clients see the game's payloads, which hold
+// interfaces on the server that can't be decoded as they are.
+
+type CardView struct {
+	Id       uuid.UUID
+	ManaCost int
+	Damage   int
+	Health   int
+}
+
+// UnmarshalJSON accepts cards in hand as well as minions on a board,
+// which nest the card under Defender.
+func (c *CardView) UnmarshalJSON(data []byte) error {
+	type card CardView
+
+	var minion struct {
+		Defender *card
+	}
+	if err := json.Unmarshal(data, &minion); err != nil {
+		return err
+	}
+
+	if minion.Defender != nil {
+		*c = CardView(*minion.Defender)
+		return nil
+	}
+
+	return json.Unmarshal(data, (*card)(c))
+}
+
+type BoardView struct {
+	Defenders map[string]CardView
+}
+
+type StartingHandView struct {
+	Id        uuid.UUID
+	GameId    uuid.UUID
+	Cards     []CardView
+	Health    int
+	Duration  time.Duration
+	BoardSize int
+}
+
+type TurnView struct {
+	GameId      uuid.UUID
+	Duration    time.Duration
+	Card        *CardView
+	Mana        int
+	CardsLeft   int
+	CardsInHand int
+}
+
+type CardPlayedView struct {
+	Mana   int
+	Player uuid.UUID
+	Card   *CardView
+	GameId uuid.UUID
+}
+
+type GamePlayerView struct {
+	Id      uuid.UUID
+	Health  int
+	Mana    int
+	MaxMana int
+	Board   BoardView
+	Hand    []CardView
+	Fatigue int
+}
+
+type GameResumedView struct {
+	GameId      uuid.UUID
+	Id          uuid.UUID
+	Hand        []CardView
+	Health      int
+	Mana        int
+	Board       BoardView
+	EnemyHealth int
+	EnemyBoard  BoardView
+	Current     bool
+	Choosing    bool
+}
+
+type GameOverView struct {
+	Winner GamePlayerView
+	Loser  GamePlayerView
+}
+
+// DecodePayload decodes the payload of a response of the given type:
+//
+//	Welcome                       WelcomePayload
+//	LoggedIn                      Identity
+//	WaitForMatch, LobbyClosed,
+//	ChallengeSent,
+//	ChallengeDeclined, Nack,
+//	Error                         string
+//	PayloadRejected               PayloadError
+//	MatchFound, GameAborted,
+//	OpponentReturned              uuid.UUID
+//	WaitOtherPlayers              []CardView, once the hand is final
+//	StartingHand                  StartingHandView
+//	StartTurn, WaitTurn           TurnView
+//	CardPlayed                    CardPlayedView
+//	AttackResult                  []BoardView, own board first
+//	DamageTaken                   DamageTakenPayload
+//	GameOver                      GameOverView
+//	GameResumed                   GameResumedView
+//	OpponentLeft                  OpponentLeftPayload
+//	MatchCanceled                 MatchCanceledPayload
+//	DeckSaved                     DeckList
+//	Decks                         []DeckList
+//	MatchHistory                  []GameRecord
+//	GameReplay                    Replay
+//	LobbyUpdated                  LobbyPayload
+//	ChallengeReceived             ChallengeReceivedPayload
+//	Maintenance                   MaintenancePayload
+//
+// Payloads that are missing are nil and anything else is left as it
+// decodes into interface{}.
+func DecodePayload(kind ResponseType, data []byte) (interface{}, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	schema, ok := responseSchemas[kind]
+	if !ok {
+		var value interface{}
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+
+	payload := reflect.New(reflect.TypeOf(schema))
+	if err := json.Unmarshal(data, payload.Interface()); err != nil {
+		return nil, err
+	}
+
+	// payloads are handed out by value, the way the server sends them
+	return payload.Elem().Interface(), nil
+}
+
+// responseSchemas holds the type each response's payload decodes into,
+// as a zero value.
+var responseSchemas = map[ResponseType]interface{}{
+	Welcome:           WelcomePayload{},
+	LoggedIn:          Identity{},
+	WaitForMatch:      "",
+	LobbyClosed:       "",
+	ChallengeSent:     "",
+	ChallengeDeclined: "",
+	Nack:              "",
+	Error:             "",
+	PayloadRejected:   PayloadError{},
+	MatchFound:        uuid.UUID{},
+	GameAborted:       uuid.UUID{},
+	OpponentReturned:  uuid.UUID{},
+	WaitOtherPlayers:  []CardView{},
+	StartingHand:      StartingHandView{},
+	StartTurn:         TurnView{},
+	WaitTurn:          TurnView{},
+	CardPlayed:        CardPlayedView{},
+	AttackResult:      []BoardView{},
+	DamageTaken:       DamageTakenPayload{},
+	GameOver:          GameOverView{},
+	GameResumed:       GameResumedView{},
+	OpponentLeft:      OpponentLeftPayload{},
+	MatchCanceled:     MatchCanceledPayload{},
+	DeckSaved:         DeckList{},
+	Decks:             []DeckList{},
+	MatchHistory:      []GameRecord{},
+	GameReplay:        Replay{},
+	LobbyUpdated:      LobbyPayload{},
+	ChallengeReceived: ChallengeReceivedPayload{},
+	Maintenance:       MaintenancePayload{},
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// awaitResponse skips responses until one of the given type arrives.
+func awaitResponse(t *testing.T, client *Client, kind ResponseType) Response {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for {
+		response, err := client.Next(ctx)
+		if err != nil {
+			t.Fatalf("Expected %v, got %v", kind, err)
+		}
+
+		if response.Type == kind {
+			return response
+		}
+	}
+}
+
+func TestClientPlaysThroughServer(t *testing.T) {
+	dispatcher := NewDispatcher()
+
+	dispatcher.Register <- NewQueueManager()
+	dispatcher.Register <- NewMatchmaker()
+	dispatcher.Register <- NewGameManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	clients := []*Client{
+		testClient(t, "0.0.0.0:8080/?token="+testToken("first")),
+		testClient(t, "0.0.0.0:8080/?token="+testToken("second")),
+	}
+
+	for _, client := range clients {
+		identity := awaitResponse(t, client, LoggedIn).Payload.(Identity)
+		if identity.Id == "" {
+			t.Error("Expected identity to be decoded")
+		}
+
+		client.QueueUp(CasualMode.Name)
+		if mode := awaitResponse(t, client, WaitForMatch).Payload.(string); mode != CasualMode.Name {
+			t.Errorf("Expected %v, got %v", CasualMode.Name, mode)
+		}
+	}
+
+	for _, client := range clients {
+		matchId := awaitResponse(t, client, MatchFound).Payload.(uuid.UUID)
+		if err := client.ConfirmMatch(matchId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var gameId uuid.UUID
+
+	for _, client := range clients {
+		hand := awaitResponse(t, client, StartingHand).Payload.(StartingHandView)
+		if len(hand.Cards) != CasualMode.Rules.StartingHand {
+			t.Errorf("Expected %v cards, got %v", CasualMode.Rules.StartingHand, len(hand.Cards))
+		}
+
+		gameId = hand.GameId
+		client.Discard(hand.GameId, []string{})
+	}
+
+	turns := make(chan ResponseType, 2)
+	for _, client := range clients {
+		go func(client *Client) {
+			for response := range client.Incoming {
+				if response.Type == StartTurn || response.Type == WaitTurn {
+					if response.Payload.(TurnView).GameId != gameId {
+						t.Error("Expected turn for the game")
+					}
+					turns <- response.Type
+					return
+				}
+			}
+		}(client)
+	}
+
+	seen := map[ResponseType]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case kind := <-turns:
+			seen[kind]++
+		case <-time.After(time.Second):
+			t.Fatal("Expected turns to start")
+		}
+	}
+
+	if seen[StartTurn] != 1 || seen[WaitTurn] != 1 {
+		t.Errorf("Expected one player to start, got %v", seen)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	client.Close()
+
+	if err := client.EndTurn(uuid.New()); err != ErrClientClosed {
+		t.Errorf("Expected %v, got %v", ErrClientClosed, err)
+	}
+
+	select {
+	case _, ok := <-client.Incoming:
+		if ok {
+			// the welcome may have arrived before closing
+			_, ok = <-client.Incoming
+		}
+		if ok {
+			t.Error("Expected incoming to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected incoming to be closed")
+	}
+
+	if _, err := client.Next(context.Background()); err != ErrClientClosed {
+		t.Errorf("Expected %v, got %v", ErrClientClosed, err)
+	}
+}
+
+func TestClientNextHonoursContext(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	awaitResponse(t, client, Welcome)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.Next(ctx); err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestClientRequestWaitsForTheServer(t *testing.T) {
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewQueueManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	queued := make(chan error, 1)
+	go func() {
+		queued <- client.Request(ctx, Event{Type: QueueUp, Payload: QueueUpPayload{Mode: CasualMode.Name}})
+	}()
+
+	// what the request brings still arrives as usual
+	awaitResponse(t, client, WaitForMatch)
+	if err := <-queued; err != nil {
+		t.Errorf("Expected the request to be applied, got %v", err)
+	}
+
+	err := client.Request(ctx, Event{Type: QueueUp, Payload: QueueUpPayload{Mode: "chess"}})
+	if nack, ok := err.(*NackError); !ok || nack.Reason != "Unknown game mode" {
+		t.Errorf("Expected the request to be refused for an unknown mode, got %v", err)
+	}
+}
+
+func TestClientChallenges(t *testing.T) {
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewLobbyManager(NewMemoryStore())
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	first := testClient(t, "0.0.0.0:8080/?token="+testToken("first"))
+	second := testClient(t, "0.0.0.0:8080/?token="+testToken("second"))
+	awaitResponse(t, first, LoggedIn)
+	awaitResponse(t, second, LoggedIn)
+
+	first.ChallengePlayer("second", CasualMode.Name)
+	sent := awaitResponse(t, first, ChallengeSent).Payload.(string)
+
+	received := awaitResponse(t, second, ChallengeReceived).Payload.(ChallengeReceivedPayload)
+	if received.ChallengeId != sent || received.From.Id != "first" {
+		t.Fatalf("Expected challenge %v from first, got %+v", sent, received)
+	}
+
+	second.DeclineChallenge(received.ChallengeId)
+	if declined := awaitResponse(t, first, ChallengeDeclined).Payload.(string); declined != sent {
+		t.Errorf("Expected %v to be declined, got %v", sent, declined)
+	}
+
+	first.ChallengePlayer("second", CasualMode.Name)
+	received = awaitResponse(t, second, ChallengeReceived).Payload.(ChallengeReceivedPayload)
+
+	second.AcceptChallenge(received.ChallengeId)
+	for _, client := range []*Client{first, second} {
+		if lobby := awaitResponse(t, client, LobbyUpdated).Payload.(LobbyPayload); len(lobby.Players) != 2 {
+			t.Errorf("Expected both players in the lobby, got %+v", lobby.Players)
+		}
+	}
+}
+
+func TestDecodeBoardPayload(t *testing.T) {
+	board := NewBoard()
+	minion := board.PlaceCard(NewMinion(1, 2, 3))
+
+	data := []byte(`[{"Defenders":{"` + minion.GetId() + `":` + mustMarshal(t, minion) + `}},{"Defenders":{}}]`)
+
+	payload, err := DecodePayload(AttackResult, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boards := payload.([]BoardView)
+	card := boards[0].Defenders[minion.GetId()]
+
+	if card.Id.String() != minion.GetId() || card.Damage != 2 || card.Health != 3 {
+		t.Errorf("Expected minion to be decoded, got %+v", card)
+	}
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
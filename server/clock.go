@@ -0,0 +1,179 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and keeps the timers of matches, games and
+// queues, so tests can move it along instead of waiting.
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the channel once d has gone by
+	After(d time.Duration) <-chan time.Time
+	// NewTicker sends the time every d, dropping ticks nobody reads
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock is the time as the system keeps it.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// FakeClock only moves when it's advanced, firing the timers that came
+// due along the way.
+type FakeClock struct {
+	mutex   sync.Mutex
+	waiting *sync.Cond
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	// every is how often a ticker ticks, zero for timers that fire once
+	every time.Duration
+	c     chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	clock := &FakeClock{now: now}
+	clock.waiting = sync.NewCond(&clock.mutex)
+	return clock
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	timer := &fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+		return timer.c
+	}
+
+	c.add(timer)
+	return timer.c
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	timer := &fakeTimer{at: c.now.Add(d), every: d, c: make(chan time.Time, 1)}
+	c.add(timer)
+	return &fakeTicker{clock: c, timer: timer}
+}
+
+func (c *FakeClock) add(timer *fakeTimer) {
+	c.timers = append(c.timers, timer)
+	c.waiting.Broadcast()
+}
+
+// Advance moves the clock forward by d, firing timers in the order they
+// come due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	end := c.now.Add(d)
+
+	for {
+		next := -1
+		for idx, timer := range c.timers {
+			if !timer.at.After(end) && (next == -1 || timer.at.Before(c.timers[next].at)) {
+				next = idx
+			}
+		}
+		if next == -1 {
+			break
+		}
+
+		timer := c.timers[next]
+		c.now = timer.at
+
+		select {
+		case timer.c <- c.now:
+		default:
+		}
+
+		if timer.every > 0 {
+			timer.at = timer.at.Add(timer.every)
+		} else {
+			c.timers = append(c.timers[:next], c.timers[next+1:]...)
+		}
+	}
+
+	c.now = end
+}
+
+// BlockUntil waits until at least n timers are waiting to fire, so the
+// clock isn't advanced before whatever's meant to wait on it does.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.timers) < n {
+		c.waiting.Wait()
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	timer *fakeTimer
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	for idx, timer := range t.clock.timers {
+		if timer == t.timer {
+			t.clock.timers = append(t.clock.timers[:idx], t.clock.timers[idx+1:]...)
+			return
+		}
+	}
+}
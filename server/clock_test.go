@@ -0,0 +1,92 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestClock is a fake clock set to a fixed time.
+func newTestClock() *FakeClock {
+	return NewFakeClock(time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC))
+}
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClockFiresTimersWhenDue(t *testing.T) {
+	clock := newTestClock()
+	start := clock.Now()
+
+	soon := clock.After(time.Second)
+	later := clock.After(time.Minute)
+
+	clock.Advance(999 * time.Millisecond)
+	if fired(soon) {
+		t.Error("Expected timer not to fire early")
+	}
+
+	clock.Advance(time.Millisecond)
+	if !fired(soon) || fired(later) {
+		t.Error("Expected only the timer that came due to fire")
+	}
+
+	clock.Advance(time.Hour)
+	if !fired(later) {
+		t.Error("Expected timer to fire once the clock went past it")
+	}
+
+	if elapsed := clock.Now().Sub(start); elapsed != time.Hour+time.Second {
+		t.Errorf("Expected clock to have moved by what it was advanced, got %v", elapsed)
+	}
+
+	if !fired(clock.After(0)) {
+		t.Error("Expected a timer for no time at all to fire right away")
+	}
+}
+
+func TestFakeClockTicks(t *testing.T) {
+	clock := newTestClock()
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(time.Second)
+	if tick := <-ticker.C(); !tick.Equal(clock.Now()) {
+		t.Errorf("Expected tick at %v, got %v", clock.Now(), tick)
+	}
+
+	// ticks nobody reads are dropped, like a real ticker's
+	clock.Advance(5 * time.Second)
+	if !fired(ticker.C()) || fired(ticker.C()) {
+		t.Error("Expected a single tick to be waiting")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	if fired(ticker.C()) {
+		t.Error("Expected stopped ticker not to tick")
+	}
+}
+
+func TestFakeClockBlocksUntilTimersAreSet(t *testing.T) {
+	clock := newTestClock()
+
+	done := make(chan bool)
+	go func() {
+		<-clock.After(time.Minute)
+		close(done)
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the timer to have been set before the clock moved")
+	}
+}
@@ -0,0 +1,653 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var ErrMalformedMessage = errors.New("malformed message")
+
+// Codec encodes what goes over a connection. Clients pick one by the
+// websocket subprotocol they ask for, and get JSONCodec if they don't
+// ask for one the server knows.
+type Codec interface {
+	// Subprotocol is the websocket subprotocol the codec is picked by
+	Subprotocol() string
+	// MessageType is the type of websocket message it's sent as
+	MessageType() int
+
+	EncodeEvent(event Event) ([]byte, error)
+	DecodeEvent(data []byte) (Event, error)
+	EncodeResponse(response Response) ([]byte, error)
+	// DecodeResponse decodes payloads into the types listed in
+	// DecodePayload.
+	DecodeResponse(data []byte) (Response, error)
+}
+
+var (
+	JSONCodec   Codec = jsonCodec{}
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// Codecs are those the server speaks, the one it prefers first.
+var Codecs = []Codec{BinaryCodec, JSONCodec}
+
+func subprotocols() []string {
+	names := make([]string, 0, len(Codecs))
+	for _, codec := range Codecs {
+		names = append(names, codec.Subprotocol())
+	}
+	return names
+}
+
+// codecFor is the codec picked by subprotocol, JSON if there's none.
+func codecFor(subprotocol string) Codec {
+	for _, codec := range Codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string {
+	return "wingscam.json"
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) EncodeEvent(event Event) ([]byte, error) {
+	return json.Marshal(struct {
+		Id      string `json:",omitempty"`
+		Type    EventType
+		Payload interface{}
+	}{event.Id, event.Type, event.Payload})
+}
+
+func (jsonCodec) DecodeEvent(data []byte) (Event, error) {
+	var raw struct {
+		Id      string
+		Type    EventType
+		Payload json.RawMessage
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{Id: raw.Id, Type: raw.Type}
+	if len(raw.Payload) > 0 && string(raw.Payload) != "null" {
+		event.Payload = raw.Payload
+	}
+	return event, nil
+}
+
+func (jsonCodec) EncodeResponse(response Response) ([]byte, error) {
+	return json.Marshal(response)
+}
+
+func (jsonCodec) DecodeResponse(data []byte) (Response, error) {
+	var raw struct {
+		Type      ResponseType
+		Payload   json.RawMessage
+		RequestId string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Response{}, err
+	}
+
+	payload, err := DecodePayload(raw.Type, raw.Payload)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{Type: raw.Type, Payload: payload, RequestId: raw.RequestId}, nil
+}
+
+// eventTypes and responseTypes are numbered by their position in binary
+// messages, so new types only ever go at the end.
+var eventTypes = []EventType{
+	Login, QueueUp, Dequeue, MatchConfirmed, MatchDeclined, CardsDiscarded,
+	EndTurn, PlayCard, Attack, AttackPlayer, Concede, SaveDeck, GetDecks,
+	GetMatchHistory, CreateLobby, JoinLobby, LeaveLobby, SelectDeck,
+	LobbyReady, ChallengePlayer, AcceptChallenge, DeclineChallenge,
+	GetReplay,
+}
+
+var responseTypes = []ResponseType{
+	Welcome, LoggedIn, WaitForMatch, MatchFound, Dequeued, WaitOtherPlayers,
+	MatchCanceled, StartingHand, StartTurn, WaitTurn, CardPlayed,
+	AttackResult, DamageTaken, GameOver, GameAborted, DeckSaved, Decks,
+	MatchHistory, LobbyUpdated, LobbyClosed, ChallengeSent,
+	ChallengeReceived, ChallengeDeclined, Maintenance, GameResumed,
+	OpponentLeft, OpponentReturned, Error, Ack, Nack, GameReplay,
+	PayloadRejected,
+}
+
+// binaryCodec writes the type of a message as its number, followed by
+// the id it carries, if any, and its payload laid out as the payload's schema, eventSchemas for events
+// and responseSchemas for responses: fields in order, without names,
+// and numbers as varints. Payloads of responses without a schema are
+// sent as JSON.
+type binaryCodec struct{}
+
+func (binaryCodec) Subprotocol() string {
+	return "wingscam.binary"
+}
+
+func (binaryCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (binaryCodec) EncodeEvent(event Event) ([]byte, error) {
+	payload, err := decodeEventPayload(event.Type, event.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var w binaryWriter
+	w.putUint(eventTag(event.Type))
+	w.putString(event.Id)
+
+	if err := w.putPayload(payload, eventSchemas[event.Type]); err != nil {
+		return nil, fmt.Errorf("%v payload: %w", event.Type, err)
+	}
+	return w.Bytes(), nil
+}
+
+func (binaryCodec) DecodeEvent(data []byte) (Event, error) {
+	r := binaryReader{bytes.NewReader(data)}
+
+	tag, err := r.readUint()
+	if err != nil || tag == 0 || tag > uint64(len(eventTypes)) {
+		return Event{}, ErrMalformedMessage
+	}
+	kind := eventTypes[tag-1]
+
+	id, err := r.readString()
+	if err != nil {
+		return Event{}, err
+	}
+
+	payload, err := r.readPayload(eventSchemas[kind])
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{Id: id, Type: kind, Payload: payload}, nil
+}
+
+func (binaryCodec) EncodeResponse(response Response) ([]byte, error) {
+	var w binaryWriter
+
+	// types the client doesn't know are sent by name, so it can still
+	// tell what it got
+	tag := responseTag(response.Type)
+	w.putUint(tag)
+	if tag == 0 {
+		w.putString(string(response.Type))
+	}
+	w.putString(response.RequestId)
+
+	schema, ok := responseSchemas[response.Type]
+	if !ok {
+		if err := w.putJSON(response.Payload); err != nil {
+			return nil, err
+		}
+		return w.Bytes(), nil
+	}
+
+	// game payloads hold cards behind interfaces, which are only laid
+	// out as their schema once they've been through JSON
+	payload := response.Payload
+	if payload != nil && reflect.TypeOf(payload) != reflect.TypeOf(schema) {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if payload, err = DecodePayload(response.Type, data); err != nil {
+			return nil, fmt.Errorf("%v payload: %w", response.Type, err)
+		}
+	}
+
+	if err := w.putPayload(payload, schema); err != nil {
+		return nil, fmt.Errorf("%v payload: %w", response.Type, err)
+	}
+	return w.Bytes(), nil
+}
+
+func (binaryCodec) DecodeResponse(data []byte) (Response, error) {
+	r := binaryReader{bytes.NewReader(data)}
+
+	tag, err := r.readUint()
+	if err != nil || tag > uint64(len(responseTypes)) {
+		return Response{}, ErrMalformedMessage
+	}
+
+	var kind ResponseType
+	if tag == 0 {
+		name, err := r.readString()
+		if err != nil {
+			return Response{}, err
+		}
+		kind = ResponseType(name)
+	} else {
+		kind = responseTypes[tag-1]
+	}
+
+	requestId, err := r.readString()
+	if err != nil {
+		return Response{}, err
+	}
+
+	schema, ok := responseSchemas[kind]
+	if !ok {
+		payload, err := r.readJSON()
+		return Response{Type: kind, Payload: payload, RequestId: requestId}, err
+	}
+
+	payload, err := r.readPayload(schema)
+	if err != nil {
+		return Response{}, err
+	}
+
+	return Response{Type: kind, Payload: payload, RequestId: requestId}, nil
+}
+
+// eventTag and responseTag are what a type is sent as, counting from
+// one, or zero for types that aren't numbered.
+func eventTag(kind EventType) uint64 {
+	for idx, numbered := range eventTypes {
+		if numbered == kind {
+			return uint64(idx + 1)
+		}
+	}
+	return 0
+}
+
+func responseTag(kind ResponseType) uint64 {
+	for idx, numbered := range responseTypes {
+		if numbered == kind {
+			return uint64(idx + 1)
+		}
+	}
+	return 0
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type binaryWriter struct {
+	bytes.Buffer
+}
+
+func (w *binaryWriter) putUint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], n)])
+}
+
+func (w *binaryWriter) putInt(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutVarint(buf[:], n)])
+}
+
+func (w *binaryWriter) putString(s string) {
+	w.putUint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+func (w *binaryWriter) putBool(b bool) {
+	if b {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func (w *binaryWriter) putJSON(value interface{}) error {
+	w.putBool(value != nil)
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	w.putString(string(data))
+	return nil
+}
+
+// payload writes whether there is one, and then payload as schema.
+// Payloads of another type are decoded into the schema first.
+func (w *binaryWriter) putPayload(payload, schema interface{}) error {
+	w.putBool(payload != nil && schema != nil)
+	if payload == nil || schema == nil {
+		return nil
+	}
+
+	value := reflect.ValueOf(payload)
+	if value.Type() != reflect.TypeOf(schema) {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		converted := reflect.New(reflect.TypeOf(schema))
+		if err := json.Unmarshal(data, converted.Interface()); err != nil {
+			return err
+		}
+		value = converted.Elem()
+	}
+
+	return w.putValue(value)
+}
+
+func (w *binaryWriter) putValue(v reflect.Value) error {
+	if v.Type() == timeType {
+		data, err := v.Interface().(time.Time).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		w.putString(string(data))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		w.putBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		w.putInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		w.putUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		w.putUint(math.Float64bits(v.Float()))
+	case reflect.String:
+		w.putString(v.String())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := w.putValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		// nil is told apart from empty, as it is in JSON
+		if v.IsNil() {
+			w.putUint(0)
+			return nil
+		}
+		w.putUint(uint64(v.Len()) + 1)
+		for i := 0; i < v.Len(); i++ {
+			if err := w.putValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			w.putUint(0)
+			return nil
+		}
+		w.putUint(uint64(v.Len()) + 1)
+
+		// keys in order, so the same payload is always the same bytes
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, key := range keys {
+			if err := w.putValue(key); err != nil {
+				return err
+			}
+			if err := w.putValue(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		w.putBool(!v.IsNil())
+		if !v.IsNil() {
+			return w.putValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := w.putValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("no binary layout for %v", v.Type())
+	}
+
+	return nil
+}
+
+type binaryReader struct {
+	*bytes.Reader
+}
+
+func (r binaryReader) readUint() (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, ErrMalformedMessage
+	}
+	return n, nil
+}
+
+func (r binaryReader) readInt() (int64, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, ErrMalformedMessage
+	}
+	return n, nil
+}
+
+// readLength reads how many of something follow, none of which can take
+// up less than a byte.
+func (r binaryReader) readLength() (int, error) {
+	n, err := r.readUint()
+	if err != nil || n > uint64(r.Len()) {
+		return 0, ErrMalformedMessage
+	}
+	return int(n), nil
+}
+
+// readCount reads how many elements a slice or map has, or that it's
+// nil.
+func (r binaryReader) readCount() (int, bool, error) {
+	n, err := r.readUint()
+	if err != nil || n > uint64(r.Len())+1 {
+		return 0, false, ErrMalformedMessage
+	}
+	if n == 0 {
+		return 0, false, nil
+	}
+	return int(n - 1), true, nil
+}
+
+func (r binaryReader) readString() (string, error) {
+	n, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, n)
+	if _, err := r.Read(buf); err != nil && n > 0 {
+		return "", ErrMalformedMessage
+	}
+	return string(buf), nil
+}
+
+func (r binaryReader) readBool() (bool, error) {
+	b, err := r.ReadByte()
+	if err != nil || b > 1 {
+		return false, ErrMalformedMessage
+	}
+	return b == 1, nil
+}
+
+func (r binaryReader) readJSON() (interface{}, error) {
+	present, err := r.readBool()
+	if err != nil || !present {
+		return nil, err
+	}
+
+	data, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil || r.Len() > 0 {
+		return nil, ErrMalformedMessage
+	}
+	return value, nil
+}
+
+func (r binaryReader) readPayload(schema interface{}) (interface{}, error) {
+	present, err := r.readBool()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload interface{}
+	if present {
+		if schema == nil {
+			return nil, ErrMalformedMessage
+		}
+
+		value := reflect.New(reflect.TypeOf(schema)).Elem()
+		if err := r.readValue(value); err != nil {
+			return nil, err
+		}
+		payload = value.Interface()
+	}
+
+	if r.Len() > 0 {
+		return nil, ErrMalformedMessage
+	}
+	return payload, nil
+}
+
+func (r binaryReader) readValue(v reflect.Value) error {
+	if v.Type() == timeType {
+		data, err := r.readString()
+		if err != nil {
+			return err
+		}
+
+		var t time.Time
+		if err := t.UnmarshalBinary([]byte(data)); err != nil {
+			return ErrMalformedMessage
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.readBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := r.readInt()
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := r.readUint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := r.readUint()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(n))
+	case reflect.String:
+		s, err := r.readString()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.readValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		n, present, err := r.readCount()
+		if err != nil || !present {
+			return err
+		}
+
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := r.readValue(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		n, present, err := r.readCount()
+		if err != nil || !present {
+			return err
+		}
+
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := r.readValue(key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := r.readValue(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		present, err := r.readBool()
+		if err != nil || !present {
+			return err
+		}
+
+		elem := reflect.New(v.Type().Elem())
+		if err := r.readValue(elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := r.readValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("no binary layout for %v", v.Type())
+	}
+
+	return nil
+}
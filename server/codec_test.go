@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var sampleEvents = map[EventType]interface{}{
+	Login:            "token",
+	QueueUp:          map[string]interface{}{"Mode": "casual"},
+	Dequeue:          nil,
+	MatchConfirmed:   uuid.NewString(),
+	MatchDeclined:    uuid.NewString(),
+	CardsDiscarded:   CardsDiscardedPayload{GameId: uuid.NewString(), Cards: []string{uuid.NewString(), uuid.NewString()}},
+	EndTurn:          uuid.NewString(),
+	PlayCard:         PlayCardPayload{GameId: uuid.NewString(), Card: uuid.NewString()},
+	Attack:           AttackPayload{GameId: uuid.NewString(), Attacker: uuid.NewString(), Target: uuid.NewString()},
+	AttackPlayer:     map[string]interface{}{"GameId": uuid.NewString(), "Attacker": uuid.NewString()},
+	Concede:          uuid.New(),
+	SaveDeck:         DeckList{Name: "aggro", Cards: []CardSpec{{Name: "imp", ManaCost: 1, Damage: 2, Health: 1}}},
+	GetDecks:         nil,
+	GetMatchHistory:  MatchHistoryPayload{Limit: 5},
+	CreateLobby:      CreateLobbyPayload{Mode: "casual"},
+	JoinLobby:        JoinLobbyPayload{Code: "ABCD"},
+	LeaveLobby:       nil,
+	SelectDeck:       SelectDeckPayload{DeckId: "deck"},
+	LobbyReady:       nil,
+	ChallengePlayer:  ChallengePayload{AccountId: "friend"},
+	AcceptChallenge:  ChallengeAnswerPayload{ChallengeId: uuid.NewString()},
+	DeclineChallenge: ChallengeAnswerPayload{ChallengeId: uuid.NewString()},
+	GetReplay:        uuid.NewString(),
+}
+
+func sampleResponses() map[ResponseType]interface{} {
+	minion := NewMinion(1, 2, 3)
+	board := NewBoard()
+	board.PlaceCard(NewMinion(2, 3, 4))
+
+	return map[ResponseType]interface{}{
+		Welcome:          nil,
+		LoggedIn:         Identity{Id: "player", Name: "Player"},
+		WaitForMatch:     "casual",
+		MatchFound:       uuid.New(),
+		Dequeued:         nil,
+		WaitOtherPlayers: []HasManaCost{minion},
+		MatchCanceled:    MatchCanceledPayload{Requeued: true},
+		StartingHand: StartingHandPayload{
+			Id:       uuid.New(),
+			GameId:   uuid.New(),
+			Cards:    []HasManaCost{minion, NewMinion(4, 5, 6)},
+			Health:   30,
+			Duration: time.Minute,
+		},
+		StartTurn:    TurnPayload{GameId: uuid.New(), Duration: time.Minute, Card: minion, Mana: 3, CardsLeft: 20, CardsInHand: 4},
+		WaitTurn:     TurnPayload{GameId: uuid.New(), Duration: time.Minute},
+		CardPlayed:   CardPlayedPayload{Mana: 2, Player: uuid.New(), Card: board.PlaceCard(minion), GameId: uuid.New()},
+		AttackResult: []*Board{board, NewBoard()},
+		DamageTaken:  DamageTakenPayload{Health: 12, PlayerId: "player"},
+		GameOver: GameOverView{
+			Winner: GamePlayerView{Id: uuid.New(), Health: 5, Board: BoardView{Defenders: map[string]CardView{}}},
+			Loser:  GamePlayerView{Id: uuid.New(), Hand: []CardView{{Id: uuid.New(), ManaCost: 2}}},
+		},
+		GameAborted:       uuid.New(),
+		DeckSaved:         DeckList{Id: "deck", Owner: "player", Name: "aggro"},
+		Decks:             []DeckList{},
+		MatchHistory:      []GameRecord{{Id: "game", Players: []string{"a", "b"}, Started: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC), Turns: 9}},
+		LobbyUpdated:      LobbyPayload{Code: "ABCD", Mode: "casual", Players: []LobbyPlayerPayload{{Id: "player", Ready: true}}},
+		LobbyClosed:       "ABCD",
+		ChallengeSent:     "challenge",
+		ChallengeReceived: ChallengeReceivedPayload{ChallengeId: "challenge", From: Identity{Id: "friend"}},
+		ChallengeDeclined: "challenge",
+		Maintenance:       MaintenancePayload{Deadline: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)},
+		GameResumed: GameResumedPayload{
+			GameId:     uuid.New(),
+			Hand:       []HasManaCost{minion},
+			Board:      board,
+			EnemyBoard: NewBoard(),
+			Current:    true,
+		},
+		OpponentLeft:     OpponentLeftPayload{GameId: uuid.New(), Grace: 30 * time.Second},
+		OpponentReturned: uuid.New(),
+		Error:            "something went wrong",
+		Ack:              nil,
+		Nack:             "Not enough mana",
+		PayloadRejected:  PayloadError{Event: QueueUp, Field: "Mode", Reason: "must be a string"},
+		GameReplay: Replay{
+			Id:      "replay",
+			GameId:  "game",
+			Players: []ReplayPlayer{{Id: "player", Hand: []ReplayCard{{Id: "card", ManaCost: 1, Damage: 2, Health: 3}}}},
+			Actions: []ReplayAction{{At: time.Second, Player: "player", Type: PlayCard, Cards: []string{"card"}}},
+		},
+	}
+}
+
+func TestCodecTablesCoverEveryType(t *testing.T) {
+	if len(eventTypes) != len(eventSchemas) {
+		t.Errorf("Expected %v event types, got %v", len(eventSchemas), len(eventTypes))
+	}
+	for _, kind := range eventTypes {
+		if _, ok := eventSchemas[kind]; !ok {
+			t.Errorf("Expected %v to have a schema", kind)
+		}
+		if _, ok := sampleEvents[kind]; !ok {
+			t.Errorf("Expected a sample %v", kind)
+		}
+	}
+
+	samples := sampleResponses()
+	for _, kind := range responseTypes {
+		if _, ok := samples[kind]; !ok {
+			t.Errorf("Expected a sample %v", kind)
+		}
+	}
+	for kind := range responseSchemas {
+		if responseTag(kind) == 0 {
+			t.Errorf("Expected %v to have a tag", kind)
+		}
+	}
+}
+
+func TestCodecsRoundTripEvents(t *testing.T) {
+	for _, codec := range Codecs {
+		for _, kind := range eventTypes {
+			sent := Event{Id: string(kind), Type: kind, Payload: sampleEvents[kind]}
+
+			data, err := codec.EncodeEvent(sent)
+			if err != nil {
+				t.Fatalf("%v could not encode %v: %v", codec.Subprotocol(), kind, err)
+			}
+
+			received, err := codec.DecodeEvent(data)
+			if err != nil {
+				t.Fatalf("%v could not decode %v: %v", codec.Subprotocol(), kind, err)
+			}
+
+			if received.Type != kind || received.Id != sent.Id {
+				t.Errorf("%v: expected %v %q, got %v %q", codec.Subprotocol(), kind, sent.Id, received.Type, received.Id)
+			}
+
+			// payloads may come back as raw JSON or as the schema,
+			// handlers decode either the same way
+			if !reflect.DeepEqual(asSchema(t, kind, received.Payload), asSchema(t, kind, sent.Payload)) {
+				t.Errorf("%v: expected %v payload %+v, got %+v", codec.Subprotocol(), kind, sent.Payload, received.Payload)
+			}
+		}
+	}
+}
+
+func asSchema(t *testing.T, kind EventType, payload interface{}) interface{} {
+	decoded, err := decodeEventPayload(kind, payload)
+	if err != nil {
+		t.Fatalf("Could not decode %v payload %+v: %v", kind, payload, err)
+	}
+	return decoded
+}
+
+func TestCodecsRoundTripResponses(t *testing.T) {
+	for _, codec := range Codecs {
+		for kind, payload := range sampleResponses() {
+			data, err := codec.EncodeResponse(Response{Type: kind, Payload: payload, RequestId: string(kind)})
+			if err != nil {
+				t.Fatalf("%v could not encode %v: %v", codec.Subprotocol(), kind, err)
+			}
+
+			received, err := codec.DecodeResponse(data)
+			if err != nil {
+				t.Fatalf("%v could not decode %v: %v", codec.Subprotocol(), kind, err)
+			}
+
+			if received.Type != kind || received.RequestId != string(kind) {
+				t.Errorf("%v: expected %v %q, got %v %q", codec.Subprotocol(), kind, kind, received.Type, received.RequestId)
+			}
+
+			raw, _ := json.Marshal(payload)
+			expected, err := DecodePayload(kind, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(received.Payload, expected) {
+				t.Errorf("%v: expected %v payload %+v, got %+v", codec.Subprotocol(), kind, expected, received.Payload)
+			}
+		}
+	}
+}
+
+func TestBinaryCodecIsCompact(t *testing.T) {
+	response := Response{Type: StartingHand, Payload: sampleResponses()[StartingHand]}
+
+	text, _ := JSONCodec.EncodeResponse(response)
+	binary, _ := BinaryCodec.EncodeResponse(response)
+
+	if len(binary) >= len(text)/2 {
+		t.Errorf("Expected binary to be well under half of %v bytes, got %v", len(text), len(binary))
+	}
+}
+
+func TestBinaryCodecRejectsMalformedMessages(t *testing.T) {
+	event, _ := BinaryCodec.EncodeEvent(Event{Type: Attack, Payload: sampleEvents[Attack]})
+	response, _ := BinaryCodec.EncodeResponse(Response{Type: StartingHand, Payload: sampleResponses()[StartingHand]})
+
+	cases := map[string][]byte{
+		"empty":           {},
+		"unknown tag":     {200, 1},
+		"truncated event": event[:len(event)-1],
+		"trailing bytes":  append(append([]byte{}, event...), 0),
+		"huge length":     {byte(eventTag(Login)), 1, 0xff, 0xff, 0xff, 0xff, 0x0f},
+	}
+
+	for name, data := range cases {
+		if _, err := BinaryCodec.DecodeEvent(data); err == nil {
+			t.Errorf("Expected %v event to be rejected", name)
+		}
+	}
+
+	for end := 0; end < len(response); end++ {
+		if _, err := BinaryCodec.DecodeResponse(response[:end]); err == nil {
+			t.Errorf("Expected response cut at %v bytes to be rejected", end)
+		}
+	}
+}
+
+func TestNegotiatesCodec(t *testing.T) {
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewQueueManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	for _, codec := range Codecs {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		client, err := ConnectWith(ctx, "0.0.0.0:8080/?token="+testToken(codec.Subprotocol()), codec)
+		cancel()
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+
+		if client.codec != codec {
+			t.Errorf("Expected %v, got %v", codec.Subprotocol(), client.codec.Subprotocol())
+		}
+
+		identity := awaitResponse(t, client, LoggedIn).Payload.(Identity)
+		if identity.Id != codec.Subprotocol() {
+			t.Errorf("Expected %v to log in, got %+v", codec.Subprotocol(), identity)
+		}
+
+		client.QueueUp(CasualMode.Name)
+		if mode := awaitResponse(t, client, WaitForMatch).Payload; mode != CasualMode.Name {
+			t.Errorf("Expected %v, got %v", CasualMode.Name, mode)
+		}
+
+		client.Close()
+	}
+}
@@ -82,3 +82,20 @@ func TestFrozenCannotAttack(t *testing.T) {
 		t.Error("Frozen should be able to counter-attack")
 	}
 }
+
+func TestSnapshotsDontChangeWithTheBoard(t *testing.T) {
+	board := NewBoard()
+	minion := board.PlaceCard(NewMinion(1, 1, 3))
+
+	snapshot := board.Snapshot()
+	minion.ReduceHealth(2)
+	minion.SetStatus(&Ready{})
+
+	copied := snapshot.Defenders[minion.GetId()]
+	if copied.GetHealth() != 3 {
+		t.Errorf("Expected the snapshot to keep 3 health, got %v", copied.GetHealth())
+	}
+	if copied.CanAttack() {
+		t.Error("Expected the snapshot to stay exhausted")
+	}
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config is everything about the server that can be tuned without
+// recompiling it. LoadConfig reads it from a file, the environment and
+// flags.
+type Config struct {
+	// Addr is where the server listens
+	Addr string
+	// Store is the path of the database file
+	Store string
+	// Secret verifies player tokens
+	Secret string
+	// LogEvents logs every event going through the dispatcher
+	LogEvents bool
+	// ShutdownGrace is how long games get to finish when shutting down
+	ShutdownGrace time.Duration
+
+	Server     ServerConfig
+	Queue      QueueConfig
+	Matchmaker MatchmakerConfig
+	Game       GameConfig
+}
+
+// ServerConfig is how the server treats connections.
+type ServerConfig struct {
+	Heartbeat     Heartbeat
+	OldestVersion int
+	Limits        Limits
+}
+
+// QueueConfig is how players are queued up and matched.
+type QueueConfig struct {
+	Modes  []GameMode
+	Window MatchWindow
+	// Rematch is how often waiting players are matched again
+	Rematch time.Duration
+}
+
+// MatchmakerConfig is how matches are set up.
+type MatchmakerConfig struct {
+	// Confirm is how long players have to confirm a match
+	Confirm time.Duration
+}
+
+// GameConfig is how games are run.
+type GameConfig struct {
+	// Mulligan is how long players have to choose their starting hand
+	Mulligan time.Duration
+}
+
+var (
+	DefaultServerConfig = ServerConfig{
+		Heartbeat:     DefaultHeartbeat,
+		OldestVersion: LegacyProtocolVersion,
+		Limits:        DefaultLimits,
+	}
+	DefaultMatchmakerConfig = MatchmakerConfig{Confirm: 15 * time.Second}
+	DefaultGameConfig       = GameConfig{Mulligan: 30 * time.Second}
+)
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Modes:   append([]GameMode(nil), DefaultModes...),
+		Window:  DefaultMatchWindow,
+		Rematch: time.Second,
+	}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:          "0.0.0.0:8080",
+		Store:         "wingscam.db",
+		ShutdownGrace: 2 * time.Minute,
+
+		Server:     DefaultServerConfig,
+		Queue:      DefaultQueueConfig(),
+		Matchmaker: DefaultMatchmakerConfig,
+		Game:       DefaultGameConfig,
+	}
+}
+
+// EnvPrefix starts the environment variables settings are read from,
+// the rest being the flag's name in upper case with underscores.
+const EnvPrefix = "WINGSCAM_"
+
+// LoadConfig reads the config from the file named by the -config flag
+// or WINGSCAM_CONFIG, then the environment, then args, each overriding
+// what came before, and validates it.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	config := DefaultConfig()
+
+	// the rules and backfill given for every mode, which modes can
+	// then set for themselves
+	rules := DefaultRules
+	var backfill time.Duration
+	for _, mode := range config.Queue.Modes {
+		if mode.Backfill > 0 {
+			backfill = mode.Backfill
+		}
+	}
+
+	modes := nestedSettings{}
+	events := nestedSettings{}
+
+	flags := config.flags(&rules, &backfill)
+	flags.Var(modes, "modes", "JSON object of settings by mode name, over those given for every mode, adding the modes it doesn't know")
+	flags.Var(events, "event-limits", "JSON object of burst and interval by event type, over the default limits")
+	path := flags.String("config", getenv(EnvPrefix+"CONFIG"), "JSON file of settings, by flag name")
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	// flags given on the command line win over the file and environment
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	if *path != "" {
+		settings, err := readConfigFile(*path)
+		if err != nil {
+			return config, err
+		}
+		for name, value := range settings {
+			if name == "config" || given[name] {
+				continue
+			}
+			if err := set(flags, name, value); err != nil {
+				return config, fmt.Errorf("%v: %w", *path, err)
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || given[f.Name] {
+			return
+		}
+		if value := getenv(envName(f.Name)); value != "" {
+			if setErr := set(flags, f.Name, value); setErr != nil {
+				err = fmt.Errorf("%v: %w", envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return config, err
+	}
+
+	// the rules given for every mode come first, each mode's own
+	// settings after
+	for idx := range config.Queue.Modes {
+		mode := &config.Queue.Modes[idx]
+		mode.Rules = rules
+		if mode.Backfill > 0 {
+			mode.Backfill = backfill
+		}
+	}
+
+	for _, name := range modes.names() {
+		idx := config.mode(name)
+		if idx < 0 {
+			config.Queue.Modes = append(config.Queue.Modes, GameMode{Name: name, Rules: rules})
+			idx = len(config.Queue.Modes) - 1
+		}
+
+		mode := &config.Queue.Modes[idx]
+		if err := setAll(modeFlags(mode), modes[name]); err != nil {
+			return config, fmt.Errorf("mode %v: %w", name, err)
+		}
+	}
+
+	limits := make(map[EventType]Rate, len(config.Server.Limits.Events))
+	for kind, rate := range config.Server.Limits.Events {
+		limits[kind] = rate
+	}
+	for _, name := range events.names() {
+		kind := EventType(name)
+		if eventTag(kind) == 0 {
+			return config, fmt.Errorf("%w: unknown event %q in event-limits", ErrInvalidConfig, name)
+		}
+
+		rate := limits[kind]
+		if err := setAll(rateFlags(name, &rate), events[name]); err != nil {
+			return config, fmt.Errorf("event-limits of %v: %w", name, err)
+		}
+		limits[kind] = rate
+	}
+	config.Server.Limits.Events = limits
+
+	return config, config.Validate()
+}
+
+// mode is the index of the mode with the given name, or -1 if there's
+// none.
+func (c *Config) mode(name string) int {
+	for idx, mode := range c.Queue.Modes {
+		if mode.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (c *Config) flags(rules *Rules, backfill *time.Duration) *flag.FlagSet {
+	flags := flag.NewFlagSet("wingscam", flag.ContinueOnError)
+
+	flags.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	flags.StringVar(&c.Store, "db", c.Store, "path of the database file")
+	flags.StringVar(&c.Secret, "secret", c.Secret, "secret player tokens are signed with")
+	flags.BoolVar(&c.LogEvents, "log-events", c.LogEvents, "log every event")
+	flags.DurationVar(&c.ShutdownGrace, "shutdown-grace", c.ShutdownGrace, "how long games get to finish when shutting down")
+
+	server := &c.Server
+	flags.IntVar(&server.OldestVersion, "oldest-version", server.OldestVersion, "oldest protocol version clients can connect with")
+	flags.DurationVar(&server.Heartbeat.Interval, "ping-interval", server.Heartbeat.Interval, "how often players are pinged")
+	flags.DurationVar(&server.Heartbeat.Timeout, "read-timeout", server.Heartbeat.Timeout, "how long players can go without sending anything")
+	flags.DurationVar(&server.Heartbeat.WriteTimeout, "write-timeout", server.Heartbeat.WriteTimeout, "how long sending a player anything can take")
+	flags.Int64Var(&server.Limits.MaxMessageSize, "max-message-size", server.Limits.MaxMessageSize, "largest message players can send, in bytes")
+	flags.IntVar(&server.Limits.Connection.Burst, "rate-burst", server.Limits.Connection.Burst, "events a connection can send at once")
+	flags.DurationVar(&server.Limits.Connection.Interval, "rate-interval", server.Limits.Connection.Interval, "how often a connection can send another event")
+	flags.IntVar(&server.Limits.Warnings, "rate-warnings", server.Limits.Warnings, "events over the limits rejected before muting")
+	flags.DurationVar(&server.Limits.Mute, "mute", server.Limits.Mute, "how long players who keep going over the limits are muted")
+	flags.IntVar(&server.Limits.Mutes, "mutes", server.Limits.Mutes, "times players are muted before being disconnected")
+	flags.DurationVar(&server.Limits.Forgive, "forgive", server.Limits.Forgive, "how long under the limits before warnings and mutes are forgotten")
+
+	queue := &c.Queue
+	flags.IntVar(&queue.Window.Base, "window-base", queue.Window.Base, "rating difference players are matched within right away")
+	flags.IntVar(&queue.Window.Growth, "window-growth", queue.Window.Growth, "how much the rating window grows every step")
+	flags.DurationVar(&queue.Window.Step, "window-step", queue.Window.Step, "how often the rating window grows")
+	flags.IntVar(&queue.Window.Max, "window-max", queue.Window.Max, "widest the rating window gets")
+	flags.DurationVar(&queue.Rematch, "rematch", queue.Rematch, "how often waiting players are matched again")
+	flags.DurationVar(backfill, "backfill", *backfill, "how long players wait in casual before a bot takes their opponent's place")
+
+	flags.DurationVar(&c.Matchmaker.Confirm, "confirm", c.Matchmaker.Confirm, "how long players have to confirm a match")
+	flags.DurationVar(&c.Game.Mulligan, "mulligan", c.Game.Mulligan, "how long players have to choose their starting hand")
+
+	ruleFlags(flags, rules)
+
+	return flags
+}
+
+func ruleFlags(flags *flag.FlagSet, rules *Rules) {
+	flags.IntVar(&rules.DeckSize, "deck-size", rules.DeckSize, "cards in generated decks")
+	flags.IntVar(&rules.StartingHealth, "health", rules.StartingHealth, "health players start with")
+	flags.IntVar(&rules.StartingHand, "starting-hand", rules.StartingHand, "cards players start with")
+	flags.IntVar(&rules.MaxMana, "max-mana", rules.MaxMana, "most mana players build up to")
+	flags.IntVar(&rules.BoardSize, "board-size", rules.BoardSize, "most minions players can have out")
+	flags.DurationVar(&rules.TurnDuration, "turn", rules.TurnDuration, "how long turns last")
+	flags.DurationVar(&rules.ReconnectGrace, "reconnect-grace", rules.ReconnectGrace, "how long players who disconnect have to come back")
+}
+
+// modeFlags are the settings a mode can be given in modes: the rules,
+// named as they are for every mode, and what sets the mode apart.
+func modeFlags(mode *GameMode) *flag.FlagSet {
+	flags := flag.NewFlagSet(mode.Name, flag.ContinueOnError)
+
+	flags.BoolVar(&mode.Ranked, "ranked", mode.Ranked, "whether games change the players' rating")
+	flags.BoolVar(&mode.AgainstBots, "bots", mode.AgainstBots, "whether players are matched with bots")
+	flags.DurationVar(&mode.Backfill, "backfill", mode.Backfill, "how long players wait before a bot takes their opponent's place")
+	ruleFlags(flags, &mode.Rules)
+
+	return flags
+}
+
+// rateFlags are the settings an event can be given in event-limits.
+func rateFlags(name string, rate *Rate) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	flags.IntVar(&rate.Burst, "burst", rate.Burst, "events that can be sent at once, or 0 for no limit")
+	flags.DurationVar(&rate.Interval, "interval", rate.Interval, "how often another event can be sent")
+
+	return flags
+}
+
+// nestedSettings is a setting made of settings: a JSON object of
+// objects of settings by flag name, such as the modes and their rules.
+// Each time it's set replaces what it was set to before.
+type nestedSettings map[string]map[string]string
+
+func (n nestedSettings) String() string {
+	return ""
+}
+
+func (n nestedSettings) Set(value string) error {
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return err
+	}
+
+	for name := range n {
+		delete(n, name)
+	}
+	for name, values := range raw {
+		settings := make(map[string]string, len(values))
+		for setting, value := range values {
+			converted, ok := scalar(value)
+			if !ok {
+				return fmt.Errorf("%v: %v should be a string, number or boolean", name, setting)
+			}
+			settings[setting] = converted
+		}
+		n[name] = settings
+	}
+	return nil
+}
+
+// names are those settings are given for, sorted so they're applied the
+// same way every time.
+func (n nestedSettings) names() []string {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func envName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+func setAll(flags *flag.FlagSet, settings map[string]string) error {
+	for name, value := range settings {
+		if err := set(flags, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func set(flags *flag.FlagSet, name, value string) error {
+	if flags.Lookup(name) == nil {
+		return fmt.Errorf("%w: unknown setting %q", ErrInvalidConfig, name)
+	}
+	if err := flags.Set(name, value); err != nil {
+		return fmt.Errorf("%w: %v %q: %v", ErrInvalidConfig, name, value, err)
+	}
+	return nil
+}
+
+// readConfigFile reads a JSON object of settings by flag name. Values
+// are written as they would be on the command line, though numbers and
+// booleans can go without quotes, and settings made of settings, like
+// modes, as objects.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrInvalidConfig, path, err)
+	}
+
+	settings := make(map[string]string, len(raw))
+	for name, value := range raw {
+		if object, ok := value.(map[string]interface{}); ok {
+			data, _ := json.Marshal(object)
+			settings[name] = string(data)
+			continue
+		}
+
+		setting, ok := scalar(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v: %v should be a string, number, boolean or object", ErrInvalidConfig, path, name)
+		}
+		settings[name] = setting
+	}
+	return settings, nil
+}
+
+// scalar is a JSON value as it would be written on the command line, if
+// it can be.
+func scalar(value interface{}) (string, bool) {
+	switch value.(type) {
+	case string, bool, float64:
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
+
+// Validate tells whether the server can run with the config, listing
+// everything that's wrong with it if not.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(problem, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr is required")
+	check(c.Store != "", "db is required")
+	check(c.Secret != "", "secret is required to verify player tokens")
+	check(c.ShutdownGrace >= 0, "shutdown-grace can't be negative")
+
+	heartbeat := c.Server.Heartbeat
+	check(heartbeat.Interval > 0, "ping-interval must be positive")
+	check(heartbeat.Timeout > heartbeat.Interval, "read-timeout must be longer than ping-interval")
+	check(heartbeat.WriteTimeout > 0, "write-timeout must be positive")
+	check(c.Server.OldestVersion >= LegacyProtocolVersion && c.Server.OldestVersion <= ProtocolVersion,
+		"oldest-version must be between %v and %v", LegacyProtocolVersion, ProtocolVersion)
+
+	limits := c.Server.Limits
+	check(limits.MaxMessageSize >= 0, "max-message-size can't be negative")
+	check(limits.Connection.Burst >= 0, "rate-burst can't be negative")
+	check(limits.Connection.Burst == 0 || limits.Connection.Interval > 0, "rate-interval must be positive")
+	check(limits.Warnings >= 0 && limits.Mutes >= 0, "rate-warnings and mutes can't be negative")
+	check(limits.Mute >= 0 && limits.Forgive >= 0, "mute and forgive can't be negative")
+	for _, kind := range eventTypes {
+		rate := limits.Events[kind]
+		check(rate.Burst >= 0, "burst of %v can't be negative", kind)
+		check(rate.Burst == 0 || rate.Interval > 0, "interval of %v must be positive", kind)
+	}
+
+	window := c.Queue.Window
+	check(window.Base >= 0 && window.Growth >= 0, "window-base and window-growth can't be negative")
+	check(window.Max >= window.Base, "window-max can't be below window-base")
+	check(window.Step > 0, "window-step must be positive")
+	check(c.Queue.Rematch > 0, "rematch must be positive")
+	check(len(c.Queue.Modes) > 0, "at least one game mode is required")
+
+	check(c.Matchmaker.Confirm > 0, "confirm must be positive")
+	check(c.Game.Mulligan > 0, "mulligan must be positive")
+
+	for _, mode := range c.Queue.Modes {
+		rules := mode.Rules
+		check(mode.Name != "", "modes need a name")
+		check(mode.Backfill >= 0, "backfill of %v can't be negative", mode.Name)
+		check(rules.DeckSize >= rules.StartingHand, "deck-size of %v can't be smaller than its starting-hand", mode.Name)
+		check(rules.StartingHand >= 0, "starting-hand of %v can't be negative", mode.Name)
+		check(rules.StartingHealth > 0, "health of %v must be positive", mode.Name)
+		check(rules.MaxMana > 0, "max-mana of %v must be positive", mode.Name)
+		check(rules.BoardSize > 0, "board-size of %v must be positive", mode.Name)
+		check(rules.TurnDuration > 0, "turn of %v must be positive", mode.Name)
+		check(rules.ReconnectGrace >= 0, "reconnect-grace of %v can't be negative", mode.Name)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnv(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wingscam.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultConfigIsValidWithASecret(t *testing.T) {
+	config := DefaultConfig()
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected %v without a secret, got %v", ErrInvalidConfig, err)
+	}
+
+	config.Secret = "secret"
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"secret": "from-file",
+		"addr": "file:1",
+		"db": "file.db",
+		"turn": "45s",
+		"board-size": 5,
+		"log-events": true
+	}`)
+
+	env := testEnv(map[string]string{
+		"WINGSCAM_CONFIG": path,
+		"WINGSCAM_ADDR":   "env:2",
+		"WINGSCAM_DB":     "env.db",
+	})
+
+	config, err := LoadConfig([]string{"-db", "flag.db"}, env)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	if config.Secret != "from-file" {
+		t.Errorf("Expected the secret from the file, got %v", config.Secret)
+	}
+	if config.Addr != "env:2" {
+		t.Errorf("Expected the environment to win over the file, got %v", config.Addr)
+	}
+	if config.Store != "flag.db" {
+		t.Errorf("Expected flags to win over the environment, got %v", config.Store)
+	}
+	if !config.LogEvents {
+		t.Error("Expected events to be logged")
+	}
+	if config.ShutdownGrace != 2*time.Minute {
+		t.Errorf("Expected the default shutdown grace, got %v", config.ShutdownGrace)
+	}
+}
+
+func TestLoadConfigAppliesRulesToEveryMode(t *testing.T) {
+	args := []string{"-secret", "secret", "-turn", "45s", "-board-size", "5", "-max-mana", "8", "-backfill", "10s"}
+
+	config, err := LoadConfig(args, testEnv(nil))
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	if len(config.Queue.Modes) != len(DefaultModes) {
+		t.Fatalf("Expected %v modes, got %v", len(DefaultModes), len(config.Queue.Modes))
+	}
+
+	for _, mode := range config.Queue.Modes {
+		rules := mode.Rules
+		if rules.TurnDuration != 45*time.Second || rules.BoardSize != 5 || rules.MaxMana != 8 {
+			t.Errorf("Expected the rules to apply to %v, got %+v", mode.Name, rules)
+		}
+		if rules.DeckSize != DefaultRules.DeckSize {
+			t.Errorf("Expected %v to keep the default deck size, got %v", mode.Name, rules.DeckSize)
+		}
+	}
+
+	// only modes that backfill have their backfill changed
+	for _, mode := range config.Queue.Modes {
+		expected := time.Duration(0)
+		if mode.Name == CasualMode.Name {
+			expected = 10 * time.Second
+		}
+		if mode.Backfill != expected {
+			t.Errorf("Expected %v to backfill after %v, got %v", mode.Name, expected, mode.Backfill)
+		}
+	}
+
+	if DefaultModes[0].Rules != DefaultRules {
+		t.Error("Expected the default modes to be left alone")
+	}
+}
+
+func TestLoadConfigRejectsUnknownSettings(t *testing.T) {
+	path := writeConfigFile(t, `{"secret": "secret", "turn-length": "45s"}`)
+
+	_, err := LoadConfig([]string{"-config", path}, testEnv(nil))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "turn-length") {
+		t.Errorf("Expected the unknown setting to be rejected, got %v", err)
+	}
+
+	_, err = LoadConfig(nil, testEnv(map[string]string{"WINGSCAM_SECRET": "secret", "WINGSCAM_TURN": "soon"}))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "WINGSCAM_TURN") {
+		t.Errorf("Expected the malformed setting to be rejected, got %v", err)
+	}
+
+	if _, err := LoadConfig([]string{"-turns", "45s"}, testEnv(nil)); err == nil {
+		t.Error("Expected the unknown flag to be rejected")
+	}
+}
+
+func TestLoadConfigListsEveryProblem(t *testing.T) {
+	args := []string{"-secret", "secret", "-ping-interval", "1m", "-window-max", "10", "-board-size", "0"}
+
+	_, err := LoadConfig(args, testEnv(nil))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected %v, got %v", ErrInvalidConfig, err)
+	}
+
+	for _, problem := range []string{"read-timeout", "window-max", "board-size of casual"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q among the problems, got %v", problem, err)
+		}
+	}
+}
+
+func TestLoadConfigModesHaveTheirOwnRules(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"secret": "secret",
+		"turn": "45s",
+		"modes": {
+			"ranked": {"turn": "90s", "board-size": 5},
+			"blitz": {"ranked": true, "turn": "20s", "health": 15}
+		}
+	}`)
+
+	config, err := LoadConfig([]string{"-config", path}, testEnv(nil))
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	rules := make(map[string]Rules)
+	for _, mode := range config.Queue.Modes {
+		rules[mode.Name] = mode.Rules
+	}
+
+	if rules["casual"].TurnDuration != 45*time.Second || rules["casual"].BoardSize != DefaultRules.BoardSize {
+		t.Errorf("Expected casual to keep the rules given for every mode, got %+v", rules["casual"])
+	}
+	if rules["ranked"].TurnDuration != 90*time.Second || rules["ranked"].BoardSize != 5 {
+		t.Errorf("Expected ranked to have its own rules, got %+v", rules["ranked"])
+	}
+
+	blitz := config.Queue.Modes[len(config.Queue.Modes)-1]
+	if blitz.Name != "blitz" || !blitz.Ranked {
+		t.Fatalf("Expected a ranked blitz mode to be added, got %+v", blitz)
+	}
+	if blitz.Rules.TurnDuration != 20*time.Second || blitz.Rules.StartingHealth != 15 || blitz.Rules.DeckSize != DefaultRules.DeckSize {
+		t.Errorf("Expected blitz to have its own rules over the others, got %+v", blitz.Rules)
+	}
+
+	_, err = LoadConfig([]string{"-secret", "secret", "-modes", `{"casual": {"turns": "45s"}}`}, testEnv(nil))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "turns") {
+		t.Errorf("Expected the unknown mode setting to be rejected, got %v", err)
+	}
+}
+
+func TestLoadConfigEventLimits(t *testing.T) {
+	env := testEnv(map[string]string{
+		"WINGSCAM_SECRET":       "secret",
+		"WINGSCAM_EVENT_LIMITS": `{"play_card": {"burst": 4}, "get_decks": {"burst": 2, "interval": "1s"}}`,
+	})
+
+	config, err := LoadConfig(nil, env)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	events := config.Server.Limits.Events
+	if rate := events[PlayCard]; rate.Burst != 4 || rate.Interval != DefaultLimits.Events[PlayCard].Interval {
+		t.Errorf("Expected only the burst of %v to change, got %+v", PlayCard, rate)
+	}
+	if rate := events[GetDecks]; rate != (Rate{Burst: 2, Interval: time.Second}) {
+		t.Errorf("Expected %v to be limited, got %+v", GetDecks, rate)
+	}
+	if events[EndTurn] != DefaultLimits.Events[EndTurn] {
+		t.Errorf("Expected %v to keep its default limit, got %+v", EndTurn, events[EndTurn])
+	}
+	if DefaultLimits.Events[PlayCard].Burst == 4 {
+		t.Error("Expected the default limits to be left alone")
+	}
+
+	_, err = LoadConfig([]string{"-secret", "secret", "-event-limits", `{"play_cards": {"burst": 4}}`}, testEnv(nil))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "play_cards") {
+		t.Errorf("Expected the unknown event to be rejected, got %v", err)
+	}
+}
@@ -1,45 +1,204 @@
 package server
 
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrUnhandled is what events nothing handles fail with, for players
+// waiting to hear back about them.
+var ErrUnhandled = errors.New("nothing handles this event, or not anymore")
+
 type Handler interface {
 	Process(event Event, dispatcher *Dispatcher)
 }
 
+// Subscription matches events of a type, and only those meant for Key
+// unless it's empty. See Event.RoutingKey for what events are meant for.
+type Subscription struct {
+	Type EventType
+	Key  string
+}
+
+// Subscriber is a handler that only gets the events it subscribes to.
+// Handlers that aren't subscribers get every event.
+type Subscriber interface {
+	Handler
+	Subscriptions() []Subscription
+}
+
+// Overflow is what happens to an event for a handler whose mailbox is
+// full.
+type Overflow int
+
+const (
+	// Block waits for room, holding up every other event meanwhile.
+	Block Overflow = iota
+	// Drop throws the event away.
+	Drop
+	// Reject throws the event away and tells its player to try again.
+	Reject
+)
+
+// Mailbox is how many events a handler can fall behind by, and what to
+// do with events past that.
+type Mailbox struct {
+	Size     int
+	Overflow Overflow
+}
+
+var DefaultMailbox = Mailbox{Size: 64, Overflow: Block}
+
+// HasMailbox is a handler that wants something other than the default
+// mailbox.
+type HasMailbox interface {
+	Handler
+	Mailbox() Mailbox
+}
+
+// mailbox feeds events to its handler from a goroutine of its own, so a
+// slow handler only holds up its own events.
+type mailbox struct {
+	handler  Handler
+	overflow Overflow
+	events   chan posted
+}
+
+// posted is an event in a mailbox, and when it was put there.
+type posted struct {
+	event Event
+	at    time.Time
+}
+
+func newMailbox(handler Handler, dispatcher *Dispatcher) *mailbox {
+	config := DefaultMailbox
+	if owner, ok := handler.(HasMailbox); ok {
+		config = owner.Mailbox()
+	}
+
+	mailbox := &mailbox{
+		handler:  handler,
+		overflow: config.Overflow,
+		events:   make(chan posted, config.Size),
+	}
+
+	dispatcher.running.Add(1)
+	go func() {
+		defer dispatcher.running.Done()
+
+		for posted := range mailbox.events {
+			handleSafely(handler, posted.event, dispatcher)
+			dispatcher.metrics.observeDispatch(posted.event.Type, time.Since(posted.at))
+		}
+	}()
+
+	return mailbox
+}
+
+func (m *mailbox) post(event Event) {
+	letter := posted{event: event, at: time.Now()}
+
+	if m.overflow == Block {
+		m.events <- letter
+		return
+	}
+
+	select {
+	case m.events <- letter:
+		return
+	default:
+	}
+
+	if m.overflow == Reject && event.Player != nil {
+		go event.Fail("Server is busy, try again")
+		return
+	}
+
+	log.Printf("Dropped %v event for %T, its mailbox is full\n", event.Type, m.handler)
+}
+
 type Dispatcher struct {
-	handlers []Handler
+	handlers  []*mailbox
+	routes    map[Subscription][]*mailbox
+	mailboxes map[Handler]*mailbox
+
+	// what events sent on Dispatch go through before being delivered
+	chain Next
+
+	metrics *Metrics
+
+	// mailbox goroutines, and handlers stopped after the mailboxes
+	// were closed
+	running sync.WaitGroup
+	late    sync.WaitGroup
+	closing bool
+	stopCtx context.Context
+	done    chan bool
+
+	// work emitted from handlers, waiting for the dispatcher's goroutine
+	mutex   sync.Mutex
+	pending []func()
+	wake    chan bool
+	direct  bool
 
 	Dispatch   chan Event
 	Register   chan Handler
 	Unregister chan Handler
 }
 
-func NewDispatcher() *Dispatcher {
+// NewDispatcher makes a dispatcher whose events sent on Dispatch go
+// through middlewares first. Events emitted by handlers don't, since
+// the server is the one sending them.
+func NewDispatcher(middlewares ...Middleware) *Dispatcher {
+	return NewDispatcherWithMetrics(NewMetrics(), middlewares...)
+}
+
+// NewDispatcherWithMetrics makes a dispatcher whose handlers report to
+// metrics.
+func NewDispatcherWithMetrics(metrics *Metrics, middlewares ...Middleware) *Dispatcher {
 	dispatcher := &Dispatcher{
-		handlers: make([]Handler, 0),
+		handlers:  make([]*mailbox, 0),
+		routes:    make(map[Subscription][]*mailbox),
+		mailboxes: make(map[Handler]*mailbox),
+		metrics:   metrics,
+
+		wake: make(chan bool, 1),
+		done: make(chan bool),
 
 		Dispatch:   make(chan Event),
 		Register:   make(chan Handler),
 		Unregister: make(chan Handler),
 	}
 
+	dispatcher.chain = Chain(func(event Event) error {
+		dispatcher.deliver(event)
+		return nil
+	}, middlewares...)
+
 	go func() {
 		for {
+			// a handler sent on Register is added straight away, since
+			// whoever sent it may already be emitting its events, but
+			// otherwise anything emitted earlier is handled first
 			select {
+			case <-dispatcher.wake:
+				dispatcher.drain()
 			case handler := <-dispatcher.Register:
-				dispatcher.handlers = append(dispatcher.handlers, handler)
+				dispatcher.add(handler)
+				dispatcher.drain()
 			case handler := <-dispatcher.Unregister:
-				for i, handle := range dispatcher.handlers {
-					if handle == handler {
-						// remove item
-						dispatcher.handlers = append(
-							dispatcher.handlers[:i],
-							dispatcher.handlers[i+1:]...,
-						)
-					}
-				}
+				dispatcher.drain()
+				dispatcher.remove(handler)
 			case event := <-dispatcher.Dispatch:
-				for _, handler := range dispatcher.handlers {
-					handler.Process(event, dispatcher)
+				dispatcher.drain()
+				if err := dispatcher.chain(event); err != nil {
+					reject(event, err)
 				}
+			case <-dispatcher.done:
+				return
 			}
 		}
 	}()
@@ -47,9 +206,275 @@ func NewDispatcher() *Dispatcher {
 	return dispatcher
 }
 
+// Emit dispatches an event without waiting for the dispatcher, so it's
+// safe to call from handlers. Events emitted from the same goroutine are
+// delivered in order.
+func (d *Dispatcher) Emit(event Event) {
+	if d.direct {
+		d.Dispatch <- event
+		return
+	}
+
+	d.queue(func() {
+		d.deliver(event)
+	})
+}
+
+// Add registers a handler without waiting for the dispatcher. Events
+// emitted after it reach the handler.
+func (d *Dispatcher) Add(handler Handler) {
+	if d.direct {
+		d.Register <- handler
+		return
+	}
+
+	d.queue(func() {
+		d.add(handler)
+	})
+}
+
+// Remove unregisters a handler without waiting for the dispatcher.
+func (d *Dispatcher) Remove(handler Handler) {
+	if d.direct {
+		d.Unregister <- handler
+		return
+	}
+
+	d.queue(func() {
+		d.remove(handler)
+	})
+}
+
+// Shutdown stops every handler that's a Stopper, including those added
+// while others stop, then lets every handler finish with the events it
+// already has and stops the dispatcher. Anything sent to it afterwards
+// is ignored.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return nil
+	default:
+	}
+
+	stopped := make(map[Handler]bool)
+
+	for {
+		var stoppers []Stopper
+		d.call(func() {
+			for handler := range d.mailboxes {
+				if stopper, ok := handler.(Stopper); ok && !stopped[handler] {
+					stoppers = append(stoppers, stopper)
+				}
+			}
+		})
+
+		if len(stoppers) == 0 {
+			break
+		}
+
+		var stopping sync.WaitGroup
+		for _, stopper := range stoppers {
+			stopped[stopper] = true
+			stopping.Add(1)
+
+			go func(stopper Stopper) {
+				defer stopping.Done()
+				stopper.Stop(ctx)
+			}(stopper)
+		}
+		stopping.Wait()
+	}
+
+	d.call(func() {
+		d.closing = true
+		d.stopCtx = ctx
+
+		for handler := range d.mailboxes {
+			d.remove(handler)
+		}
+	})
+
+	err := wait(ctx, &d.running)
+	if err == nil {
+		// whatever the last of them left behind is added, and stopped,
+		// before waiting on those stopping
+		d.call(func() {})
+		err = wait(ctx, &d.late)
+	}
+
+	close(d.done)
+	return err
+}
+
+// Metrics is what the dispatcher and its handlers report to.
+func (d *Dispatcher) Metrics() *Metrics {
+	return d.metrics
+}
+
+// Done is closed once the dispatcher has shut down.
+func (d *Dispatcher) Done() <-chan bool {
+	return d.done
+}
+
+// call runs work on the dispatcher's goroutine and waits for it.
+func (d *Dispatcher) call(work func()) {
+	done := make(chan bool)
+	d.queue(func() {
+		work()
+		close(done)
+	})
+	<-done
+}
+
+func (d *Dispatcher) queue(work func()) {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+
+	d.mutex.Lock()
+	d.pending = append(d.pending, work)
+	d.mutex.Unlock()
+
+	select {
+	case d.wake <- true:
+	default:
+	}
+}
+
+func (d *Dispatcher) drain() {
+	for {
+		d.mutex.Lock()
+		pending := d.pending
+		d.pending = nil
+		d.mutex.Unlock()
+
+		if len(pending) == 0 {
+			return
+		}
+
+		for _, work := range pending {
+			work()
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(event Event) {
+	recipients := d.recipients(event)
+
+	// whoever's waiting on the event would otherwise never hear back,
+	// as when the game it's for is over
+	if len(recipients) == 0 && event.Id != "" {
+		go event.Fail(ErrUnhandled.Error())
+		return
+	}
+
+	for _, mailbox := range recipients {
+		mailbox.post(event)
+	}
+}
+
+// recipients lists who an event goes to, handlers getting everything
+// first, then subscribers to its type and then those to its key.
+func (d *Dispatcher) recipients(event Event) []*mailbox {
+	mailboxes := append([]*mailbox{}, d.handlers...)
+	mailboxes = append(mailboxes, d.routes[Subscription{Type: event.Type}]...)
+
+	if key := event.RoutingKey(); key != "" {
+		mailboxes = append(mailboxes, d.routes[Subscription{Type: event.Type, Key: key}]...)
+	}
+
+	return mailboxes
+}
+
+func (d *Dispatcher) add(handler Handler) {
+	if _, ok := d.mailboxes[handler]; ok {
+		return
+	}
+
+	// it's too late to get events, but not to be stopped
+	if d.closing {
+		if stopper, ok := handler.(Stopper); ok {
+			d.late.Add(1)
+			go func() {
+				defer d.late.Done()
+				stopper.Stop(d.stopCtx)
+			}()
+		}
+		return
+	}
+
+	mailbox := newMailbox(handler, d)
+	d.mailboxes[handler] = mailbox
+
+	subscriber, ok := handler.(Subscriber)
+	if !ok {
+		d.handlers = append(d.handlers, mailbox)
+		return
+	}
+
+	for _, subscription := range subscriber.Subscriptions() {
+		d.routes[subscription] = append(d.routes[subscription], mailbox)
+	}
+}
+
+func (d *Dispatcher) remove(handler Handler) {
+	mailbox, ok := d.mailboxes[handler]
+	if !ok {
+		return
+	}
+
+	delete(d.mailboxes, handler)
+	// the handler still gets what's already in its mailbox
+	close(mailbox.events)
+
+	subscriber, ok := handler.(Subscriber)
+	if !ok {
+		d.handlers = without(d.handlers, mailbox)
+		return
+	}
+
+	for _, subscription := range subscriber.Subscriptions() {
+		mailboxes := without(d.routes[subscription], mailbox)
+
+		if len(mailboxes) == 0 {
+			delete(d.routes, subscription)
+		} else {
+			d.routes[subscription] = mailboxes
+		}
+	}
+}
+
+// subscribe subscribes to every one of types meant for key, or to all of
+// them when key is empty.
+func subscribe(key string, types ...EventType) []Subscription {
+	subscriptions := make([]Subscription, 0, len(types))
+	for _, kind := range types {
+		subscriptions = append(subscriptions, Subscription{Type: kind, Key: key})
+	}
+	return subscriptions
+}
+
+func without(mailboxes []*mailbox, mailbox *mailbox) []*mailbox {
+	for i, box := range mailboxes {
+		if box == mailbox {
+			// remove item
+			return append(mailboxes[:i:i], mailboxes[i+1:]...)
+		}
+	}
+	return mailboxes
+}
+
+// NewTestDispatcher doesn't deliver anything. What handlers emit, add or
+// remove is sent straight to its channels instead, for tests to read.
 func NewTestDispatcher() *Dispatcher {
 	return &Dispatcher{
-		handlers: make([]Handler, 0),
+		handlers:  make([]*mailbox, 0),
+		routes:    make(map[Subscription][]*mailbox),
+		mailboxes: make(map[Handler]*mailbox),
+		metrics:   NewMetrics(),
+		direct:    true,
 
 		Register: make(chan Handler),
 		Dispatch: make(chan Event),
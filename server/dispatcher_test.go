@@ -1,7 +1,11 @@
 package server
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type TestHandler struct {
@@ -28,3 +32,332 @@ func TestRegisterHandlers(t *testing.T) {
 		t.Error("Expected handler to be executed")
 	}
 }
+
+type SubscribedHandler struct {
+	Events        chan Event
+	subscriptions []Subscription
+}
+
+func (s *SubscribedHandler) Subscriptions() []Subscription {
+	return s.subscriptions
+}
+
+func (s *SubscribedHandler) Process(event Event, dispatcher *Dispatcher) {
+	s.Events <- event
+}
+
+func TestRoutesEventsByType(t *testing.T) {
+	dispatcher := NewDispatcher()
+	handler := &SubscribedHandler{
+		Events:        make(chan Event, 2),
+		subscriptions: subscribe("", QueueUp),
+	}
+
+	dispatcher.Register <- handler
+
+	dispatcher.Dispatch <- Event{Type: Dequeue}
+	dispatcher.Dispatch <- Event{Type: QueueUp}
+
+	// a handler gets its events in order, so the first one it gets
+	// tells whether the other was delivered
+	select {
+	case event := <-handler.Events:
+		if event.Type != QueueUp {
+			t.Errorf("Expected only %v, got %v", QueueUp, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler to get the subscribed event")
+	}
+}
+
+func TestRoutesEventsByKey(t *testing.T) {
+	dispatcher := NewDispatcher()
+
+	first, second := uuid.New(), uuid.New()
+	handlers := map[uuid.UUID]*SubscribedHandler{}
+
+	for _, id := range []uuid.UUID{first, second} {
+		handlers[id] = &SubscribedHandler{
+			Events:        make(chan Event, 1),
+			subscriptions: subscribe(id.String(), PlayCard, EndTurn),
+		}
+		dispatcher.Register <- handlers[id]
+	}
+
+	dispatcher.Dispatch <- Event{
+		Type:    PlayCard,
+		Payload: PlayCardPayload{GameId: second.String()},
+	}
+
+	select {
+	case <-handlers[second].Events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler for the game to get the event")
+	}
+
+	dispatcher.Unregister <- handlers[second]
+	dispatcher.Dispatch <- Event{Type: EndTurn, Payload: second.String()}
+	dispatcher.Dispatch <- Event{Type: EndTurn, Payload: first.String()}
+
+	select {
+	case <-handlers[first].Events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler for the game to get the event")
+	}
+
+	if len(handlers[second].Events) != 0 {
+		t.Error("Expected unregistered handler not to get events")
+	}
+}
+
+// StuckHandler doesn't finish handling an event until it's released.
+type StuckHandler struct {
+	mailbox Mailbox
+	release chan bool
+	handled chan Event
+}
+
+func NewStuckHandler(mailbox Mailbox) *StuckHandler {
+	return &StuckHandler{
+		mailbox: mailbox,
+		release: make(chan bool),
+		handled: make(chan Event, 16),
+	}
+}
+
+func (s *StuckHandler) Mailbox() Mailbox {
+	return s.mailbox
+}
+
+func (s *StuckHandler) Process(event Event, dispatcher *Dispatcher) {
+	<-s.release
+	s.handled <- event
+}
+
+func TestStuckHandlerDoesNotHoldUpOthers(t *testing.T) {
+	dispatcher := NewDispatcher()
+	stuck := NewStuckHandler(DefaultMailbox)
+	handler := &TestHandler{Executed: make(chan bool, 2)}
+
+	dispatcher.Register <- stuck
+	dispatcher.Register <- handler
+
+	dispatcher.Dispatch <- Event{Type: QueueUp}
+	dispatcher.Dispatch <- Event{Type: Dequeue}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handler.Executed:
+		case <-time.After(time.Second):
+			t.Fatal("Expected handler to get events while another is stuck")
+		}
+	}
+
+	close(stuck.release)
+}
+
+func TestRejectsEventsWhenMailboxIsFull(t *testing.T) {
+	player := NewTestPlayer()
+	dispatcher := NewDispatcher()
+	stuck := NewStuckHandler(Mailbox{Size: 1, Overflow: Reject})
+
+	dispatcher.Register <- stuck
+
+	// one is being handled at most, and one waits in the mailbox
+	for i := 0; i < 3; i++ {
+		dispatcher.Dispatch <- Event{Type: QueueUp, Player: player}
+	}
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected player to be told the event was rejected")
+	}
+
+	close(stuck.release)
+}
+
+func TestDropsEventsWhenMailboxIsFull(t *testing.T) {
+	player := NewTestPlayer()
+	dispatcher := NewDispatcher()
+	stuck := NewStuckHandler(Mailbox{Size: 1, Overflow: Drop})
+
+	dispatcher.Register <- stuck
+
+	for i := 0; i < 4; i++ {
+		dispatcher.Dispatch <- Event{Type: QueueUp, Player: player}
+	}
+
+	close(stuck.release)
+
+	handled := 0
+	for done := false; !done; {
+		select {
+		case <-stuck.handled:
+			handled++
+		case <-time.After(100 * time.Millisecond):
+			done = true
+		}
+	}
+
+	// one being handled at most, and one in the mailbox
+	if handled == 0 || handled > 2 {
+		t.Errorf("Expected full mailbox to drop events, %v were handled", handled)
+	}
+
+	select {
+	case response := <-player.Outgoing:
+		t.Errorf("Expected dropped events to go unanswered, got %v", response.Type)
+	default:
+	}
+}
+
+// ChainHandler emits the next event of a chain while handling one, which
+// would deadlock a dispatcher that waits for handlers.
+type ChainHandler struct {
+	done chan bool
+}
+
+func (c *ChainHandler) Process(event Event, dispatcher *Dispatcher) {
+	switch event.Type {
+	case QueueUp:
+		dispatcher.Emit(Event{Type: Dequeue})
+	case Dequeue:
+		dispatcher.Emit(Event{Type: Login})
+	case Login:
+		c.done <- true
+	}
+}
+
+func TestHandlersCanEmitEvents(t *testing.T) {
+	dispatcher := NewDispatcher()
+	handler := &ChainHandler{done: make(chan bool)}
+
+	dispatcher.Register <- handler
+	dispatcher.Dispatch <- Event{Type: QueueUp}
+
+	select {
+	case <-handler.done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected every event of the chain to be handled")
+	}
+}
+
+func TestAddedHandlerGetsEventsEmittedAfter(t *testing.T) {
+	dispatcher := NewDispatcher()
+	handler := &TestHandler{Executed: make(chan bool, 1)}
+
+	dispatcher.Add(handler)
+	dispatcher.Emit(Event{Type: QueueUp})
+
+	select {
+	case <-handler.Executed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler to get the event")
+	}
+
+	dispatcher.Remove(handler)
+	dispatcher.Emit(Event{Type: QueueUp})
+
+	// a barrier emitted after, once handled, means the event was routed
+	barrier := &TestHandler{Executed: make(chan bool)}
+	dispatcher.Add(barrier)
+	dispatcher.Emit(Event{Type: Dequeue})
+	<-barrier.Executed
+
+	if len(handler.Executed) != 0 {
+		t.Error("Expected removed handler not to get events")
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	id := uuid.New()
+
+	cases := []struct {
+		event Event
+		key   string
+	}{
+		{Event{Type: AskConfirmation, Payload: id}, id.String()},
+		{Event{Type: EndTurn, Payload: strings.ToUpper(id.String())}, id.String()},
+		{Event{Type: EndTurn, Payload: "not an id"}, ""},
+		{Event{Type: PlayCard, Payload: PlayCardPayload{GameId: id.String()}}, id.String()},
+		{Event{Type: Attack, Payload: map[string]interface{}{"GameId": id.String()}}, id.String()},
+		{Event{Type: QueueUp, Payload: QueueUpPayload{}}, ""},
+	}
+
+	for _, c := range cases {
+		if key := c.event.RoutingKey(); key != c.key {
+			t.Errorf("Expected %q for %v, got %q", c.key, c.event.Type, key)
+		}
+	}
+}
+
+// benchmarkGame checks events the way Game does, so broadcasting to it
+// costs what broadcasting to a game would.
+type benchmarkGame struct {
+	id   uuid.UUID
+	done chan bool
+}
+
+func (g *benchmarkGame) Subscriptions() []Subscription {
+	return subscribe(g.id.String(), PlayCard)
+}
+
+func (g *benchmarkGame) Process(event Event, dispatcher *Dispatcher) {
+	var data PlayCardPayload
+
+	if err := event.Decode(&data); err != nil {
+		return
+	}
+
+	if id, err := uuid.Parse(data.GameId); err != nil || id != g.id {
+		return
+	}
+
+	g.done <- true
+}
+
+// broadcastGame is a benchmarkGame that isn't a Subscriber, so it gets
+// every event like all handlers used to.
+type broadcastGame struct {
+	game *benchmarkGame
+}
+
+func (g *broadcastGame) Process(event Event, dispatcher *Dispatcher) {
+	g.game.Process(event, dispatcher)
+}
+
+func benchmarkDispatch(b *testing.B, games int, routed bool) {
+	dispatcher := NewDispatcher()
+
+	var target *benchmarkGame
+	for i := 0; i < games; i++ {
+		target = &benchmarkGame{id: uuid.New(), done: make(chan bool)}
+
+		if routed {
+			dispatcher.Register <- target
+		} else {
+			dispatcher.Register <- &broadcastGame{game: target}
+		}
+	}
+
+	event := Event{
+		Type:    PlayCard,
+		Payload: PlayCardPayload{GameId: target.id.String()},
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dispatcher.Dispatch <- event
+		<-target.done
+	}
+}
+
+func BenchmarkDispatchBroadcast10(b *testing.B)   { benchmarkDispatch(b, 10, false) }
+func BenchmarkDispatchBroadcast1000(b *testing.B) { benchmarkDispatch(b, 1000, false) }
+func BenchmarkDispatchRouted10(b *testing.B)      { benchmarkDispatch(b, 10, true) }
+func BenchmarkDispatchRouted1000(b *testing.B)    { benchmarkDispatch(b, 1000, true) }
@@ -1,40 +1,163 @@
 package server
 
+import (
+	"github.com/google/uuid"
+)
+
 type Event struct {
+	// Id is set by clients that want to hear how the event turned out:
+	// it's acknowledged with an Ack or a Nack, and the responses to it
+	// carry it as their RequestId
+	Id      string
 	Type    EventType
 	Player  *Player
 	Payload interface{}
 }
 
+// Ack sends the player who sent the event the responses to it, and then
+// an Ack if they gave the event an id.
+func (e Event) Ack(responses ...Response) {
+	if e.Player == nil {
+		return
+	}
+
+	for _, response := range responses {
+		response.RequestId = e.Id
+		e.Player.Send(response)
+	}
+
+	if e.Id != "" {
+		e.Player.settle(Response{Type: Ack, RequestId: e.Id})
+	}
+}
+
+// Fail tells the player who sent the event why it couldn't be applied,
+// with a Nack if they gave it an id or an Error otherwise.
+func (e Event) Fail(reason string) {
+	if e.Player == nil {
+		return
+	}
+
+	if e.Id == "" {
+		e.Player.Send(Response{Type: Error, Payload: reason})
+		return
+	}
+	e.Player.settle(Response{Type: Nack, Payload: reason, RequestId: e.Id})
+}
+
+// RoutingKey is the id of the match or game the event is meant for, or
+// empty for events that aren't meant for one in particular.
+func (e Event) RoutingKey() string {
+	var key string
+
+	switch e.Type {
+	case AskConfirmation:
+		if id, ok := e.Payload.(uuid.UUID); ok {
+			return id.String()
+		}
+	case MatchConfirmed, MatchDeclined, EndTurn, Concede:
+		var id uuid.UUID
+		if e.Decode(&id) == nil {
+			return id.String()
+		}
+	case CardsDiscarded:
+		var data CardsDiscardedPayload
+		e.peek(&data)
+		key = data.GameId
+	case PlayCard:
+		var data PlayCardPayload
+		e.peek(&data)
+		key = data.GameId
+	case Attack, AttackPlayer:
+		var data AttackPayload
+		e.peek(&data)
+		key = data.GameId
+	}
+
+	// ids can be written more than one way
+	id, err := uuid.Parse(key)
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
 type EventType string
 
 const (
-	QueueUp         EventType = "queue_up"
-	Dequeue         EventType = "dequeue"
-	CreateMatch     EventType = "create_match"
-	MatchConfirmed  EventType = "match_confirmed"
-	MatchDeclined   EventType = "match_declined"
-	AskConfirmation EventType = "confirm_match"
-	StartGame       EventType = "start_game"
-	CardsDiscarded  EventType = "cards_discarded"
-	EndTurn         EventType = "end_turn"
-	PlayCard        EventType = "play_card"
-	Attack          EventType = "attack"
-	AttackPlayer    EventType = "attack_player"
+	Login              EventType = "login"
+	QueueUp            EventType = "queue_up"
+	Dequeue            EventType = "dequeue"
+	CreateMatch        EventType = "create_match"
+	MatchConfirmed     EventType = "match_confirmed"
+	MatchDeclined      EventType = "match_declined"
+	AskConfirmation    EventType = "confirm_match"
+	StartGame          EventType = "start_game"
+	CardsDiscarded     EventType = "cards_discarded"
+	EndTurn            EventType = "end_turn"
+	PlayCard           EventType = "play_card"
+	Attack             EventType = "attack"
+	AttackPlayer       EventType = "attack_player"
+	Concede            EventType = "concede"
+	PlayerLoggedIn     EventType = "player_logged_in"
+	PlayerDisconnected EventType = "player_disconnected"
+	GameFinished       EventType = "game_finished"
+	SaveDeck           EventType = "save_deck"
+	GetDecks           EventType = "get_decks"
+	GetMatchHistory    EventType = "get_match_history"
+	CreateLobby        EventType = "create_lobby"
+	JoinLobby          EventType = "join_lobby"
+	LeaveLobby         EventType = "leave_lobby"
+	SelectDeck         EventType = "select_deck"
+	LobbyReady         EventType = "lobby_ready"
+	ChallengePlayer    EventType = "challenge_player"
+	AcceptChallenge    EventType = "accept_challenge"
+	DeclineChallenge   EventType = "decline_challenge"
+	GetReplay          EventType = "get_replay"
 )
 
+type QueueUpPayload struct {
+	Mode string
+}
+
 type CardsDiscardedPayload struct {
-	GameId string
-	Cards  []string
+	GameId string   `payload:"required,uuid"`
+	Cards  []string `payload:"uuid"`
 }
 
 type PlayCardPayload struct {
-	GameId string
-	Card   string
+	GameId string `payload:"required,uuid"`
+	Card   string `payload:"required,uuid"`
 }
 
+// AttackPayload has no Target when attacking the other player.
 type AttackPayload struct {
-	GameId   string
-	Attacker string
-	Target   string
+	GameId   string `payload:"required,uuid"`
+	Attacker string `payload:"required,uuid"`
+	Target   string `payload:"uuid"`
+}
+
+type MatchHistoryPayload struct {
+	Limit int
+}
+
+type CreateLobbyPayload struct {
+	Mode string
+}
+
+type JoinLobbyPayload struct {
+	Code string `payload:"required"`
+}
+
+type SelectDeckPayload struct {
+	DeckId string `payload:"required"`
+}
+
+type ChallengePayload struct {
+	AccountId string `payload:"required"`
+	Mode      string
+}
+
+type ChallengeAnswerPayload struct {
+	ChallengeId string `payload:"required,uuid"`
 }
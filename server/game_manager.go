@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/mitchellh/mapstructure"
 )
 
 type Deck struct {
@@ -13,8 +16,11 @@ type Deck struct {
 }
 
 func (d *Deck) Draw() HasManaCost {
+	if len(d.cards) == 0 {
+		return nil
+	}
 	card := d.cards[0]
-	d.cards = append(d.cards[1:])
+	d.cards = d.cards[1:]
 	return card
 }
 
@@ -24,7 +30,7 @@ func (d *Deck) Count() int {
 
 func (d *Deck) DrawMany(count int) []HasManaCost {
 	var cards []HasManaCost
-	for i := 0; i < count; i++ {
+	for i := 0; i < count && d.Count() > 0; i++ {
 		cards = append(cards, d.Draw())
 	}
 	return cards
@@ -34,11 +40,83 @@ func (d *Deck) Add(card HasManaCost) {
 	d.cards = append(d.cards, card)
 }
 
-func NewDeck() *Deck {
+type CardSpec struct {
+	Name     string
+	ManaCost int
+	Damage   int
+	Health   int
+}
+
+// Label names the card in reports, falling back to its stats for cards
+// without a name.
+func (s CardSpec) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%v/%v/%v", s.ManaCost, s.Damage, s.Health)
+}
+
+// MaxCardStat is the most mana, damage or health a card players build
+// decks with can have.
+const MaxCardStat = 10
+
+// Validate tells what's wrong with a card players want in their deck, if
+// anything. Cards cost no less than nothing, can't heal what they hit
+// and have some health to put on the board.
+func (s CardSpec) Validate() error {
+	switch {
+	case s.ManaCost < 0 || s.ManaCost > MaxCardStat:
+		return fmt.Errorf("mana cost must be from 0 to %v", MaxCardStat)
+	case s.Damage < 0 || s.Damage > MaxCardStat:
+		return fmt.Errorf("damage must be from 0 to %v", MaxCardStat)
+	case s.Health < 1 || s.Health > MaxCardStat:
+		return fmt.Errorf("health must be from 1 to %v", MaxCardStat)
+	}
+	return nil
+}
+
+type DeckList struct {
+	Id    string
+	Owner string
+	Name  string
+	Cards []CardSpec
+}
+
+// Validate tells what's wrong with a deck players built, if anything.
+func (l DeckList) Validate() error {
+	if len(l.Cards) == 0 {
+		return errors.New("deck has no cards")
+	}
+
+	for idx, card := range l.Cards {
+		if err := card.Validate(); err != nil {
+			return fmt.Errorf("card %v: %w", idx+1, err)
+		}
+	}
+	return nil
+}
+
+func NewDeckFromList(list DeckList) *Deck {
+	var cards []HasManaCost
+	for _, spec := range list.Cards {
+		cards = append(
+			cards,
+			NewMinion(spec.ManaCost, spec.Damage, spec.Health),
+		)
+	}
+
+	rand.Shuffle(len(cards), func(i, j int) {
+		cards[i], cards[j] = cards[j], cards[i]
+	})
+
+	return &Deck{cards: cards}
+}
+
+func NewDeck(size int) *Deck {
 	rand.Seed(time.Now().UnixNano())
 
 	var cards []HasManaCost
-	for i := 0; i < 60; i++ {
+	for i := 0; i < size; i++ {
 		cards = append(
 			cards,
 			NewMinion(rand.Intn(10), rand.Intn(10), rand.Intn(10)),
@@ -50,10 +128,21 @@ func NewDeck() *Deck {
 type Discarded struct {
 	Cards  []string
 	Player *Player
+	Event  Event
 }
 
+// DefaultMaxMana is how much mana players build up to unless the rules
+// say otherwise.
+const DefaultMaxMana = 10
+
 type GamePlayer struct {
+	// player changes when they reconnect, while responses sent earlier
+	// may still be on their way
+	mutex  sync.Mutex
 	player *Player
+	// away is set while the player is disconnected, and closed once
+	// they're back
+	away chan bool
 
 	Id      uuid.UUID
 	Deck    *Deck
@@ -62,8 +151,12 @@ type GamePlayer struct {
 	MaxMana int
 	Board   *Board
 	Hand    []HasManaCost
+	Fatigue int
 
 	Current bool
+
+	// maxMana caps MaxMana, zero for DefaultMaxMana
+	maxMana int
 }
 
 func (gp *GamePlayer) GetHealth() int {
@@ -77,13 +170,22 @@ func (gp *GamePlayer) ReduceHealth(amount int) {
 }
 
 func (gp *GamePlayer) Send(response Response) {
-	gp.player.Send(response)
+	gp.mutex.Lock()
+	player := gp.player
+	gp.mutex.Unlock()
+
+	player.Send(response)
 }
 
 func (gp *GamePlayer) IncreaseMana(amount int) {
+	limit := gp.maxMana
+	if limit == 0 {
+		limit = DefaultMaxMana
+	}
+
 	gp.MaxMana += amount
-	if gp.MaxMana > 10 {
-		gp.MaxMana = 10
+	if gp.MaxMana > limit {
+		gp.MaxMana = limit
 	}
 }
 
@@ -105,53 +207,135 @@ func (gp *GamePlayer) ConsumeMana(amount int) {
 	}
 }
 
+type GameResult struct {
+	GameId   uuid.UUID
+	Mode     GameMode
+	Winner   *Player
+	Loser    *Player
+	Started  time.Time
+	Duration time.Duration
+	Turns    int
+	// Aborted games ended without a winner, after something went wrong.
+	Aborted bool
+	Replay  *Replay
+}
+
 type Game struct {
 	Id      uuid.UUID
+	Mode    GameMode
 	Ready   []*Player
 	Players map[*Player]*GamePlayer
-
-	EndTurn   chan *Player
+	Turns   int
+	Created time.Time
+
+	clock Clock
+	// metrics is where turns are reported, if anywhere
+	metrics *Metrics
+	// replay logs the game as it goes, from its goroutine
+	replay *Replay
+
+	Over chan GameResult
+	done chan bool
+
+	EndTurn   chan Event
+	Concede   chan Event
+	Abort     chan bool
+	Mulligan  chan bool
 	Discard   chan Discarded
 	Started   chan time.Duration
 	StartTurn chan time.Duration
 	TurnOver  chan time.Duration
-	PlayCard  chan PlayCardPayload
-	Attack    chan AttackPayload
+	// events whose payloads have been decoded, into PlayCardPayload
+	// and AttackPayload
+	PlayCard chan Event
+	Attack   chan Event
+
+	// players who disconnect, and those who come back on a new
+	// connection
+	Disconnect chan *Player
+	Reconnect  chan *Player
+	// forfeit gets the away channel of players who didn't come back
+	forfeit chan chan bool
 }
 
-func NewGame(players []*Player) *Game {
+func NewGame(players []*Player, mode GameMode) *Game {
+	decks := make(map[*Player]*Deck)
+	for _, player := range players {
+		decks[player] = NewDeck(mode.Rules.DeckSize)
+	}
+	return NewGameWithDecks(players, mode, decks)
+}
+
+func NewGameWithDecks(players []*Player, mode GameMode, decks map[*Player]*Deck) *Game {
+	return NewGameWithClock(players, mode, decks, RealClock)
+}
+
+func NewGameWithClock(players []*Player, mode GameMode, decks map[*Player]*Deck, clock Clock) *Game {
 	gamePlayers := map[*Player]*GamePlayer{}
 
 	for idx, player := range players {
-		deck := NewDeck()
+		deck := decks[player]
 
 		gamePlayers[player] = &GamePlayer{
 			player: player,
 
 			Id:      uuid.New(),
-			Health:  30,
+			Health:  mode.Rules.StartingHealth,
 			Deck:    deck,
 			Current: idx == 0,
-			Board:   NewBoard(),
-			Hand:    deck.DrawMany(3),
+			Board:   NewBoardOfSize(mode.Rules.BoardSize),
+			Hand:    deck.DrawMany(mode.Rules.StartingHand),
+
+			maxMana: mode.Rules.MaxMana,
 		}
 	}
 
 	game := &Game{
 		Id:      uuid.New(),
+		Mode:    mode,
 		Ready:   make([]*Player, 0),
 		Players: gamePlayers,
+		Created: clock.Now(),
+
+		clock: clock,
+
+		Over: make(chan GameResult, 1),
+		done: make(chan bool),
 
-		EndTurn:   make(chan *Player),
+		EndTurn:   make(chan Event),
+		Concede:   make(chan Event),
+		Abort:     make(chan bool),
+		Mulligan:  make(chan bool),
 		Started:   make(chan time.Duration),
 		Discard:   make(chan Discarded),
 		StartTurn: make(chan time.Duration),
 		TurnOver:  make(chan time.Duration),
-		PlayCard:  make(chan PlayCardPayload),
-		Attack:    make(chan AttackPayload),
+		PlayCard:  make(chan Event),
+		Attack:    make(chan Event),
+
+		Disconnect: make(chan *Player),
+		Reconnect:  make(chan *Player),
+		forfeit:    make(chan chan bool),
 	}
+	game.replay = newReplay(game, players)
 
 	go func() {
+		// a bug in one game shouldn't take every other game down with it
+		defer func() {
+			if reason := recover(); reason != nil {
+				logPanic(reason, fmt.Sprintf("game %v", game.Id))
+				game.abort()
+			}
+		}()
+
+		// whether turns have begun, either because everyone chose
+		// their starting hand or because the mulligan timer ran out
+		begun := false
+		// ending passes EndTurn on to the turn that's running, nil
+		// between turns, so nobody holds the game up ending a turn
+		// that hasn't begun or is already over
+		var ending chan Event
+
 		for {
 			select {
 			case duration := <-game.Started:
@@ -159,21 +343,24 @@ func NewGame(players []*Player) *Game {
 					go player.Send(Response{
 						Type: StartingHand,
 						Payload: StartingHandPayload{
-							Id:       player.Id,
-							Cards:    player.Hand,
-							GameId:   game.Id,
-							Duration: duration,
+							Id:        player.Id,
+							Cards:     player.Hand,
+							GameId:    game.Id,
+							Health:    player.Health,
+							Duration:  duration,
+							BoardSize: player.Board.Size(),
 						},
 					})
 				}
-			case event := <-game.Discard:
-				player, ok := game.Players[event.Player]
+			case discarded := <-game.Discard:
+				player, ok := game.Players[discarded.Player]
 
 				if !ok {
-					return
+					discarded.Event.Fail("Not in this game")
+					continue
 				}
 
-				for _, cardId := range event.Cards {
+				for _, cardId := range discarded.Cards {
 					for idx, card := range player.Hand {
 						if card.GetId() == cardId {
 							player.Hand = append(
@@ -188,18 +375,25 @@ func NewGame(players []*Player) *Game {
 
 				player.Hand = append(
 					player.Hand,
-					player.Deck.DrawMany(len(event.Cards))...,
+					player.Deck.DrawMany(len(discarded.Cards))...,
 				)
 
-				game.Ready = append(game.Ready, event.Player)
+				game.record(player, ReplayAction{Type: CardsDiscarded, Cards: discarded.Cards})
+				game.Ready = append(game.Ready, discarded.Player)
 
-				player.Send(Response{
+				discarded.Event.Ack(Response{
 					Type:    WaitOtherPlayers,
 					Payload: player.Hand,
 				})
 
-				if len(game.Ready) == len(game.Players) {
-					go game.StartTurns(75 * time.Second)
+				if len(game.Ready) == len(game.Players) && !begun {
+					begun = true
+					go game.StartTurns(game.Mode.Rules.TurnDuration)
+				}
+			case <-game.Mulligan:
+				if !begun {
+					begun = true
+					go game.StartTurns(game.Mode.Rules.TurnDuration)
 				}
 			case duration := <-game.StartTurn:
 				var other *GamePlayer
@@ -213,11 +407,35 @@ func NewGame(players []*Player) *Game {
 					}
 				}
 
+				game.Turns++
+
 				current.IncreaseMana(1)
 				current.RefillMana()
 
 				card := current.Deck.Draw()
-				current.Hand = append(current.Hand, card)
+
+				if card != nil {
+					current.Hand = append(current.Hand, card)
+				} else {
+					// drawing from an empty deck hurts more every time
+					current.Fatigue++
+					current.ReduceHealth(current.Fatigue)
+
+					for _, player := range game.Players {
+						go player.Send(Response{
+							Type: DamageTaken,
+							Payload: DamageTakenPayload{
+								Health:   current.GetHealth(),
+								PlayerId: current.Id.String(),
+							},
+						})
+					}
+
+					if current.GetHealth() <= 0 {
+						game.finish(other, current)
+						return
+					}
+				}
 
 				for _, minion := range current.Board.Defenders {
 					minion.SetStatus(&Ready{})
@@ -246,58 +464,188 @@ func NewGame(players []*Player) *Game {
 					},
 				})
 
+				end := make(chan Event, 1)
+				ending = end
+
 				go func() {
+					began := game.clock.Now()
+
 					select {
-					case <-time.After(duration):
-						game.TurnOver <- duration
-					case <-game.EndTurn:
-						game.TurnOver <- duration
+					case <-game.clock.After(duration):
+						// the turn's over either way, so whoever ended
+						// it just as it ran out is told it went through
+						select {
+						case event := <-end:
+							event.Ack()
+						default:
+						}
+					case event := <-end:
+						event.Ack()
+					case <-game.done:
+						return
+					}
+
+					if game.metrics != nil {
+						game.metrics.observeTurn(game.Mode, game.clock.Now().Sub(began))
+					}
+
+					select {
+					case game.TurnOver <- duration:
+					case <-game.done:
 					}
 				}()
+			case event := <-game.EndTurn:
+				// only the player whose turn it is ends it, and only
+				// once
+				if game.turnOf(event.Player) == nil || ending == nil {
+					go event.Fail("Not your turn")
+					continue
+				}
+				ending <- event
+				ending = nil
+			case <-game.Abort:
+				game.abort()
+				return
+			case event := <-game.Concede:
+				loser, ok := game.Players[event.Player]
+				if !ok {
+					go event.Fail("Not in this game")
+					continue
+				}
+
+				game.record(loser, ReplayAction{Type: Concede})
+
+				for _, winner := range game.Players {
+					if winner != loser {
+						go event.Ack()
+						game.finish(winner, loser)
+						return
+					}
+				}
+			case player := <-game.Disconnect:
+				gone, ok := game.Players[player]
+				if !ok || gone.away != nil {
+					continue
+				}
+
+				gone.away = make(chan bool)
+				go game.awaitReconnect(gone.away)
+
+				for _, other := range game.Players {
+					if other != gone {
+						go other.Send(Response{
+							Type: OpponentLeft,
+							Payload: OpponentLeftPayload{
+								GameId: game.Id,
+								Grace:  game.Mode.Rules.ReconnectGrace,
+							},
+						})
+					}
+				}
+			case player := <-game.Reconnect:
+				var previous *Player
+				for other, gamePlayer := range game.Players {
+					if gamePlayer.away != nil && other.Id == player.Id {
+						previous = other
+					}
+				}
+				if previous == nil {
+					continue
+				}
+
+				// the player's new connection takes the place of the
+				// old one
+				back := game.Players[previous]
+				close(back.away)
+				back.away = nil
+
+				back.mutex.Lock()
+				back.player = player
+				back.mutex.Unlock()
+
+				delete(game.Players, previous)
+				game.Players[player] = back
+
+				for idx, ready := range game.Ready {
+					if ready == previous {
+						game.Ready[idx] = player
+					}
+				}
+
+				go back.Send(Response{
+					Type:    GameResumed,
+					Payload: game.resumed(back, begun),
+				})
+
+				for _, other := range game.Players {
+					if other != back {
+						go other.Send(Response{
+							Type:    OpponentReturned,
+							Payload: game.Id,
+						})
+					}
+				}
+			case away := <-game.forfeit:
+				for _, loser := range game.Players {
+					if loser.away != away {
+						continue
+					}
+
+					for _, winner := range game.Players {
+						if winner != loser {
+							game.finish(winner, loser)
+							return
+						}
+					}
+				}
 			case duration := <-game.TurnOver:
+				ending = nil
+				for _, player := range game.Players {
+					if player.Current {
+						game.record(player, ReplayAction{Type: EndTurn})
+					}
+				}
 				for _, player := range game.Players {
 					player.Current = !player.Current
 				}
 				go game.StartTurns(duration)
-			case data := <-game.PlayCard:
+			case event := <-game.PlayCard:
+				data := event.Payload.(PlayCardPayload)
+
+				current := game.turnOf(event.Player)
+				if current == nil {
+					go event.Fail("Not your turn")
+					continue
+				}
+
 				var index int
 				var card HasManaCost
-				var current *GamePlayer
-
-				for _, player := range game.Players {
-					for idx, c := range player.Hand {
-						if c.GetId() == data.Card {
-							card = c
-							index = idx
 
-						}
-					}
-					if player.Current {
-						current = player
+				for idx, c := range current.Hand {
+					if c.GetId() == data.Card {
+						card = c
+						index = idx
 					}
 				}
 
 				if card == nil {
-					current.Send(Response{
-						Type:    Error,
-						Payload: "Card not found",
-					})
+					event.Fail("Card not found")
 				} else if card.GetManaCost() > current.Mana {
-					current.Send(Response{
-						Type:    Error,
-						Payload: "Not enough mana",
-					})
+					event.Fail("Not enough mana")
+				} else if len(current.Board.Defenders) >= current.Board.Size() {
+					event.Fail("Board is full")
 				} else {
 					current.Hand = append(
 						current.Hand[:index],
 						current.Hand[index+1:]...,
 					)
 
+					game.record(current, ReplayAction{Type: PlayCard, Cards: []string{card.GetId()}})
 					current.ConsumeMana(card.GetManaCost())
-					played := current.Board.PlaceCard(card.(Defender))
+					played := snapshotDefender(current.Board.PlaceCard(card.(Defender)))
 
 					for _, player := range game.Players {
-						go player.Send(Response{
+						response := Response{
 							Type: CardPlayed,
 							Payload: CardPlayedPayload{
 								GameId: game.Id,
@@ -305,17 +653,27 @@ func NewGame(players []*Player) *Game {
 								Mana:   current.Mana,
 								Player: current.Id,
 							},
-						})
+						}
+
+						if player == current {
+							go event.Ack(response)
+						} else {
+							go player.Send(response)
+						}
 					}
 				}
-			case data := <-game.Attack:
-				var current *GamePlayer
-				var other *GamePlayer
+			case event := <-game.Attack:
+				data := event.Payload.(AttackPayload)
+
+				current := game.turnOf(event.Player)
+				if current == nil {
+					go event.Fail("Not your turn")
+					continue
+				}
 
+				var other *GamePlayer
 				for _, player := range game.Players {
-					if player.Current {
-						current = player
-					} else {
+					if player != current {
 						other = player
 					}
 				}
@@ -330,6 +688,11 @@ func NewGame(players []*Player) *Game {
 					}
 				}
 
+				if attacker == nil {
+					go event.Fail("Attacker not found")
+					continue
+				}
+
 				if data.Target != "" {
 					for _, card := range other.Board.Defenders {
 						if card.GetId() == data.Target {
@@ -338,7 +701,10 @@ func NewGame(players []*Player) *Game {
 						}
 					}
 
-					if attacker.CanAttack() {
+					if defender == nil {
+						go event.Fail("Target not found")
+					} else if attacker.CanAttack() {
+						game.record(current, ReplayAction{Type: Attack, Attacker: data.Attacker, Target: data.Target})
 						attacker.Attack(defender)
 
 						if attacker.GetHealth() == 0 {
@@ -348,55 +714,54 @@ func NewGame(players []*Player) *Game {
 							other.Board.Remove(defender)
 						}
 
-						current.Send(Response{
+						event.Ack(Response{
 							Type: AttackResult,
 							Payload: []*Board{
-								current.Board,
-								other.Board,
+								current.Board.Snapshot(),
+								other.Board.Snapshot(),
 							},
 						})
 
 						other.Send(Response{
 							Type: AttackResult,
 							Payload: []*Board{
-								other.Board,
-								current.Board,
+								other.Board.Snapshot(),
+								current.Board.Snapshot(),
 							},
 						})
 					} else {
-						current.Send(Response{
-							Type:    Error,
-							Payload: "Cannot attack with this card",
-						})
+						event.Fail("Cannot attack with this card")
 					}
+				} else if !attacker.CanAttack() {
+					event.Fail("Cannot attack with this card")
 				} else if len(other.Board.Defenders) == 0 {
+					game.record(current, ReplayAction{Type: AttackPlayer, Attacker: data.Attacker})
 					other.ReduceHealth(attacker.GetDamage())
+					attacker.SetStatus(&Exhausted{})
 
 					if other.GetHealth() <= 0 {
-						for _, player := range game.Players {
-							player.Send(Response{
-								Type: GameOver,
-								Payload: GameOverPayload{
-									Winner: current,
-									Loser:  other,
-								},
-							})
+						go event.Ack()
+						game.finish(current, other)
+						return
+					}
+
+					for _, player := range game.Players {
+						response := Response{
+							Type: DamageTaken,
+							Payload: DamageTakenPayload{
+								Health:   other.GetHealth(),
+								PlayerId: other.Id.String(),
+							},
 						}
-					} else {
-						for _, player := range game.Players {
-							player.Send(Response{
-								Type: DamageTaken,
-								Payload: DamageTakenPayload{
-									Health: other.GetHealth(),
-								},
-							})
+
+						if player == current {
+							go event.Ack(response)
+						} else {
+							go player.Send(response)
 						}
 					}
 				} else {
-					current.Send(Response{
-						Type:    Error,
-						Payload: "Cannot attack player with minions on board",
-					})
+					event.Fail("Cannot attack player with minions on board")
 				}
 			}
 		}
@@ -405,19 +770,168 @@ func NewGame(players []*Player) *Game {
 	return game
 }
 
+// turnOf is the player if it's their turn, or nil if it isn't or they
+// aren't in the game. It's only called from the game's goroutine.
+func (g *Game) turnOf(player *Player) *GamePlayer {
+	gamePlayer, ok := g.Players[player]
+	if !ok || g.Turns == 0 || !gamePlayer.Current {
+		return nil
+	}
+	return gamePlayer
+}
+
+func (g *Game) finish(winner, loser *GamePlayer) {
+	g.Over <- GameResult{
+		GameId:   g.Id,
+		Mode:     g.Mode,
+		Winner:   winner.player,
+		Loser:    loser.player,
+		Started:  g.Created,
+		Duration: g.clock.Now().Sub(g.Created),
+		Turns:    g.Turns,
+		Replay:   g.replay,
+	}
+
+	for _, player := range g.Players {
+		go player.Send(Response{
+			Type: GameOver,
+			Payload: GameOverPayload{
+				Winner: winner,
+				Loser:  loser,
+			},
+		})
+	}
+
+	close(g.done)
+}
+
+// abort ends the game without a winner, unless it's already over.
+func (g *Game) abort() {
+	select {
+	case <-g.done:
+		return
+	default:
+	}
+
+	select {
+	case g.Over <- GameResult{
+		GameId:   g.Id,
+		Mode:     g.Mode,
+		Started:  g.Created,
+		Duration: g.clock.Now().Sub(g.Created),
+		Turns:    g.Turns,
+		Aborted:  true,
+	}:
+	default:
+	}
+
+	for _, player := range g.Players {
+		go player.Send(Response{
+			Type:    GameAborted,
+			Payload: g.Id,
+		})
+	}
+
+	close(g.done)
+}
+
+// awaitReconnect has the player whose away channel it is forfeit, unless
+// they're back before the grace period is over.
+func (g *Game) awaitReconnect(away chan bool) {
+	select {
+	case <-g.clock.After(g.Mode.Rules.ReconnectGrace):
+	case <-away:
+		return
+	case <-g.done:
+		return
+	}
+
+	select {
+	case g.forfeit <- away:
+	case <-away:
+	case <-g.done:
+	}
+}
+
+// resumed is the state of the game as player sees it.
+func (g *Game) resumed(player *GamePlayer, begun bool) GameResumedPayload {
+	chosen := begun
+	for _, ready := range g.Ready {
+		if ready == player.player {
+			chosen = true
+		}
+	}
+
+	payload := GameResumedPayload{
+		GameId:   g.Id,
+		Id:       player.Id,
+		Hand:     append([]HasManaCost(nil), player.Hand...),
+		Health:   player.Health,
+		Mana:     player.Mana,
+		Board:    player.Board.Snapshot(),
+		Current:  begun && player.Current,
+		Choosing: !chosen,
+	}
+
+	for _, other := range g.Players {
+		if other != player {
+			payload.EnemyHealth = other.Health
+			payload.EnemyBoard = other.Board.Snapshot()
+		}
+	}
+
+	return payload
+}
+
 func (g *Game) StartTurns(duration time.Duration) {
-	g.StartTurn <- duration
+	select {
+	case g.StartTurn <- duration:
+	case <-g.done:
+	}
 }
 
 func (g *Game) Start(duration time.Duration) {
 	go func() {
 		select {
-		case <-time.After(duration):
-			g.StartTurns(75 * time.Second)
+		case <-g.clock.After(duration):
+		case <-g.done:
+			return
+		}
+
+		select {
+		case g.Mulligan <- true:
+		case <-g.done:
 		}
 	}()
 
-	g.Started <- duration
+	select {
+	case g.Started <- duration:
+	case <-g.done:
+	}
+}
+
+// Mailbox rejects events once a game falls behind, rather than let one
+// player flooding it hold up everyone else.
+func (g *Game) Mailbox() Mailbox {
+	return Mailbox{Size: 32, Overflow: Reject}
+}
+
+// Crashed aborts the game, since whatever the event was meant to do
+// might have been left half done.
+func (g *Game) Crashed(event Event, reason interface{}) {
+	g.interrupt()
+}
+
+// interrupt has the game aborted, unless it's already over.
+func (g *Game) interrupt() {
+	select {
+	case g.Abort <- true:
+	case <-g.done:
+	}
+}
+
+func (g *Game) Subscriptions() []Subscription {
+	return subscribe(g.Id.String(), CardsDiscarded, EndTurn, PlayCard, Attack, AttackPlayer, Concede)
 }
 
 func (g *Game) Process(event Event, dispatcher *Dispatcher) {
@@ -425,8 +939,8 @@ func (g *Game) Process(event Event, dispatcher *Dispatcher) {
 	case CardsDiscarded:
 		var data CardsDiscardedPayload
 
-		err := mapstructure.Decode(event.Payload, &data)
-		if err != nil {
+		if err := event.Decode(&data); err != nil {
+			go event.Fail(err.Error())
 			return
 		}
 
@@ -435,23 +949,48 @@ func (g *Game) Process(event Event, dispatcher *Dispatcher) {
 			return
 		}
 
-		g.Discard <- Discarded{
-			Cards:  data.Cards,
-			Player: event.Player,
+		select {
+		case g.Discard <- Discarded{Cards: data.Cards, Player: event.Player, Event: event}:
+		case <-g.done:
+			go event.Fail("Game is over")
 		}
 	case EndTurn:
-		uuid, err := uuid.Parse(event.Payload.(string))
+		var id uuid.UUID
+		if err := event.Decode(&id); err != nil {
+			go event.Fail(err.Error())
+			return
+		}
 
-		if err != nil || uuid != g.Id {
+		if id != g.Id {
+			return
+		}
+
+		select {
+		case g.EndTurn <- event:
+		case <-g.done:
+			go event.Fail("Game is over")
+		}
+	case Concede:
+		var id uuid.UUID
+		if err := event.Decode(&id); err != nil {
+			go event.Fail(err.Error())
 			return
 		}
 
-		g.EndTurn <- event.Player
+		if id != g.Id {
+			return
+		}
+
+		select {
+		case g.Concede <- event:
+		case <-g.done:
+			go event.Fail("Game is over")
+		}
 	case PlayCard:
 		var data PlayCardPayload
 
-		err := mapstructure.Decode(event.Payload, &data)
-		if err != nil {
+		if err := event.Decode(&data); err != nil {
+			go event.Fail(err.Error())
 			return
 		}
 
@@ -460,12 +999,18 @@ func (g *Game) Process(event Event, dispatcher *Dispatcher) {
 			return
 		}
 
-		g.PlayCard <- data
+		event.Payload = data
+
+		select {
+		case g.PlayCard <- event:
+		case <-g.done:
+			go event.Fail("Game is over")
+		}
 	case Attack, AttackPlayer:
 		var data AttackPayload
 
-		err := mapstructure.Decode(event.Payload, &data)
-		if err != nil {
+		if err := event.Decode(&data); err != nil {
+			go event.Fail(err.Error())
 			return
 		}
 
@@ -474,26 +1019,191 @@ func (g *Game) Process(event Event, dispatcher *Dispatcher) {
 			return
 		}
 
-		g.Attack <- data
+		event.Payload = data
+
+		select {
+		case g.Attack <- event:
+		case <-g.done:
+			go event.Fail("Game is over")
+		}
 	}
 }
 
-type GameManager struct{}
+type GameManager struct {
+	mutex sync.Mutex
+	// the players each game started with, and the game each account
+	// is playing, for when they disconnect or come back
+	games   map[*Game][]*Player
+	playing map[string]*Game
+	// once closing no new games start, and once aborting the ones
+	// still going are aborted
+	closing  bool
+	aborting bool
+	running  sync.WaitGroup
+
+	config GameConfig
+	clock  Clock
+}
 
 func NewGameManager() *GameManager {
-	return &GameManager{}
+	return NewGameManagerWithClock(RealClock)
+}
+
+func NewGameManagerWithClock(clock Clock) *GameManager {
+	return NewGameManagerWithConfig(DefaultGameConfig, clock)
+}
+
+func NewGameManagerWithConfig(config GameConfig, clock Clock) *GameManager {
+	return &GameManager{
+		games:   make(map[*Game][]*Player),
+		playing: make(map[string]*Game),
+		config:  config,
+		clock:   clock,
+	}
+}
+
+func (gm *GameManager) Subscriptions() []Subscription {
+	return subscribe("", StartGame, PlayerDisconnected, PlayerLoggedIn)
 }
 
 func (gm *GameManager) Process(event Event, dispatcher *Dispatcher) {
 	switch event.Type {
 	case StartGame:
+		data := event.Payload.(MatchPayload)
+
+		gm.mutex.Lock()
+		if gm.closing {
+			gm.mutex.Unlock()
+
+			for _, player := range data.Players {
+				go sendError(player, ErrShuttingDown.Error())
+			}
+			return
+		}
+		gm.running.Add(1)
+		gm.mutex.Unlock()
+
 		go func() {
-			players := event.Payload.([]*Player)
-			game := NewGame(players)
+			defer gm.running.Done()
+
+			decks := make(map[*Player]*Deck)
+			for _, player := range data.Players {
+				if list, ok := data.Decks[player]; ok {
+					decks[player] = NewDeckFromList(list)
+				} else {
+					decks[player] = NewDeck(data.Mode.Rules.DeckSize)
+				}
+			}
+
+			game := NewGameWithClock(data.Players, data.Mode, decks, gm.clock)
+			game.metrics = dispatcher.Metrics()
+			gm.track(game, data.Players, dispatcher.Metrics())
+
+			dispatcher.Add(game)
+
+			game.Start(gm.config.Mulligan)
+
+			result := <-game.Over
+
+			gm.untrack(game, dispatcher.Metrics())
+			dispatcher.Remove(game)
 
-			dispatcher.Register <- game
+			// nobody won an aborted game, so there's nothing to record
+			if result.Aborted {
+				return
+			}
+
+			dispatcher.Metrics().observeGame(result)
 
-			game.Start(30 * time.Second)
+			dispatcher.Emit(Event{
+				Type:    GameFinished,
+				Payload: result,
+			})
 		}()
+	case PlayerDisconnected:
+		if game := gm.game(event.Player); game != nil {
+			select {
+			case game.Disconnect <- event.Player:
+			case <-game.done:
+			}
+		}
+	case PlayerLoggedIn:
+		// players whose client can't pick a game back up are left to
+		// forfeit it
+		if game := gm.game(event.Player); game != nil && event.Player.Supports(ReconnectCapability) {
+			select {
+			case game.Reconnect <- event.Player:
+			case <-game.done:
+			}
+		}
+	}
+}
+
+// game is the game the player's account is playing, if any.
+func (gm *GameManager) game(player *Player) *Game {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	if player == nil || player.Id == "" {
+		return nil
+	}
+	return gm.playing[player.Id]
+}
+
+func (gm *GameManager) track(game *Game, players []*Player, metrics *Metrics) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	gm.games[game] = players
+	metrics.activeGames.Set("", float64(len(gm.games)))
+	for _, player := range players {
+		if player.Id != "" {
+			gm.playing[player.Id] = game
+		}
 	}
+
+	if gm.aborting {
+		go game.interrupt()
+	}
+}
+
+func (gm *GameManager) untrack(game *Game, metrics *Metrics) {
+	gm.mutex.Lock()
+	defer gm.mutex.Unlock()
+
+	for _, player := range gm.games[game] {
+		if gm.playing[player.Id] == game {
+			delete(gm.playing, player.Id)
+		}
+	}
+	delete(gm.games, game)
+	metrics.activeGames.Set("", float64(len(gm.games)))
+}
+
+// Stop starts no more games and waits for those in progress to finish,
+// aborting whichever are still going once ctx is done.
+func (gm *GameManager) Stop(ctx context.Context) {
+	gm.mutex.Lock()
+	gm.closing = true
+	gm.mutex.Unlock()
+
+	if wait(ctx, &gm.running) == nil {
+		return
+	}
+
+	gm.mutex.Lock()
+	gm.aborting = true
+	for game := range gm.games {
+		go game.interrupt()
+	}
+	gm.mutex.Unlock()
+
+	gm.running.Wait()
+}
+
+func sendError(player *Player, message string) {
+	player.Send(Response{
+		Type:    Error,
+		Payload: message,
+	})
 }
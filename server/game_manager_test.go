@@ -1,8 +1,11 @@
 package server
 
 import (
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 func TestRegistersGameAsHandler(t *testing.T) {
@@ -14,7 +17,7 @@ func TestRegistersGameAsHandler(t *testing.T) {
 
 	go manager.Process(Event{
 		Type:    StartGame,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	select {
@@ -28,7 +31,7 @@ func TestSendsThreeCardsAsStartingHand(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.Start(time.Minute)
 
 	select {
@@ -70,7 +73,7 @@ func TestReplacesDiscardedCards(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher := NewDispatcher()
 	dispatcher.Register <- game
@@ -109,21 +112,39 @@ func TestReplacesDiscardedCards(t *testing.T) {
 	}
 }
 
+// newClockedGame is a casual game whose timers go by clock.
+func newClockedGame(clock Clock, players ...*Player) *Game {
+	decks := make(map[*Player]*Deck)
+	for _, player := range players {
+		decks[player] = NewDeck(CasualMode.Rules.DeckSize)
+	}
+	return NewGameWithClock(players, CasualMode, decks, clock)
+}
+
+// endTurnInTime runs out the clock on the current turn, once it's
+// started.
+func endTurnInTime(clock *FakeClock, duration time.Duration) {
+	clock.BlockUntil(1)
+	clock.Advance(duration)
+}
+
 func TestTimerToChooseStartingHand(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
 
 	dispatcher := NewDispatcher()
 	dispatcher.Register <- game
 
-	go game.Start(100 * time.Millisecond)
+	go game.Start(30 * time.Second)
 
 	<-p1.Outgoing // starting hand
 	<-p2.Outgoing // starting hand
 
-	time.Sleep(200 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
 
 	select {
 	case response := <-p1.Outgoing:
@@ -148,7 +169,7 @@ func TestConfirmWithoutDiscarding(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher := NewDispatcher()
 	dispatcher.Register <- game
@@ -200,7 +221,7 @@ func TestStartsTurnsWhenBothPlayersChoseHand(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher := NewDispatcher()
 	dispatcher.Register <- game
@@ -284,13 +305,15 @@ func TestTurnTimer(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
-	go game.StartTurns(100 * time.Millisecond)
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
+	go game.StartTurns(time.Minute)
 
 	<-p1.Outgoing // start turn
 	<-p2.Outgoing // wait turn
 
-	time.Sleep(150 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
 
 	select {
 	case response := <-p1.Outgoing:
@@ -316,7 +339,7 @@ func TestEndTurn(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewDispatcher()
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher.Register <- game
 	go game.StartTurns(time.Minute)
@@ -377,13 +400,15 @@ func TestDrawsCardOnTurnStart(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
-	go game.Start(100 * time.Millisecond)
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
+	go game.Start(30 * time.Second)
 
 	<-p1.Outgoing // starting hand
 	<-p2.Outgoing // starting hand
 
-	time.Sleep(200 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
 
 	select {
 	case response := <-p1.Outgoing:
@@ -405,7 +430,7 @@ func TestPlayCard(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewDispatcher()
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher.Register <- game
 	go game.StartTurns(time.Minute)
@@ -470,8 +495,9 @@ func TestGainsManaOnTurnStart(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
-	go game.StartTurns(100 * time.Millisecond)
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
+	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing
 	<-p2.Outgoing // wait turn
@@ -482,8 +508,7 @@ func TestGainsManaOnTurnStart(t *testing.T) {
 		t.Errorf("Expected %v, got %v", 1, payload.Mana)
 	}
 
-	// wait turn end
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	<-p1.Outgoing // wait turn
 	res2 := <-p2.Outgoing
@@ -493,8 +518,7 @@ func TestGainsManaOnTurnStart(t *testing.T) {
 		t.Errorf("Expected %v, got %v", 1, payload2.Mana)
 	}
 
-	// wait turn end
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	res3 := <-p1.Outgoing
 	<-p2.Outgoing // wait turn
@@ -511,7 +535,7 @@ func TestCannotPlayCardWithManaCostHigherThanCurrentMana(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewDispatcher()
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 
 	dispatcher.Register <- game
 	go game.StartTurns(time.Minute)
@@ -553,7 +577,7 @@ func TestPlayedCardIsRemovedFromHand(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -603,8 +627,9 @@ func TestPlayingCardsUsesMana(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
-	go game.Start(100 * time.Millisecond)
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
+	go game.Start(30 * time.Second)
 
 	res := <-p1.Outgoing // starting hand
 	<-p2.Outgoing        // starting hand
@@ -612,7 +637,8 @@ func TestPlayingCardsUsesMana(t *testing.T) {
 	hand := res.Payload.(StartingHandPayload)
 
 	// start turns
-	time.Sleep(110 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
 
 	turn := <-p1.Outgoing // start turn
 	<-p2.Outgoing         // wait turn
@@ -669,18 +695,19 @@ func TestRefillsManaOnTurnStart(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
-	go game.StartTurns(100 * time.Millisecond)
+	clock := newTestClock()
+	game := newClockedGame(clock, p1, p2)
+	go game.StartTurns(time.Minute)
 
 	<-p1.Outgoing // start turn
 	<-p2.Outgoing // wait turn
 
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	<-p1.Outgoing // wait turn
 	<-p2.Outgoing // start turn
 
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	res := <-p1.Outgoing // start turn
 	<-p2.Outgoing        // wait turn
@@ -700,12 +727,12 @@ func TestRefillsManaOnTurnStart(t *testing.T) {
 	<-p1.Outgoing // card played
 	<-p2.Outgoing // card played
 
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	<-p1.Outgoing // wait turn
 	<-p2.Outgoing // start turn
 
-	time.Sleep(100 * time.Millisecond)
+	endTurnInTime(clock, time.Minute)
 
 	res2 := <-p1.Outgoing // start turn
 	<-p2.Outgoing         // wait turn
@@ -750,7 +777,7 @@ func TestAttackCards(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -872,7 +899,7 @@ func TestDestroysDefender(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -993,7 +1020,7 @@ func TestDestroysAttacker(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -1113,7 +1140,7 @@ func TestAttackHero(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -1198,7 +1225,7 @@ func TestCannotAttackPlayerIfThereAreMinionsOnBoard(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -1282,7 +1309,7 @@ func TestGameOver(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
-	game := NewGame([]*Player{p1, p2})
+	game := NewGame([]*Player{p1, p2}, CasualMode)
 	go game.StartTurns(time.Minute)
 
 	res := <-p1.Outgoing // start turn
@@ -1363,4 +1390,428 @@ func TestGameOver(t *testing.T) {
 			t.Error("Wrong loser")
 		}
 	}
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Error("expected game result")
+	case result := <-game.Over:
+		if result.Winner != p1 || result.Loser != p2 {
+			t.Error("Wrong result")
+		}
+		if result.Turns != 3 {
+			t.Errorf("Expected %v turns, got %v", 3, result.Turns)
+		}
+	}
+}
+
+func TestConcede(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, CasualMode)
+	go game.StartTurns(time.Minute)
+
+	<-p1.Outgoing // start turn
+	<-p2.Outgoing // wait turn
+
+	go game.Process(Event{
+		Type:    Concede,
+		Player:  p2,
+		Payload: game.Id.String(),
+	}, nil)
+
+	for _, player := range []*Player{p1, p2} {
+		select {
+		case res := <-player.Outgoing:
+			if res.Type != GameOver {
+				t.Errorf("Expected %v, got %v", GameOver, res.Type)
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected game over response")
+		}
+	}
+
+	select {
+	case result := <-game.Over:
+		if result.Winner != p1 || result.Loser != p2 {
+			t.Error("Expected conceding player to lose")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected game result")
+	}
+}
+
+func TestDeckFromList(t *testing.T) {
+	deck := NewDeckFromList(DeckList{
+		Cards: []CardSpec{
+			{ManaCost: 1, Damage: 2, Health: 3},
+			{ManaCost: 1, Damage: 2, Health: 3},
+		},
+	})
+
+	if deck.Count() != 2 {
+		t.Errorf("Expected %v cards, got %v", 2, deck.Count())
+	}
+
+	card := deck.Draw().(Minion)
+	if card.GetManaCost() != 1 || card.GetDamage() != 2 || card.GetHealth() != 3 {
+		t.Errorf("Expected card to match spec, got %+v", card)
+	}
+}
+
+func TestGameUsesModeRules(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, GameMode{
+		Name: "arena",
+		Rules: Rules{
+			DeckSize:       20,
+			StartingHealth: 15,
+			StartingHand:   4,
+			TurnDuration:   time.Minute,
+		},
+	})
+
+	for _, player := range game.Players {
+		if player.Health != 15 {
+			t.Errorf("Expected %v, got %v", 15, player.Health)
+		}
+		if len(player.Hand) != 4 {
+			t.Errorf("Expected %v cards, got %v", 4, len(player.Hand))
+		}
+		if player.Deck.Count() != 16 {
+			t.Errorf("Expected %v cards, got %v", 16, player.Deck.Count())
+		}
+	}
+}
+
+func TestStartGameUsesSelectedDecks(t *testing.T) {
+	manager := NewGameManager()
+	dispatcher := NewTestDispatcher()
+
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	list := DeckList{Cards: make([]CardSpec, 10)}
+	for i := range list.Cards {
+		list.Cards[i] = CardSpec{ManaCost: 7, Damage: 7, Health: 7}
+	}
+
+	go manager.Process(Event{
+		Type: StartGame,
+		Payload: MatchPayload{
+			Players: []*Player{p1, p2},
+			Mode:    CasualMode,
+			Decks:   map[*Player]DeckList{p1: list},
+		},
+	}, dispatcher)
+
+	select {
+	case handler := <-dispatcher.Register:
+		game := handler.(*Game)
+
+		if game.Players[p1].Deck.Count() != 7 {
+			t.Errorf("Expected %v cards, got %v", 7, game.Players[p1].Deck.Count())
+		}
+		for _, card := range game.Players[p1].Hand {
+			if card.GetManaCost() != 7 {
+				t.Errorf("Expected card from selected deck, got %+v", card)
+			}
+		}
+		if game.Players[p2].Deck.Count() != 57 {
+			t.Errorf("Expected %v cards, got %v", 57, game.Players[p2].Deck.Count())
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected game to be registered")
+	}
+}
+
+func TestFatigueWhenDeckIsEmpty(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, GameMode{
+		Name: "fatigue",
+		Rules: Rules{
+			DeckSize:       3,
+			StartingHealth: 1,
+			StartingHand:   3,
+			TurnDuration:   time.Minute,
+		},
+	})
+
+	go game.StartTurns(time.Minute)
+
+	go func() {
+		for {
+			select {
+			case <-p1.Outgoing:
+			case <-p2.Outgoing:
+			}
+		}
+	}()
+
+	select {
+	case result := <-game.Over:
+		if result.Winner != p2 {
+			t.Error("Expected player without cards to lose")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected game to be over")
+	}
+}
+
+func expectFailure(t *testing.T, player *Player, reason string) {
+	t.Helper()
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error || response.Payload != reason {
+			t.Errorf("Expected %v %q, got %v %v", Error, reason, response.Type, response.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %q", reason)
+	}
+}
+
+func TestAttacksWithCardsNotOnBoardFail(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, RankedMode)
+	go game.StartTurns(time.Minute)
+
+	<-p1.Outgoing // start turn
+	<-p2.Outgoing // wait turn
+
+	attacks := []struct {
+		kind    EventType
+		payload AttackPayload
+		reason  string
+	}{
+		{Attack, AttackPayload{GameId: game.Id.String(), Attacker: uuid.NewString(), Target: uuid.NewString()}, "Attacker not found"},
+		{AttackPlayer, AttackPayload{GameId: game.Id.String(), Attacker: uuid.NewString()}, "Attacker not found"},
+	}
+
+	for _, attack := range attacks {
+		go game.Process(Event{Type: attack.kind, Player: p1, Payload: attack.payload}, nil)
+		expectFailure(t, p1, attack.reason)
+	}
+
+	// the attacker is on the board, but what it attacks isn't
+	attacker := game.Players[p1].Board.PlaceCard(NewMinion(0, 1, 1))
+	attacker.SetStatus(&Ready{})
+
+	go game.Process(Event{
+		Type:    Attack,
+		Player:  p1,
+		Payload: AttackPayload{GameId: game.Id.String(), Attacker: attacker.GetId(), Target: uuid.NewString()},
+	}, nil)
+	expectFailure(t, p1, "Target not found")
+
+	select {
+	case result := <-game.Over:
+		t.Errorf("Expected the game to go on, got %+v", result)
+	default:
+	}
+}
+
+func TestOnlyCurrentPlayerActs(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+	outsider := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, CasualMode)
+
+	// nobody's turn has begun yet
+	go game.Process(Event{
+		Type:    PlayCard,
+		Player:  p1,
+		Payload: PlayCardPayload{GameId: game.Id.String(), Card: uuid.NewString()},
+	}, nil)
+	expectFailure(t, p1, "Not your turn")
+
+	go game.StartTurns(time.Minute)
+
+	res := <-p1.Outgoing // start turn
+	<-p2.Outgoing        // wait turn
+
+	card := res.Payload.(TurnPayload).Card
+
+	for _, player := range []*Player{p2, outsider} {
+		go game.Process(Event{
+			Type:    PlayCard,
+			Player:  player,
+			Payload: PlayCardPayload{GameId: game.Id.String(), Card: card.GetId()},
+		}, nil)
+		expectFailure(t, player, "Not your turn")
+
+		go game.Process(Event{
+			Type:    AttackPlayer,
+			Player:  player,
+			Payload: AttackPayload{GameId: game.Id.String(), Attacker: uuid.NewString()},
+		}, nil)
+		expectFailure(t, player, "Not your turn")
+
+		go game.Process(Event{Type: EndTurn, Player: player, Payload: game.Id.String()}, nil)
+		expectFailure(t, player, "Not your turn")
+	}
+
+	// it's still the first player's turn, and they can end it
+	go game.Process(Event{Type: EndTurn, Player: p1, Payload: game.Id.String()}, nil)
+
+	select {
+	case response := <-p2.Outgoing:
+		if response.Type != StartTurn {
+			t.Errorf("Expected %v, got %v", StartTurn, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the second player's turn to start")
+	}
+}
+
+func TestRecordsReplay(t *testing.T) {
+	p1 := NewTestPlayer()
+	p1.Identify(Identity{Id: "first"})
+	p2 := NewTestPlayer()
+	p2.Identify(Identity{Id: "second"})
+
+	game := NewGame([]*Player{p1, p2}, CasualMode)
+
+	replay := game.replay
+	if replay.Players[0].Id != "first" || replay.Players[1].Id != "second" {
+		t.Fatalf("Expected the players in turn order, got %+v", replay.Players)
+	}
+	for idx, player := range []*Player{p1, p2} {
+		started := replay.Players[idx]
+		if len(started.Hand) != len(game.Players[player].Hand) || len(started.Deck) != game.Players[player].Deck.Count() {
+			t.Errorf("Expected %v's starting cards, got %+v", started.Id, started)
+		}
+	}
+
+	go game.StartTurns(time.Minute)
+
+	card := (<-p1.Outgoing).Payload.(TurnPayload).Card
+	<-p2.Outgoing
+	card.ReduceManaCost(100)
+
+	go game.Process(Event{
+		Type:    PlayCard,
+		Player:  p1,
+		Payload: PlayCardPayload{GameId: game.Id.String(), Card: card.GetId()},
+	}, nil)
+	expectResponse(t, p1, CardPlayed)
+	expectResponse(t, p2, CardPlayed)
+
+	go game.Process(Event{Type: EndTurn, Player: p1, Payload: game.Id.String()}, nil)
+	expectResponse(t, p2, StartTurn)
+	expectResponse(t, p1, WaitTurn)
+
+	go game.Process(Event{Type: Concede, Player: p2, Payload: game.Id.String()}, nil)
+	expectResponse(t, p1, GameOver)
+	expectResponse(t, p2, GameOver)
+
+	result := <-game.Over
+	if result.Replay != replay {
+		t.Fatal("Expected the result to carry the replay")
+	}
+
+	expected := []ReplayAction{
+		{Player: "first", Type: PlayCard, Cards: []string{card.GetId()}},
+		{Player: "first", Type: EndTurn},
+		{Player: "second", Type: Concede},
+	}
+	if len(replay.Actions) != len(expected) {
+		t.Fatalf("Expected %v actions, got %+v", len(expected), replay.Actions)
+	}
+	for idx, action := range replay.Actions {
+		action.At = 0
+		if !reflect.DeepEqual(action, expected[idx]) {
+			t.Errorf("Expected %+v, got %+v", expected[idx], action)
+		}
+	}
+}
+
+func TestCannotEndTurnBetweenTurns(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	game := NewGame([]*Player{p1, p2}, CasualMode)
+
+	// nobody's turn has begun while they choose their starting hands
+	go game.Process(Event{Type: EndTurn, Player: p1, Payload: game.Id.String()}, nil)
+	expectFailure(t, p1, "Not your turn")
+
+	go game.StartTurns(time.Minute)
+	<-p1.Outgoing // start turn
+	<-p2.Outgoing // wait turn
+
+	// a turn is only ended once, however many times it's asked to be
+	for i := 0; i < 2; i++ {
+		go game.Process(Event{Type: EndTurn, Player: p1, Payload: game.Id.String()}, nil)
+	}
+
+	responses := map[ResponseType]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case response := <-p1.Outgoing:
+			responses[response.Type]++
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the turn to end, got %v", responses)
+		}
+	}
+	if responses[WaitTurn] != 1 || responses[Error] != 1 {
+		t.Errorf("Expected the turn to end once, got %v", responses)
+	}
+}
+
+func TestCannotPlayCardOntoFullBoard(t *testing.T) {
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	mode := CasualMode
+	mode.Rules.BoardSize = 1
+
+	cheap := DeckList{Cards: []CardSpec{{ManaCost: 0, Damage: 1, Health: 1}}}
+	for len(cheap.Cards) < 10 {
+		cheap.Cards = append(cheap.Cards, cheap.Cards[0])
+	}
+
+	game := NewGameWithDecks([]*Player{p1, p2}, mode, map[*Player]*Deck{
+		p1: NewDeckFromList(cheap),
+		p2: NewDeckFromList(cheap),
+	})
+
+	go game.Start(time.Minute)
+	hand := (<-p1.Outgoing).Payload.(StartingHandPayload)
+	<-p2.Outgoing
+
+	if hand.BoardSize != 1 {
+		t.Errorf("Expected players to be told the board holds %v, got %v", 1, hand.BoardSize)
+	}
+
+	go game.StartTurns(time.Minute)
+	<-p1.Outgoing // start turn
+	<-p2.Outgoing // wait turn
+
+	play := func(card HasManaCost) {
+		go game.Process(Event{
+			Type:    PlayCard,
+			Player:  p1,
+			Payload: PlayCardPayload{GameId: game.Id.String(), Card: card.GetId()},
+		}, nil)
+	}
+
+	play(hand.Cards[0])
+	<-p1.Outgoing // card played
+	<-p2.Outgoing // card played
+
+	play(hand.Cards[1])
+	expectFailure(t, p1, "Board is full")
+
+	// the card that didn't fit is kept, to be played later
+	if player := game.Players[p1]; cardById(player.Hand, hand.Cards[1].GetId()) == nil {
+		t.Errorf("Expected the card to stay in hand, got %v", player.Hand)
+	}
 }
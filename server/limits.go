@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrMuted       = errors.New("too many events, muted for a while")
+	ErrAbusiveRate = errors.New("too many events, disconnected")
+)
+
+// Rate is a token bucket: up to Burst events at once, and one more for
+// every Interval that goes by. A zero Rate doesn't limit anything.
+type Rate struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// Limits is how much a connection can send before the server stops
+// listening to it. Events over a limit are rejected, and players who
+// keep at it are muted and then disconnected.
+type Limits struct {
+	// MaxMessageSize is the largest message players can send, in bytes.
+	// Connections sending more are closed.
+	MaxMessageSize int64
+	// Connection limits every event a connection sends
+	Connection Rate
+	// Events limits events of a type, on top of Connection
+	Events map[EventType]Rate
+
+	// Warnings is how many events over a limit are rejected before the
+	// player is muted
+	Warnings int
+	// Mute is how long muted players have everything they send dropped
+	Mute time.Duration
+	// Mutes is how many times players can be muted before going over
+	// a limit again disconnects them
+	Mutes int
+	// Forgive is how long players have to stay under the limits for
+	// their warnings and mutes to be forgotten
+	Forgive time.Duration
+}
+
+var DefaultLimits = Limits{
+	MaxMessageSize: 32 << 10,
+	Connection:     Rate{Burst: 30, Interval: 50 * time.Millisecond},
+	Events: map[EventType]Rate{
+		Login:           {Burst: 3, Interval: time.Second},
+		QueueUp:         {Burst: 3, Interval: time.Second},
+		Dequeue:         {Burst: 3, Interval: time.Second},
+		SaveDeck:        {Burst: 5, Interval: time.Second},
+		ChallengePlayer: {Burst: 3, Interval: time.Second},
+		CardsDiscarded:  {Burst: 10, Interval: 100 * time.Millisecond},
+		PlayCard:        {Burst: 10, Interval: 100 * time.Millisecond},
+		Attack:          {Burst: 10, Interval: 100 * time.Millisecond},
+		AttackPlayer:    {Burst: 10, Interval: 100 * time.Millisecond},
+		EndTurn:         {Burst: 10, Interval: 100 * time.Millisecond},
+	},
+	Warnings: 5,
+	Mute:     10 * time.Second,
+	Mutes:    2,
+	Forgive:  time.Minute,
+}
+
+// verdict is what's done with an event a connection sent.
+type verdict int
+
+const (
+	// allow passes the event on
+	allow verdict = iota
+	// warn rejects the event
+	warn
+	// mute rejects the event, and drops what follows for a while
+	mute
+	// drop drops the event without a word, the player being muted
+	drop
+	// disconnect closes the connection
+	disconnect
+)
+
+// limiter holds the buckets of a connection. It's only used by the
+// goroutine reading from it, so it isn't safe for concurrent use.
+type limiter struct {
+	limits Limits
+	clock  Clock
+
+	connection bucket
+	events     map[EventType]*bucket
+
+	warnings   int
+	mutes      int
+	mutedUntil time.Time
+	// when the player last went over a limit
+	strayed time.Time
+}
+
+func newLimiter(limits Limits, clock Clock) *limiter {
+	return &limiter{
+		limits: limits,
+		clock:  clock,
+		events: make(map[EventType]*bucket),
+	}
+}
+
+// judge takes an event of the given type out of the buckets, and tells
+// what to do with it.
+func (l *limiter) judge(kind EventType) verdict {
+	now := l.clock.Now()
+
+	if now.Before(l.mutedUntil) {
+		return drop
+	}
+
+	if l.limits.Forgive > 0 && now.Sub(l.strayed) > l.limits.Forgive {
+		l.warnings = 0
+		l.mutes = 0
+	}
+
+	events, ok := l.events[kind]
+	if !ok {
+		events = &bucket{}
+		l.events[kind] = events
+	}
+
+	rate := l.limits.Events[kind]
+	events.refill(rate, now)
+	l.connection.refill(l.limits.Connection, now)
+
+	if events.has(rate) && l.connection.has(l.limits.Connection) {
+		events.take(rate)
+		l.connection.take(l.limits.Connection)
+		return allow
+	}
+
+	l.strayed = now
+	l.warnings++
+	if l.warnings <= l.limits.Warnings {
+		return warn
+	}
+
+	l.warnings = 0
+	l.mutes++
+	if l.mutes > l.limits.Mutes {
+		return disconnect
+	}
+
+	l.mutedUntil = now.Add(l.limits.Mute)
+	return mute
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens that came in since the bucket was last used.
+// New buckets start full.
+func (b *bucket) refill(rate Rate, now time.Time) {
+	if rate.Burst == 0 {
+		return
+	}
+
+	if b.updated.IsZero() {
+		b.tokens = float64(rate.Burst)
+	} else if rate.Interval > 0 {
+		b.tokens += float64(now.Sub(b.updated)) / float64(rate.Interval)
+	}
+	if b.tokens > float64(rate.Burst) {
+		b.tokens = float64(rate.Burst)
+	}
+	b.updated = now
+}
+
+func (b *bucket) has(rate Rate) bool {
+	return rate.Burst == 0 || b.tokens >= 1
+}
+
+func (b *bucket) take(rate Rate) {
+	if rate.Burst > 0 {
+		b.tokens--
+	}
+}
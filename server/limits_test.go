@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func judgeAll(l *limiter, kind EventType, n int) []verdict {
+	verdicts := make([]verdict, n)
+	for i := range verdicts {
+		verdicts[i] = l.judge(kind)
+	}
+	return verdicts
+}
+
+func expectVerdicts(t *testing.T, got []verdict, expected ...verdict) {
+	t.Helper()
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v verdicts, got %v", len(expected), len(got))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %v for event %v, got %v", expected[i], i, got[i])
+		}
+	}
+}
+
+func TestLimiterRefillsBuckets(t *testing.T) {
+	clock := newTestClock()
+	l := newLimiter(Limits{
+		Connection: Rate{Burst: 2, Interval: time.Second},
+		Warnings:   10,
+	}, clock)
+
+	expectVerdicts(t, judgeAll(l, QueueUp, 3), allow, allow, warn)
+
+	clock.Advance(500 * time.Millisecond)
+	expectVerdicts(t, judgeAll(l, QueueUp, 1), warn)
+
+	clock.Advance(500 * time.Millisecond)
+	expectVerdicts(t, judgeAll(l, QueueUp, 2), allow, warn)
+
+	// buckets don't fill past their burst, however long they sit
+	clock.Advance(time.Hour)
+	expectVerdicts(t, judgeAll(l, QueueUp, 3), allow, allow, warn)
+}
+
+func TestLimiterLimitsEventTypes(t *testing.T) {
+	clock := newTestClock()
+	l := newLimiter(Limits{
+		Connection: Rate{Burst: 4, Interval: time.Second},
+		Events:     map[EventType]Rate{QueueUp: {Burst: 1, Interval: time.Second}},
+		Warnings:   10,
+	}, clock)
+
+	expectVerdicts(t, judgeAll(l, QueueUp, 2), allow, warn)
+
+	// what's over the limit of its type doesn't count against the
+	// connection
+	expectVerdicts(t, judgeAll(l, Dequeue, 4), allow, allow, allow, warn)
+
+	clock.Advance(time.Second)
+	expectVerdicts(t, judgeAll(l, QueueUp, 1), allow)
+}
+
+func TestLimiterEscalates(t *testing.T) {
+	clock := newTestClock()
+	l := newLimiter(Limits{
+		Connection: Rate{Burst: 1, Interval: time.Hour},
+		Warnings:   2,
+		Mute:       time.Minute,
+		Mutes:      1,
+		Forgive:    time.Hour,
+	}, clock)
+
+	expectVerdicts(t, judgeAll(l, EndTurn, 5), allow, warn, warn, mute, drop)
+
+	clock.Advance(time.Minute)
+	expectVerdicts(t, judgeAll(l, EndTurn, 3), warn, warn, disconnect)
+}
+
+func TestLimiterForgives(t *testing.T) {
+	clock := newTestClock()
+	l := newLimiter(Limits{
+		Connection: Rate{Burst: 1, Interval: time.Minute},
+		Warnings:   1,
+		Mute:       time.Minute,
+		Mutes:      1,
+		Forgive:    10 * time.Minute,
+	}, clock)
+
+	expectVerdicts(t, judgeAll(l, EndTurn, 3), allow, warn, mute)
+
+	clock.Advance(11 * time.Minute)
+
+	// it'd have no mutes to spare, and be disconnected, if it weren't
+	// forgiven
+	expectVerdicts(t, judgeAll(l, EndTurn, 3), allow, warn, mute)
+}
+
+func TestDisconnectsPlayersWhoKeepSpamming(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.Limits = Limits{
+		Connection: Rate{Burst: 1, Interval: time.Hour},
+		Warnings:   1,
+		Mute:       time.Hour,
+	}
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+
+	for _, id := range []string{"first", "second", "third"} {
+		client.Send(context.Background(), Event{Id: id, Type: Dequeue})
+	}
+
+	// the first gets past the limits, to find nobody's logged in
+	if response := awaitResponse(t, client, Nack); response.Payload != ErrNotAuthenticated.Error() {
+		t.Errorf("Expected %v, got %v", ErrNotAuthenticated, response.Payload)
+	}
+	if response := awaitResponse(t, client, Nack); response.Payload != ErrRateLimited.Error() {
+		t.Errorf("Expected %v, got %v", ErrRateLimited, response.Payload)
+	}
+	if response := awaitResponse(t, client, Nack); response.Payload != ErrAbusiveRate.Error() {
+		t.Errorf("Expected %v, got %v", ErrAbusiveRate, response.Payload)
+	}
+
+	expectClosed(t, client)
+}
+
+func TestRejectsOversizedMessages(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.Limits.MaxMessageSize = 1024
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	client.Send(context.Background(), Event{Type: Login, Payload: strings.Repeat("x", 2048)})
+
+	expectClosed(t, client)
+}
+
+func expectClosed(t *testing.T, client *Client) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for {
+		if _, err := client.Next(ctx); err != nil {
+			if err == context.DeadlineExceeded {
+				t.Fatal("Expected connection to be closed")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,528 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// characters that can't be mistaken for one another when read aloud
+const lobbyCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const lobbyCodeLength = 6
+
+type Lobby struct {
+	Code    string
+	Mode    GameMode
+	Players []*Player
+	Decks   map[*Player]DeckList
+	Ready   map[*Player]bool
+}
+
+func (l *Lobby) Payload() LobbyPayload {
+	players := make([]LobbyPlayerPayload, 0)
+
+	for _, player := range l.Players {
+		_, hasDeck := l.Decks[player]
+
+		players = append(players, LobbyPlayerPayload{
+			Id:      player.Id,
+			Name:    player.Name,
+			HasDeck: hasDeck,
+			Ready:   l.Ready[player],
+		})
+	}
+
+	return LobbyPayload{
+		Code:    l.Code,
+		Mode:    l.Mode.Name,
+		Players: players,
+	}
+}
+
+func (l *Lobby) Broadcast(response Response) {
+	for _, player := range l.Players {
+		player.Send(response)
+	}
+}
+
+// BroadcastReply broadcasts a response to event, and then acknowledges
+// the event.
+func (l *Lobby) BroadcastReply(event Event, response Response) {
+	for _, player := range l.Players {
+		reply := response
+		if player == event.Player {
+			reply.RequestId = event.Id
+		}
+		player.Send(reply)
+	}
+	event.Ack()
+}
+
+type challenge struct {
+	Id   string
+	Mode GameMode
+	From *Player
+	To   *Player
+}
+
+type LobbyManager struct {
+	store      Store
+	modes      map[string]GameMode
+	lobbies    map[string]*Lobby
+	players    map[*Player]*Lobby
+	online     map[string]*Player
+	challenges map[string]*challenge
+
+	Requests chan WithEvent
+
+	done     chan bool
+	stop     chan bool
+	stopping sync.Once
+}
+
+type WithEvent struct {
+	Event      Event
+	Dispatcher *Dispatcher
+}
+
+func NewLobbyManager(store Store, modes ...GameMode) *LobbyManager {
+	if len(modes) == 0 {
+		modes = DefaultModes
+	}
+
+	manager := &LobbyManager{
+		store:      store,
+		modes:      make(map[string]GameMode),
+		lobbies:    make(map[string]*Lobby),
+		players:    make(map[*Player]*Lobby),
+		online:     make(map[string]*Player),
+		challenges: make(map[string]*challenge),
+
+		Requests: make(chan WithEvent),
+
+		done: make(chan bool),
+		stop: make(chan bool),
+	}
+
+	for _, mode := range modes {
+		manager.modes[mode.Name] = mode
+	}
+
+	go func() {
+		defer close(manager.done)
+
+		for {
+			select {
+			case data := <-manager.Requests:
+				manager.handle(data.Event, data.Dispatcher)
+			case <-manager.stop:
+				for _, lobby := range manager.lobbies {
+					manager.close(lobby)
+				}
+				return
+			}
+		}
+	}()
+
+	return manager
+}
+
+func (lm *LobbyManager) Subscriptions() []Subscription {
+	return subscribe("", PlayerLoggedIn, PlayerDisconnected, CreateLobby, JoinLobby,
+		LeaveLobby, SelectDeck, LobbyReady, ChallengePlayer, AcceptChallenge, DeclineChallenge)
+}
+
+func (lm *LobbyManager) Process(event Event, dispatcher *Dispatcher) {
+	switch event.Type {
+	case PlayerLoggedIn, PlayerDisconnected, CreateLobby, JoinLobby, LeaveLobby,
+		SelectDeck, LobbyReady, ChallengePlayer, AcceptChallenge, DeclineChallenge:
+		select {
+		case lm.Requests <- WithEvent{
+			Event:      event,
+			Dispatcher: dispatcher,
+		}:
+		case <-lm.done:
+		}
+	}
+}
+
+// Stop closes every lobby and stops taking requests.
+func (lm *LobbyManager) Stop(ctx context.Context) {
+	lm.stopping.Do(func() {
+		close(lm.stop)
+	})
+
+	select {
+	case <-lm.done:
+	case <-ctx.Done():
+	}
+}
+
+func (lm *LobbyManager) handle(event Event, dispatcher *Dispatcher) {
+	player := event.Player
+
+	switch event.Type {
+	case PlayerLoggedIn:
+		lm.online[player.Id] = player
+	case CreateLobby:
+		var data CreateLobbyPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		mode, reason := lm.mode(data.Mode)
+		if reason != "" {
+			event.Fail(reason)
+			return
+		}
+
+		if place, ok := player.wait(inLobby); !ok {
+			event.Fail(place.reason())
+			return
+		}
+
+		lobby := lm.open(mode, player)
+		lobby.BroadcastReply(event, Response{
+			Type:    LobbyUpdated,
+			Payload: lobby.Payload(),
+		})
+	case JoinLobby:
+		var data JoinLobbyPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		lobby, ok := lm.lobbies[data.Code]
+		if !ok {
+			event.Fail("Lobby not found")
+			return
+		}
+
+		if len(lobby.Players) == 2 {
+			event.Fail("Lobby is full")
+			return
+		}
+
+		// nobody plays against themselves from another connection
+		for _, other := range lobby.Players {
+			if other.Id != "" && other.Id == player.Id {
+				event.Fail("Already in this lobby")
+				return
+			}
+		}
+
+		if place, ok := player.wait(inLobby); !ok {
+			event.Fail(place.reason())
+			return
+		}
+
+		lobby.Players = append(lobby.Players, player)
+		lm.players[player] = lobby
+
+		lobby.BroadcastReply(event, Response{
+			Type:    LobbyUpdated,
+			Payload: lobby.Payload(),
+		})
+	case PlayerDisconnected:
+		// unless they're already back on another connection
+		if lm.online[player.Id] == player {
+			delete(lm.online, player.Id)
+		}
+		lm.leave(player)
+		lm.withdraw(player)
+	case LeaveLobby:
+		lm.leave(player)
+		event.Ack()
+	case SelectDeck:
+		var data SelectDeckPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		lobby, ok := lm.players[player]
+		if !ok {
+			event.Fail("Not in a lobby")
+			return
+		}
+
+		deck, err := lm.store.GetDeck(data.DeckId)
+		if err != nil || deck.Owner != player.Id {
+			event.Fail("Deck not found")
+			return
+		}
+
+		if len(deck.Cards) != lobby.Mode.Rules.DeckSize {
+			event.Fail(fmt.Sprintf("Deck must have %v cards", lobby.Mode.Rules.DeckSize))
+			return
+		}
+
+		// decks saved before their cards were checked can't be
+		// trusted either
+		if err := deck.Validate(); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		lobby.Decks[player] = deck
+		lobby.Ready[player] = false
+
+		lobby.BroadcastReply(event, Response{
+			Type:    LobbyUpdated,
+			Payload: lobby.Payload(),
+		})
+	case LobbyReady:
+		lobby, ok := lm.players[player]
+		if !ok {
+			event.Fail("Not in a lobby")
+			return
+		}
+
+		if _, ok := lobby.Decks[player]; !ok {
+			event.Fail("Select a deck first")
+			return
+		}
+
+		lobby.Ready[player] = true
+
+		lobby.BroadcastReply(event, Response{
+			Type:    LobbyUpdated,
+			Payload: lobby.Payload(),
+		})
+
+		if len(lobby.Players) == 2 && lobby.Ready[lobby.Players[0]] && lobby.Ready[lobby.Players[1]] {
+			lm.remove(lobby)
+
+			dispatcher.Emit(Event{
+				Type: StartGame,
+				Payload: MatchPayload{
+					Players: lobby.Players,
+					Mode:    lobby.Mode,
+					Decks:   lobby.Decks,
+				},
+			})
+		}
+	case ChallengePlayer:
+		var data ChallengePayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		mode, reason := lm.mode(data.Mode)
+		if reason != "" {
+			event.Fail(reason)
+			return
+		}
+
+		opponent, ok := lm.online[data.AccountId]
+		if !ok || opponent.Id == player.Id {
+			event.Fail("Player is not online")
+			return
+		}
+
+		challenge := &challenge{
+			Id:   uuid.New().String(),
+			Mode: mode,
+			From: player,
+			To:   opponent,
+		}
+		lm.challenges[challenge.Id] = challenge
+
+		event.Ack(Response{
+			Type:    ChallengeSent,
+			Payload: challenge.Id,
+		})
+
+		opponent.Send(Response{
+			Type: ChallengeReceived,
+			Payload: ChallengeReceivedPayload{
+				ChallengeId: challenge.Id,
+				Mode:        mode.Name,
+				From: Identity{
+					Id:   player.Id,
+					Name: player.Name,
+				},
+			},
+		})
+	case AcceptChallenge:
+		var data ChallengeAnswerPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		challenge, ok := lm.challenges[data.ChallengeId]
+		if !ok || challenge.To != player {
+			event.Fail("Challenge not found")
+			return
+		}
+
+		delete(lm.challenges, challenge.Id)
+
+		// neither can be waiting anywhere else, or whoever was
+		// let in is let go again
+		if place, ok := challenge.From.wait(inLobby); !ok {
+			event.Fail(place.reason())
+			return
+		}
+		if place, ok := challenge.To.wait(inLobby); !ok {
+			challenge.From.stopWaiting(inLobby)
+			event.Fail(place.reason())
+			return
+		}
+
+		lobby := lm.open(challenge.Mode, challenge.From)
+		lobby.Players = append(lobby.Players, challenge.To)
+		lm.players[challenge.To] = lobby
+
+		lobby.BroadcastReply(event, Response{
+			Type:    LobbyUpdated,
+			Payload: lobby.Payload(),
+		})
+	case DeclineChallenge:
+		var data ChallengeAnswerPayload
+		if err := event.Decode(&data); err != nil {
+			event.Fail(err.Error())
+			return
+		}
+
+		challenge, ok := lm.challenges[data.ChallengeId]
+		if !ok || challenge.To != player {
+			event.Fail("Challenge not found")
+			return
+		}
+
+		delete(lm.challenges, challenge.Id)
+
+		challenge.From.Send(Response{
+			Type:    ChallengeDeclined,
+			Payload: challenge.Id,
+		})
+		event.Ack()
+	}
+}
+
+// withdraw drops the challenges to or from a player, telling whoever
+// challenged them it's been declined.
+func (lm *LobbyManager) withdraw(player *Player) {
+	for id, challenge := range lm.challenges {
+		if challenge.From != player && challenge.To != player {
+			continue
+		}
+
+		delete(lm.challenges, id)
+
+		if challenge.To == player {
+			challenge.From.Send(Response{
+				Type:    ChallengeDeclined,
+				Payload: challenge.Id,
+			})
+		}
+	}
+}
+
+// mode finds the mode a lobby is played in, casual unless named, or why
+// it can't be. Private games are never ranked, so nobody gains rating
+// off a friend throwing games, and bots only play through the queue.
+func (lm *LobbyManager) mode(name string) (GameMode, string) {
+	if name == "" {
+		name = CasualMode.Name
+	}
+
+	mode, ok := lm.modes[name]
+	if !ok {
+		return mode, "Unknown game mode"
+	}
+	if mode.Ranked || mode.AgainstBots {
+		return mode, "Mode not available in lobbies"
+	}
+	return mode, ""
+}
+
+func (lm *LobbyManager) open(mode GameMode, owner *Player) *Lobby {
+	code := lm.code()
+
+	lobby := &Lobby{
+		Code:    code,
+		Mode:    mode,
+		Players: []*Player{owner},
+		Decks:   make(map[*Player]DeckList),
+		Ready:   make(map[*Player]bool),
+	}
+
+	lm.lobbies[code] = lobby
+	lm.players[owner] = lobby
+
+	return lobby
+}
+
+func (lm *LobbyManager) remove(lobby *Lobby) {
+	delete(lm.lobbies, lobby.Code)
+	for _, player := range lobby.Players {
+		delete(lm.players, player)
+		player.stopWaiting(inLobby)
+	}
+}
+
+func (lm *LobbyManager) leave(player *Player) {
+	lobby, ok := lm.players[player]
+	if !ok {
+		return
+	}
+
+	// the lobby goes away with its owner, anyone else just leaves
+	if lobby.Players[0] == player {
+		lm.close(lobby)
+		return
+	}
+
+	delete(lm.players, player)
+	player.stopWaiting(inLobby)
+	delete(lobby.Decks, player)
+	delete(lobby.Ready, player)
+	lobby.Players = lobby.Players[:1]
+
+	player.Send(Response{
+		Type:    LobbyClosed,
+		Payload: lobby.Code,
+	})
+
+	lobby.Broadcast(Response{
+		Type:    LobbyUpdated,
+		Payload: lobby.Payload(),
+	})
+}
+
+func (lm *LobbyManager) close(lobby *Lobby) {
+	lm.remove(lobby)
+	lobby.Broadcast(Response{
+		Type:    LobbyClosed,
+		Payload: lobby.Code,
+	})
+}
+
+// code picks a code no open lobby has. Codes are all it takes to join,
+// so they come from crypto/rand, which like uuid.New it panics without.
+func (lm *LobbyManager) code() string {
+	for {
+		code := make([]byte, lobbyCodeLength)
+		if _, err := rand.Read(code); err != nil {
+			panic(err)
+		}
+
+		// the alphabet fits a byte evenly, so no letter is likelier
+		for i, b := range code {
+			code[i] = lobbyCodeAlphabet[int(b)%len(lobbyCodeAlphabet)]
+		}
+
+		if _, ok := lm.lobbies[string(code)]; !ok {
+			return string(code)
+		}
+	}
+}
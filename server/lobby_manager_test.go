@@ -0,0 +1,470 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func testDeck(store Store, owner string, size int) DeckList {
+	deck := DeckList{
+		Id:    owner + "-deck",
+		Owner: owner,
+		Cards: make([]CardSpec, size),
+	}
+	for i := range deck.Cards {
+		deck.Cards[i] = CardSpec{ManaCost: 1, Damage: 1, Health: 1}
+	}
+	store.SaveDeck(deck)
+	return deck
+}
+
+func expectResponse(t *testing.T, player *Player, expected ResponseType) Response {
+	t.Helper()
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != expected {
+			t.Fatalf("Expected %v, got %v (%v)", expected, response.Type, response.Payload)
+		}
+		return response
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %v response", expected)
+	}
+	return Response{}
+}
+
+func createLobby(t *testing.T, manager *LobbyManager, owner, guest *Player) string {
+	go manager.Process(Event{
+		Type:   CreateLobby,
+		Player: owner,
+	}, nil)
+
+	lobby := expectResponse(t, owner, LobbyUpdated).Payload.(LobbyPayload)
+
+	if len(lobby.Code) != lobbyCodeLength {
+		t.Errorf("Expected code with %v characters, got %v", lobbyCodeLength, lobby.Code)
+	}
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  guest,
+		Payload: JoinLobbyPayload{Code: lobby.Code},
+	}, nil)
+
+	expectResponse(t, owner, LobbyUpdated)
+	expectResponse(t, guest, LobbyUpdated)
+
+	return lobby.Code
+}
+
+func TestJoinLobbyWithCode(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	owner := NewTestPlayer()
+	owner.Identify(Identity{Id: "owner"})
+
+	guest := NewTestPlayer()
+	guest.Identify(Identity{Id: "guest"})
+
+	go manager.Process(Event{
+		Type:   CreateLobby,
+		Player: owner,
+	}, nil)
+
+	created := expectResponse(t, owner, LobbyUpdated).Payload.(LobbyPayload)
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  guest,
+		Payload: map[string]interface{}{"Code": created.Code},
+	}, nil)
+
+	expectResponse(t, owner, LobbyUpdated)
+	joined := expectResponse(t, guest, LobbyUpdated).Payload.(LobbyPayload)
+
+	if len(joined.Players) != 2 {
+		t.Errorf("Expected %v players, got %v", 2, len(joined.Players))
+	}
+	if joined.Code != created.Code {
+		t.Errorf("Expected %v, got %v", created.Code, joined.Code)
+	}
+}
+
+func TestJoinUnknownLobby(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+	player := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  player,
+		Payload: JoinLobbyPayload{Code: "NOPE"},
+	}, nil)
+
+	expectResponse(t, player, Error)
+}
+
+func TestLobbyIsFull(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	owner := NewTestPlayer()
+	guest := NewTestPlayer()
+	other := NewTestPlayer()
+
+	code := createLobby(t, manager, owner, guest)
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  other,
+		Payload: JoinLobbyPayload{Code: code},
+	}, nil)
+
+	expectResponse(t, other, Error)
+}
+
+func TestCannotJoinOwnLobbyFromAnotherConnection(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	owner := NewTestPlayer()
+	owner.Identify(Identity{Id: "42"})
+
+	again := NewTestPlayer()
+	again.Identify(Identity{Id: "42"})
+
+	go manager.Process(Event{
+		Type:   CreateLobby,
+		Player: owner,
+	}, nil)
+
+	lobby := expectResponse(t, owner, LobbyUpdated).Payload.(LobbyPayload)
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  again,
+		Payload: JoinLobbyPayload{Code: lobby.Code},
+	}, nil)
+
+	if response := expectResponse(t, again, Error); response.Payload != "Already in this lobby" {
+		t.Errorf("Expected %q, got %v", "Already in this lobby", response.Payload)
+	}
+}
+
+func TestReadyRequiresDeck(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	owner := NewTestPlayer()
+	guest := NewTestPlayer()
+
+	createLobby(t, manager, owner, guest)
+
+	go manager.Process(Event{
+		Type:   LobbyReady,
+		Player: owner,
+	}, nil)
+
+	expectResponse(t, owner, Error)
+}
+
+func TestRejectsDeckWithWrongSize(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewLobbyManager(store)
+
+	owner := NewTestPlayer()
+	owner.Identify(Identity{Id: "owner"})
+	guest := NewTestPlayer()
+
+	deck := testDeck(store, "owner", 10)
+
+	createLobby(t, manager, owner, guest)
+
+	go manager.Process(Event{
+		Type:    SelectDeck,
+		Player:  owner,
+		Payload: SelectDeckPayload{DeckId: deck.Id},
+	}, nil)
+
+	expectResponse(t, owner, Error)
+}
+
+func TestRejectsDeckWithCardsOutOfBounds(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewLobbyManager(store)
+
+	owner := NewTestPlayer()
+	owner.Identify(Identity{Id: "owner"})
+	guest := NewTestPlayer()
+
+	// saved straight to the store, as decks were before being checked
+	deck := testDeck(store, "owner", DefaultRules.DeckSize)
+	deck.Cards[0].ManaCost = -10
+	store.SaveDeck(deck)
+
+	createLobby(t, manager, owner, guest)
+
+	go manager.Process(Event{
+		Type:    SelectDeck,
+		Player:  owner,
+		Payload: SelectDeckPayload{DeckId: deck.Id},
+	}, nil)
+
+	expectResponse(t, owner, Error)
+}
+
+func TestStartsGameWhenBothReady(t *testing.T) {
+	store := NewMemoryStore()
+	manager := NewLobbyManager(store)
+	dispatcher := NewTestDispatcher()
+
+	owner := NewTestPlayer()
+	owner.Identify(Identity{Id: "owner"})
+
+	guest := NewTestPlayer()
+	guest.Identify(Identity{Id: "guest"})
+
+	createLobby(t, manager, owner, guest)
+
+	for _, player := range []*Player{owner, guest} {
+		deck := testDeck(store, player.Id, DefaultRules.DeckSize)
+
+		go manager.Process(Event{
+			Type:    SelectDeck,
+			Player:  player,
+			Payload: SelectDeckPayload{DeckId: deck.Id},
+		}, dispatcher)
+
+		expectResponse(t, owner, LobbyUpdated)
+		expectResponse(t, guest, LobbyUpdated)
+
+		go manager.Process(Event{
+			Type:   LobbyReady,
+			Player: player,
+		}, dispatcher)
+
+		expectResponse(t, owner, LobbyUpdated)
+		expectResponse(t, guest, LobbyUpdated)
+	}
+
+	select {
+	case event := <-dispatcher.Dispatch:
+		if event.Type != StartGame {
+			t.Fatalf("Expected %v, got %v", StartGame, event.Type)
+		}
+
+		payload := event.Payload.(MatchPayload)
+		if len(payload.Players) != 2 {
+			t.Errorf("Expected %v players, got %v", 2, len(payload.Players))
+		}
+		if payload.Decks[guest].Id != "guest-deck" {
+			t.Errorf("Expected %v, got %v", "guest-deck", payload.Decks[guest].Id)
+		}
+		if payload.Mode.Ranked {
+			t.Error("Expected a casual lobby not to be ranked")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected game to start")
+	}
+}
+
+func TestOwnerLeavingClosesLobby(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	owner := NewTestPlayer()
+	guest := NewTestPlayer()
+
+	code := createLobby(t, manager, owner, guest)
+
+	go manager.Process(Event{
+		Type:   LeaveLobby,
+		Player: owner,
+	}, nil)
+
+	expectResponse(t, owner, LobbyClosed)
+	expectResponse(t, guest, LobbyClosed)
+
+	other := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    JoinLobby,
+		Player:  other,
+		Payload: JoinLobbyPayload{Code: code},
+	}, nil)
+
+	expectResponse(t, other, Error)
+}
+
+func loginToLobbies(manager *LobbyManager, id string) *Player {
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: id, Name: id})
+
+	manager.Process(Event{
+		Type:   PlayerLoggedIn,
+		Player: player,
+	}, nil)
+
+	return player
+}
+
+func TestAcceptChallenge(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	challenger := loginToLobbies(manager, "challenger")
+	opponent := loginToLobbies(manager, "opponent")
+
+	go manager.Process(Event{
+		Type:    ChallengePlayer,
+		Player:  challenger,
+		Payload: ChallengePayload{AccountId: "opponent"},
+	}, nil)
+
+	expectResponse(t, challenger, ChallengeSent)
+	received := expectResponse(t, opponent, ChallengeReceived).Payload.(ChallengeReceivedPayload)
+
+	if received.From.Id != "challenger" {
+		t.Errorf("Expected %v, got %v", "challenger", received.From.Id)
+	}
+
+	go manager.Process(Event{
+		Type:    AcceptChallenge,
+		Player:  opponent,
+		Payload: ChallengeAnswerPayload{ChallengeId: received.ChallengeId},
+	}, nil)
+
+	lobby := expectResponse(t, challenger, LobbyUpdated).Payload.(LobbyPayload)
+	expectResponse(t, opponent, LobbyUpdated)
+
+	if len(lobby.Players) != 2 {
+		t.Errorf("Expected %v players, got %v", 2, len(lobby.Players))
+	}
+}
+
+func TestDeclineChallenge(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	challenger := loginToLobbies(manager, "challenger")
+	opponent := loginToLobbies(manager, "opponent")
+
+	go manager.Process(Event{
+		Type:    ChallengePlayer,
+		Player:  challenger,
+		Payload: ChallengePayload{AccountId: "opponent"},
+	}, nil)
+
+	id := expectResponse(t, challenger, ChallengeSent).Payload.(string)
+	expectResponse(t, opponent, ChallengeReceived)
+
+	go manager.Process(Event{
+		Type:    DeclineChallenge,
+		Player:  opponent,
+		Payload: ChallengeAnswerPayload{ChallengeId: id},
+	}, nil)
+
+	declined := expectResponse(t, challenger, ChallengeDeclined)
+	if declined.Payload != id {
+		t.Errorf("Expected %v, got %v", id, declined.Payload)
+	}
+}
+
+func TestChallengeOfflinePlayer(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+	challenger := loginToLobbies(manager, "challenger")
+
+	go manager.Process(Event{
+		Type:    ChallengePlayer,
+		Player:  challenger,
+		Payload: ChallengePayload{AccountId: "nobody"},
+	}, nil)
+
+	expectResponse(t, challenger, Error)
+}
+
+func TestDisconnectingDropsChallenges(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	challenger := loginToLobbies(manager, "challenger")
+	opponent := loginToLobbies(manager, "opponent")
+
+	go manager.Process(Event{
+		Type:    ChallengePlayer,
+		Player:  challenger,
+		Payload: ChallengePayload{AccountId: "opponent"},
+	}, nil)
+
+	id := expectResponse(t, challenger, ChallengeSent).Payload.(string)
+	expectResponse(t, opponent, ChallengeReceived)
+
+	go manager.Process(Event{
+		Type:   PlayerDisconnected,
+		Player: opponent,
+	}, nil)
+
+	declined := expectResponse(t, challenger, ChallengeDeclined)
+	if declined.Payload != id {
+		t.Errorf("Expected %v, got %v", id, declined.Payload)
+	}
+
+	go manager.Process(Event{
+		Type:    AcceptChallenge,
+		Player:  opponent,
+		Payload: ChallengeAnswerPayload{ChallengeId: id},
+	}, nil)
+	expectResponse(t, opponent, Error)
+}
+
+func TestLobbiesAreNeverRankedOrAgainstBots(t *testing.T) {
+	manager := NewLobbyManager(NewMemoryStore())
+
+	challenger := loginToLobbies(manager, "challenger")
+	loginToLobbies(manager, "opponent")
+
+	for _, mode := range []GameMode{RankedMode, AIMode} {
+		go manager.Process(Event{
+			Type:    CreateLobby,
+			Player:  challenger,
+			Payload: CreateLobbyPayload{Mode: mode.Name},
+		}, nil)
+
+		if reason := expectResponse(t, challenger, Error).Payload; reason != "Mode not available in lobbies" {
+			t.Errorf("Expected a %v lobby to be refused, got %v", mode.Name, reason)
+		}
+
+		go manager.Process(Event{
+			Type:    ChallengePlayer,
+			Player:  challenger,
+			Payload: ChallengePayload{AccountId: "opponent", Mode: mode.Name},
+		}, nil)
+
+		if reason := expectResponse(t, challenger, Error).Payload; reason != "Mode not available in lobbies" {
+			t.Errorf("Expected a %v challenge to be refused, got %v", mode.Name, reason)
+		}
+	}
+}
+
+func TestPlayersCantBeQueuedAndInALobby(t *testing.T) {
+	lobbies := NewLobbyManager(NewMemoryStore())
+	queue := NewQueueManager()
+	dispatcher := NewDispatcher()
+
+	player := NewTestPlayer()
+	queueUp := Event{Type: QueueUp, Player: player, Payload: QueueUpPayload{Mode: CasualMode.Name}}
+	createLobby := Event{Type: CreateLobby, Player: player}
+
+	go queue.Process(queueUp, dispatcher)
+	expectResponse(t, player, WaitForMatch)
+
+	go lobbies.Process(createLobby, nil)
+	expectResponse(t, player, Error)
+
+	go queue.Process(Event{Type: Dequeue, Player: player}, dispatcher)
+	expectResponse(t, player, Dequeued)
+
+	go lobbies.Process(createLobby, nil)
+	expectResponse(t, player, LobbyUpdated)
+
+	go queue.Process(queueUp, dispatcher)
+	expectResponse(t, player, Error)
+
+	go lobbies.Process(Event{Type: LeaveLobby, Player: player}, nil)
+	expectResponse(t, player, LobbyClosed)
+
+	go queue.Process(queueUp, dispatcher)
+	expectResponse(t, player, WaitForMatch)
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,63 +11,107 @@ import (
 type WithDispatcher struct {
 	Player     *Player
 	Dispatcher *Dispatcher
+	Event      Event
+}
+
+type MatchPayload struct {
+	Players []*Player
+	Mode    GameMode
+	Decks   map[*Player]DeckList
 }
 
 type Match struct {
 	Id        uuid.UUID
+	Mode      GameMode
 	Players   []*Player
 	Confirmed []*Player
 	Duration  time.Duration
 
-	Ready   chan bool
+	clock Clock
+
 	Confirm chan WithDispatcher
 	Cancel  chan *Dispatcher
+	Leave   chan WithDispatcher
+
+	// done is closed once the match is ready, canceled or stopped
+	done     chan bool
+	stop     chan bool
+	stopping sync.Once
 }
 
-func NewMatch(players []*Player, confirmDuration time.Duration) *Match {
+func NewMatch(players []*Player, mode GameMode, confirmDuration time.Duration) *Match {
+	return NewMatchWithClock(players, mode, confirmDuration, RealClock)
+}
+
+func NewMatchWithClock(players []*Player, mode GameMode, confirmDuration time.Duration, clock Clock) *Match {
 	match := &Match{
 		Id:        uuid.New(),
+		Mode:      mode,
 		Players:   players,
 		Duration:  confirmDuration,
 		Confirmed: make([]*Player, 0),
 
-		Ready:   make(chan bool),
+		clock: clock,
+
 		Confirm: make(chan WithDispatcher),
 		Cancel:  make(chan *Dispatcher),
+		Leave:   make(chan WithDispatcher),
+
+		done: make(chan bool),
+		stop: make(chan bool),
 	}
 
 	go func() {
+		defer close(match.done)
+
 		for {
 			select {
-			case dispatcher := <-match.Cancel:
+			case <-match.stop:
+				match.release()
+
+				// nobody's requeued, as the server is going away
 				for _, player := range match.Players {
 					player.Send(Response{
-						Type: MatchCanceled,
+						Type:    MatchCanceled,
+						Payload: MatchCanceledPayload{},
 					})
 				}
-				for _, player := range match.Confirmed {
-					dispatcher.Dispatch <- Event{
-						Type:   QueueUp,
-						Player: player,
+				return
+			case dispatcher := <-match.Cancel:
+				match.cancel(dispatcher, match.Confirmed)
+				return
+			case data := <-match.Leave:
+				// whoever's left didn't do anything wrong, confirmed
+				// or not
+				others := make([]*Player, 0, len(match.Players))
+				for _, player := range match.Players {
+					if player != data.Player {
+						others = append(others, player)
 					}
 				}
 
-				dispatcher.Unregister <- match
+				match.cancel(data.Dispatcher, others)
+				return
 			case data := <-match.Confirm:
-				data.Player.Send(Response{
+				data.Event.Ack(Response{
 					Type: WaitOtherPlayers,
 				})
 
 				match.Confirmed = append(match.Confirmed, data.Player)
 
 				if len(match.Confirmed) == len(match.Players) {
-					data.Dispatcher.Dispatch <- Event{
-						Type:    StartGame,
-						Payload: match.Confirmed,
-					}
+					match.release()
 
-					match.Ready <- true
-					data.Dispatcher.Unregister <- match
+					data.Dispatcher.Emit(Event{
+						Type: StartGame,
+						Payload: MatchPayload{
+							Players: match.Confirmed,
+							Mode:    match.Mode,
+						},
+					})
+
+					data.Dispatcher.Remove(match)
+					return
 				}
 			}
 		}
@@ -74,6 +120,44 @@ func NewMatch(players []*Player, confirmDuration time.Duration) *Match {
 	return match
 }
 
+// cancel tells the players the match is off and puts those in requeue
+// back in the queue.
+func (m *Match) cancel(dispatcher *Dispatcher, requeue []*Player) {
+	// removed before anyone hears of it, so nothing they send
+	// afterwards reaches the match
+	dispatcher.Remove(m)
+	m.release()
+
+	requeued := make(map[*Player]bool)
+	for _, player := range requeue {
+		requeued[player] = !player.Bot
+	}
+
+	for _, player := range m.Players {
+		player.Send(Response{
+			Type:    MatchCanceled,
+			Payload: MatchCanceledPayload{Requeued: requeued[player]},
+		})
+	}
+	for _, player := range requeue {
+		if !requeued[player] {
+			continue
+		}
+
+		dispatcher.Emit(Event{
+			Type:   QueueUp,
+			Player: player,
+			Payload: QueueUpPayload{
+				Mode: m.Mode.Name,
+			},
+		})
+	}
+}
+
+func (m *Match) Subscriptions() []Subscription {
+	return subscribe(m.Id.String(), AskConfirmation, MatchConfirmed, MatchDeclined)
+}
+
 func (m *Match) Process(event Event, dispatcher *Dispatcher) {
 	switch event.Type {
 	case AskConfirmation:
@@ -92,32 +176,102 @@ func (m *Match) Process(event Event, dispatcher *Dispatcher) {
 
 		go func() {
 			select {
-			case <-time.After(m.Duration):
-				m.Cancel <- dispatcher
-			case <-m.Ready:
-				break
+			case <-m.clock.After(m.Duration):
+				select {
+				case m.Cancel <- dispatcher:
+				case <-m.done:
+				}
+			case <-m.done:
 			}
 		}()
 	case MatchConfirmed:
-		uuid, err := uuid.Parse(event.Payload.(string))
+		var id uuid.UUID
+		if err := event.Decode(&id); err != nil {
+			go event.Fail(err.Error())
+			return
+		}
 
-		if err != nil || uuid != m.Id {
+		if id != m.Id {
 			return
 		}
 
-		for _, player := range m.Players {
-			if player == event.Player {
-				m.Confirm <- WithDispatcher{
-					Player:     player,
-					Dispatcher: dispatcher,
-				}
-			}
+		if !m.has(event.Player) {
+			go event.Fail("Not in this match")
+			return
+		}
+
+		select {
+		case m.Confirm <- WithDispatcher{
+			Player:     event.Player,
+			Dispatcher: dispatcher,
+			Event:      event,
+		}:
+		case <-m.done:
+			go event.Fail("Match is over")
 		}
 	case MatchDeclined:
-		uuid, err := uuid.Parse(event.Payload.(string))
-		if err != nil || uuid != m.Id {
+		var id uuid.UUID
+		if err := event.Decode(&id); err != nil {
+			go event.Fail(err.Error())
 			return
 		}
-		m.Cancel <- dispatcher
+
+		if id != m.Id {
+			return
+		}
+
+		if !m.has(event.Player) {
+			go event.Fail("Not in this match")
+			return
+		}
+
+		select {
+		case m.Cancel <- dispatcher:
+			go event.Ack()
+		case <-m.done:
+			go event.Fail("Match is over")
+		}
+	}
+}
+
+// leave cancels the match for a player who's gone, unless it's already
+// over. The matchmaker passes on their disconnect.
+func (m *Match) leave(player *Player, dispatcher *Dispatcher) {
+	select {
+	case m.Leave <- WithDispatcher{
+		Player:     player,
+		Dispatcher: dispatcher,
+	}:
+	case <-m.done:
+	}
+}
+
+// release lets the players wait for something else, before any of them
+// is requeued.
+func (m *Match) release() {
+	for _, player := range m.Players {
+		player.stopWaiting(inMatch)
+	}
+}
+
+func (m *Match) has(player *Player) bool {
+	for _, in := range m.Players {
+		if in == player {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop cancels the match, unless it's already over, without requeueing
+// anyone.
+func (m *Match) Stop(ctx context.Context) {
+	m.stopping.Do(func() {
+		close(m.stop)
+	})
+
+	select {
+	case <-m.done:
+	case <-ctx.Done():
 	}
 }
@@ -1,26 +1,85 @@
 package server
 
-import "time"
+import "sync"
 
-type Matchmaker struct{}
+type Matchmaker struct {
+	config MatchmakerConfig
+	clock  Clock
+
+	// matches are the matches players are yet to confirm, so their
+	// disconnects go to the one match they're in
+	mutex   sync.Mutex
+	matches map[*Player]*Match
+}
 
 func NewMatchmaker() *Matchmaker {
-	return &Matchmaker{}
+	return NewMatchmakerWithClock(RealClock)
+}
+
+func NewMatchmakerWithClock(clock Clock) *Matchmaker {
+	return NewMatchmakerWithConfig(DefaultMatchmakerConfig, clock)
+}
+
+func NewMatchmakerWithConfig(config MatchmakerConfig, clock Clock) *Matchmaker {
+	return &Matchmaker{
+		config:  config,
+		clock:   clock,
+		matches: make(map[*Player]*Match),
+	}
+}
+
+func (m *Matchmaker) Subscriptions() []Subscription {
+	return subscribe("", CreateMatch, PlayerDisconnected)
 }
 
 func (m *Matchmaker) Process(event Event, dispatcher *Dispatcher) {
 	switch event.Type {
 	case CreateMatch:
 		go func() {
-			players := event.Payload.([]*Player)
-			match := NewMatch(players, 15*time.Second)
+			data := event.Payload.(MatchPayload)
+			match := NewMatchWithClock(data.Players, data.Mode, m.config.Confirm, m.clock)
 
-			dispatcher.Register <- match
+			metrics := dispatcher.Metrics()
+			metrics.activeMatches.Add("", 1)
 
-			dispatcher.Dispatch <- Event{
+			m.track(match)
+			dispatcher.Add(match)
+			dispatcher.Emit(Event{
 				Type:    AskConfirmation,
 				Payload: match.Id,
-			}
+			})
+
+			<-match.done
+			m.untrack(match)
+			metrics.activeMatches.Add("", -1)
 		}()
+	case PlayerDisconnected:
+		m.mutex.Lock()
+		match, ok := m.matches[event.Player]
+		m.mutex.Unlock()
+
+		if ok {
+			go match.leave(event.Player, dispatcher)
+		}
+	}
+}
+
+func (m *Matchmaker) track(match *Match) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, player := range match.Players {
+		m.matches[player] = match
+	}
+}
+
+func (m *Matchmaker) untrack(match *Match) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, player := range match.Players {
+		if m.matches[player] == match {
+			delete(m.matches, player)
+		}
 	}
 }
@@ -16,7 +16,7 @@ func TestSendsMatchFoundToPlayers(t *testing.T) {
 
 	go maker.Process(Event{
 		Type:    CreateMatch,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	select {
@@ -49,7 +49,7 @@ func TestRegistersMatchAsHandler(t *testing.T) {
 
 	go maker.Process(Event{
 		Type:    CreateMatch,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	match := <-dispatcher.Register
@@ -68,7 +68,7 @@ func TestConfirmMatch(t *testing.T) {
 
 	go maker.Process(Event{
 		Type:    CreateMatch,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	res := <-p1.Outgoing
@@ -101,7 +101,7 @@ func TestRefuseMatch(t *testing.T) {
 
 	go maker.Process(Event{
 		Type:    CreateMatch,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	res := <-p1.Outgoing
@@ -125,6 +125,32 @@ func TestRefuseMatch(t *testing.T) {
 	}
 }
 
+func TestOnlyPlayersInMatchCanDeclineIt(t *testing.T) {
+	match := NewMatch([]*Player{NewTestPlayer(), NewTestPlayer()}, CasualMode, time.Minute)
+	outsider := NewTestPlayer()
+
+	go match.Process(Event{
+		Type:    MatchDeclined,
+		Player:  outsider,
+		Payload: match.Id.String(),
+	}, NewTestDispatcher())
+
+	select {
+	case response := <-outsider.Outgoing:
+		if response.Type != Error || response.Payload != "Not in this match" {
+			t.Errorf("Expected declining to fail, got %v %v", response.Type, response.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected response from server")
+	}
+
+	select {
+	case <-match.done:
+		t.Error("Expected the match to go on")
+	default:
+	}
+}
+
 func TestConfirmedPlayersGoBackToQueue(t *testing.T) {
 	maker := NewMatchmaker()
 
@@ -136,7 +162,7 @@ func TestConfirmedPlayersGoBackToQueue(t *testing.T) {
 
 	go maker.Process(Event{
 		Type:    CreateMatch,
-		Payload: []*Player{p1, p2},
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
 	}, dispatcher)
 
 	res := <-p1.Outgoing // match found
@@ -158,8 +184,12 @@ func TestConfirmedPlayersGoBackToQueue(t *testing.T) {
 		Payload: uuid.String(),
 	}
 
-	<-p1.Outgoing // match canceled
-	<-p2.Outgoing // match canceled
+	if canceled := <-p1.Outgoing; canceled.Payload != (MatchCanceledPayload{}) {
+		t.Errorf("Expected whoever declined not to be requeued, got %+v", canceled.Payload)
+	}
+	if canceled := <-p2.Outgoing; canceled.Payload != (MatchCanceledPayload{Requeued: true}) {
+		t.Errorf("Expected whoever confirmed to be told they're requeued, got %+v", canceled.Payload)
+	}
 
 	select {
 	case requeue := <-p2.Outgoing:
@@ -181,8 +211,9 @@ func TestMatchIsCanceledIfPlayersDontConfirm(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
+	clock := newTestClock()
 	dispatcher := NewDispatcher()
-	match := NewMatch([]*Player{p1, p2}, 5*time.Millisecond)
+	match := NewMatchWithClock([]*Player{p1, p2}, CasualMode, 15*time.Second, clock)
 
 	dispatcher.Register <- match
 
@@ -194,12 +225,15 @@ func TestMatchIsCanceledIfPlayersDontConfirm(t *testing.T) {
 	<-p1.Outgoing
 	<-p2.Outgoing
 
+	clock.BlockUntil(1)
+	clock.Advance(15 * time.Second)
+
 	select {
 	case res := <-p1.Outgoing:
 		if res.Type != MatchCanceled {
 			t.Errorf("Expected %v, got %v", MatchCanceled, res.Type)
 		}
-	case <-time.After(6 * time.Millisecond):
+	case <-time.After(time.Second):
 		t.Error("Expected response from server")
 	}
 
@@ -208,7 +242,7 @@ func TestMatchIsCanceledIfPlayersDontConfirm(t *testing.T) {
 		if res.Type != MatchCanceled {
 			t.Errorf("Expected %v, got %v", MatchCanceled, res.Type)
 		}
-	case <-time.After(6 * time.Millisecond):
+	case <-time.After(time.Second):
 		t.Error("Expected response from server")
 	}
 }
@@ -218,7 +252,7 @@ func TestDispatchesStartGame(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewTestDispatcher()
-	match := NewMatch([]*Player{p1, p2}, time.Minute)
+	match := NewMatch([]*Player{p1, p2}, CasualMode, time.Minute)
 
 	go match.Process(Event{
 		Type:    MatchConfirmed,
@@ -241,7 +275,7 @@ func TestDispatchesStartGame(t *testing.T) {
 		if event.Type != StartGame {
 			t.Errorf("Expected %v, got %v", StartGame, event.Type)
 		}
-		players := event.Payload.([]*Player)
+		players := event.Payload.(MatchPayload).Players
 		if len(players) != 2 {
 			t.Errorf("Expected 2 players, got %v", len(players))
 		}
@@ -255,7 +289,7 @@ func TestMatchIsRemovedFromDispatcherWhenMatchIsCanceled(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewDispatcher()
-	match := NewMatch([]*Player{p1, p2}, time.Minute)
+	match := NewMatch([]*Player{p1, p2}, CasualMode, time.Minute)
 
 	dispatcher.Register <- match
 
@@ -286,7 +320,7 @@ func TestMatchIsRemovedFromDispatcherWhenMatchIsReady(t *testing.T) {
 	p2 := NewTestPlayer()
 
 	dispatcher := NewDispatcher()
-	match := NewMatch([]*Player{p1, p2}, time.Minute)
+	match := NewMatch([]*Player{p1, p2}, CasualMode, time.Minute)
 
 	dispatcher.Register <- match
 
@@ -331,8 +365,9 @@ func TestDoesNotTimeoutAfterReady(t *testing.T) {
 	p1 := NewTestPlayer()
 	p2 := NewTestPlayer()
 
+	clock := newTestClock()
 	dispatcher := NewDispatcher()
-	match := NewMatch([]*Player{p1, p2}, 100*time.Millisecond)
+	match := NewMatchWithClock([]*Player{p1, p2}, CasualMode, 15*time.Second, clock)
 
 	dispatcher.Register <- match
 
@@ -360,11 +395,44 @@ func TestDoesNotTimeoutAfterReady(t *testing.T) {
 
 	<-p2.Outgoing // wait other players
 
+	clock.BlockUntil(1)
+	clock.Advance(15 * time.Second)
+
 	select {
-	case <-time.After(200 * time.Millisecond):
+	case <-time.After(100 * time.Millisecond):
 	case <-p1.Outgoing:
 		t.Error("Match should not timeout")
 	case <-p2.Outgoing:
 		t.Error("Match should not timeout")
 	}
 }
+
+func TestDisconnectsOnlyReachTheirMatch(t *testing.T) {
+	maker := NewMatchmaker()
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- maker
+
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	p3, p4 := NewTestPlayer(), NewTestPlayer()
+
+	for _, players := range [][]*Player{{p1, p2}, {p3, p4}} {
+		dispatcher.Emit(Event{
+			Type:    CreateMatch,
+			Payload: MatchPayload{Players: players, Mode: CasualMode},
+		})
+	}
+	for _, player := range []*Player{p1, p2, p3, p4} {
+		expectResponse(t, player, MatchFound)
+	}
+
+	dispatcher.Emit(Event{Type: PlayerDisconnected, Player: p1})
+
+	expectResponse(t, p1, MatchCanceled)
+	expectResponse(t, p2, MatchCanceled)
+
+	select {
+	case response := <-p3.Outgoing:
+		t.Errorf("Expected the other match to go on, got %v", response.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import "sync"
+
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	accounts map[string]Account
+	decks    map[string]DeckList
+	games    []GameRecord
+	replays  map[string]Replay
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		accounts: make(map[string]Account),
+		decks:    make(map[string]DeckList),
+		games:    make([]GameRecord, 0),
+		replays:  make(map[string]Replay),
+	}
+}
+
+func (s *MemoryStore) SaveAccount(account Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.accounts[account.Id] = account
+	return nil
+}
+
+func (s *MemoryStore) GetAccount(id string) (Account, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return account, nil
+}
+
+func (s *MemoryStore) SaveDeck(deck DeckList) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.decks[deck.Id] = deck
+	return nil
+}
+
+func (s *MemoryStore) GetDeck(id string) (DeckList, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	deck, ok := s.decks[id]
+	if !ok {
+		return DeckList{}, ErrNotFound
+	}
+	return deck, nil
+}
+
+func (s *MemoryStore) GetDecks(owner string) ([]DeckList, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	decks := make([]DeckList, 0)
+	for _, deck := range s.decks {
+		if deck.Owner == owner {
+			decks = append(decks, deck)
+		}
+	}
+	return decks, nil
+}
+
+func (s *MemoryStore) SaveGame(record GameRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.games = append(s.games, record)
+	return nil
+}
+
+func (s *MemoryStore) GetMatchHistory(accountId string, limit int) ([]GameRecord, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	history := make([]GameRecord, 0)
+
+	// most recent first
+	for i := len(s.games) - 1; i >= 0; i-- {
+		if limit > 0 && len(history) == limit {
+			break
+		}
+		for _, player := range s.games[i].Players {
+			if player == accountId {
+				history = append(history, s.games[i])
+				break
+			}
+		}
+	}
+	return history, nil
+}
+
+func (s *MemoryStore) SaveReplay(replay Replay) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replays[replay.Id] = replay
+	return nil
+}
+
+func (s *MemoryStore) GetReplay(id string) (Replay, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	replay, ok := s.replays[id]
+	if !ok {
+		return Replay{}, ErrNotFound
+	}
+	return replay, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
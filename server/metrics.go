@@ -0,0 +1,371 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Metrics is what the server tells about itself on /metrics, in the
+// Prometheus text format. Handlers get to it through the dispatcher.
+type Metrics struct {
+	// Events counts the events players send, when it's given to
+	// CountEvents
+	Events *EventMetrics
+
+	connectedPlayers *Gauge
+	queuedPlayers    *Gauge
+	activeMatches    *Gauge
+	activeGames      *Gauge
+	errors           *Counter
+	gameDuration     *Histogram
+	turnDuration     *Histogram
+	dispatchLatency  *Histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Events: NewEventMetrics(),
+
+		connectedPlayers: NewGauge("wingscam_connected_players", "Players connected to the server.", ""),
+		queuedPlayers:    NewGauge("wingscam_queued_players", "Players waiting for a match, by mode.", "mode"),
+		activeMatches:    NewGauge("wingscam_active_matches", "Matches waiting for their players to confirm.", ""),
+		activeGames:      NewGauge("wingscam_active_games", "Games being played.", ""),
+		errors:           NewCounter("wingscam_errors_total", "Errors sent to players, by code.", "code"),
+		gameDuration: NewHistogram("wingscam_game_duration_seconds", "How long finished games lasted, by mode.", "mode",
+			[]float64{30, 60, 120, 300, 600, 900, 1200, 1800, 3600}),
+		turnDuration: NewHistogram("wingscam_turn_duration_seconds", "How long turns lasted, by mode.", "mode",
+			[]float64{1, 5, 10, 20, 30, 45, 60, 90}),
+		dispatchLatency: NewHistogram("wingscam_dispatch_latency_seconds", "How long events took from being delivered to being handled, by type.", "type",
+			[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}),
+	}
+}
+
+// ServeHTTP writes the metrics for a scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	out := &countingWriter{w: bufio.NewWriter(w)}
+
+	m.connectedPlayers.write(out)
+	m.queuedPlayers.write(out)
+	m.activeMatches.write(out)
+	m.activeGames.write(out)
+	m.writeEvents(out)
+	m.errors.write(out)
+	m.gameDuration.write(out)
+	m.turnDuration.write(out)
+	m.dispatchLatency.write(out)
+
+	if out.err == nil {
+		out.err = out.w.Flush()
+	}
+	return out.n, out.err
+}
+
+// writeEvents writes what Events counted as two counters, by type.
+func (m *Metrics) writeEvents(out *countingWriter) {
+	received := NewCounter("wingscam_events_received_total", "Events players sent, by type.", "type")
+	rejected := NewCounter("wingscam_events_rejected_total", "Events players sent that were rejected, by type.", "type")
+
+	for kind, count := range m.Events.Counts() {
+		received.Add(string(kind), float64(count.Received))
+		rejected.Add(string(kind), float64(count.Rejected))
+	}
+
+	received.write(out)
+	rejected.write(out)
+}
+
+func (m *Metrics) observeError(reason string) {
+	m.errors.Inc(errorCode(reason))
+}
+
+func (m *Metrics) observeGame(result GameResult) {
+	m.gameDuration.Observe(result.Mode.Name, result.Duration.Seconds())
+}
+
+func (m *Metrics) observeTurn(mode GameMode, duration time.Duration) {
+	m.turnDuration.Observe(mode.Name, duration.Seconds())
+}
+
+func (m *Metrics) observeDispatch(kind EventType, latency time.Duration) {
+	m.dispatchLatency.Observe(string(kind), latency.Seconds())
+}
+
+// codedErrors are the errors whose codes are their names rather than
+// their reasons, since the reasons carry details that vary.
+var codedErrors = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidPayload, "invalid_payload"},
+	{ErrUnknownEvent, "unknown_event"},
+	{ErrNotAuthenticated, "not_authenticated"},
+	{ErrRateLimited, "rate_limited"},
+	{ErrMuted, "muted"},
+	{ErrAbusiveRate, "abusive_rate"},
+	{ErrInvalidToken, "invalid_token"},
+	{ErrExpiredToken, "expired_token"},
+	{ErrShuttingDown, "shutting_down"},
+	{ErrUnhandled, "unhandled"},
+}
+
+// errorCode sorts the reason players were given for an error into a
+// code, so errors are counted by what went wrong rather than by every
+// detail of it. Reasons that aren't one of codedErrors are their own
+// code, in snake case up to the first digit or colon.
+func errorCode(reason string) string {
+	for _, coded := range codedErrors {
+		if strings.HasPrefix(reason, coded.err.Error()) {
+			return coded.code
+		}
+	}
+
+	var code strings.Builder
+	underscore := false
+	for _, r := range reason {
+		if r == ':' || unicode.IsDigit(r) {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			underscore = code.Len() > 0
+			continue
+		}
+		if underscore {
+			code.WriteRune('_')
+			underscore = false
+		}
+		code.WriteRune(unicode.ToLower(r))
+	}
+
+	if code.Len() == 0 {
+		return "unknown"
+	}
+	return code.String()
+}
+
+// family is what a metric's series have in common. Each metric has at
+// most one label, and a series for each value it's been given, the
+// empty value for metrics without a label.
+type family struct {
+	name  string
+	help  string
+	kind  string
+	label string
+}
+
+func (f family) header(out *countingWriter) {
+	fmt.Fprintf(out, "# HELP %v %v\n", f.name, f.help)
+	fmt.Fprintf(out, "# TYPE %v %v\n", f.name, f.kind)
+}
+
+// labels writes the braces after a series' name, with its label and any
+// extra pairs, or nothing if there are none.
+func (f family) labels(value string, extra ...string) string {
+	pairs := make([]string, 0, 1+len(extra)/2)
+	if f.label != "" {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, f.label, labelEscaper.Replace(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// scalars is the series of a counter or gauge, by label value.
+type scalars struct {
+	family
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (v *scalars) add(label string, delta float64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[label] += delta
+}
+
+func (v *scalars) write(out *countingWriter) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.header(out)
+
+	// metrics without a label always have their one series
+	if v.label == "" {
+		fmt.Fprintf(out, "%v %v\n", v.name, formatValue(v.values[""]))
+		return
+	}
+
+	labels := make([]string, 0, len(v.values))
+	for label := range v.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		fmt.Fprintf(out, "%v%v %v\n", v.name, v.labels(label), formatValue(v.values[label]))
+	}
+}
+
+// Counter only goes up.
+type Counter struct {
+	scalars
+}
+
+func NewCounter(name, help, label string) *Counter {
+	return &Counter{scalars{
+		family: family{name: name, help: help, kind: "counter", label: label},
+		values: make(map[string]float64),
+	}}
+}
+
+func (c *Counter) Inc(label string) {
+	c.add(label, 1)
+}
+
+// Add adds delta, which mustn't be negative.
+func (c *Counter) Add(label string, delta float64) {
+	c.add(label, delta)
+}
+
+// Gauge goes up and down.
+type Gauge struct {
+	scalars
+}
+
+func NewGauge(name, help, label string) *Gauge {
+	return &Gauge{scalars{
+		family: family{name: name, help: help, kind: "gauge", label: label},
+		values: make(map[string]float64),
+	}}
+}
+
+func (g *Gauge) Set(label string, value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[label] = value
+}
+
+func (g *Gauge) Add(label string, delta float64) {
+	g.add(label, delta)
+}
+
+// Histogram counts observations into buckets, each bucket counting
+// those up to its bound.
+type Histogram struct {
+	family
+	bounds []float64
+
+	mutex  sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(name, help, label string, bounds []float64) *Histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+
+	return &Histogram{
+		family: family{name: name, help: help, kind: "histogram", label: label},
+		bounds: bounds,
+		series: make(map[string]*histogramSeries),
+	}
+}
+
+func (h *Histogram) Observe(label string, value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	series, ok := h.series[label]
+	if !ok {
+		series = &histogramSeries{buckets: make([]uint64, len(h.bounds))}
+		h.series[label] = series
+	}
+
+	for idx, bound := range h.bounds {
+		if value <= bound {
+			series.buckets[idx]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *Histogram) write(out *countingWriter) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.header(out)
+
+	labels := make([]string, 0, len(h.series))
+	for label := range h.series {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		series := h.series[label]
+
+		for idx, bound := range h.bounds {
+			fmt.Fprintf(out, "%v_bucket%v %v\n", h.name, h.labels(label, "le", formatValue(bound)), series.buckets[idx])
+		}
+		fmt.Fprintf(out, "%v_bucket%v %v\n", h.name, h.labels(label, "le", "+Inf"), series.count)
+		fmt.Fprintf(out, "%v_sum%v %v\n", h.name, h.labels(label), formatValue(series.sum))
+		fmt.Fprintf(out, "%v_count%v %v\n", h.name, h.labels(label), series.count)
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// countingWriter keeps how much was written, and the first error
+// writing it, so WriteTo can report them.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awaitMetric waits for a line of what's written to show up, since
+// metrics are reported after players hear back.
+func awaitMetric(t *testing.T, scrape func() string, line string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		text := scrape()
+		for _, got := range strings.Split(text, "\n") {
+			if got == line {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %q among the metrics, got\n%v", line, text)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func written(metrics *Metrics) func() string {
+	return func() string {
+		var buffer bytes.Buffer
+		metrics.WriteTo(&buffer)
+		return buffer.String()
+	}
+}
+
+func TestWritesPrometheusText(t *testing.T) {
+	counter := NewCounter("requests_total", "Requests, by path.", "path")
+	counter.Inc(`/a"b`)
+	counter.Add("/", 2)
+
+	gauge := NewGauge("temperature", "How warm it is.", "")
+	gauge.Set("", 21.5)
+	gauge.Add("", -1)
+
+	histogram := NewHistogram("latency_seconds", "How long it took.", "", []float64{1, 0.5})
+	histogram.Observe("", 0.25)
+	histogram.Observe("", 0.75)
+	histogram.Observe("", 3)
+
+	var buffer bytes.Buffer
+	out := &countingWriter{w: bufio.NewWriter(&buffer)}
+	counter.write(out)
+	gauge.write(out)
+	histogram.write(out)
+	out.w.Flush()
+
+	expected := `# HELP requests_total Requests, by path.
+# TYPE requests_total counter
+requests_total{path="/"} 2
+requests_total{path="/a\"b"} 1
+# HELP temperature How warm it is.
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds How long it took.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 4
+latency_seconds_count 3
+`
+	if buffer.String() != expected {
+		t.Errorf("Expected\n%v\ngot\n%v", expected, buffer.String())
+	}
+	if out.n != int64(buffer.Len()) {
+		t.Errorf("Expected %v bytes to be counted, got %v", buffer.Len(), out.n)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	cases := map[string]string{
+		ErrNotAuthenticated.Error():                                     "not_authenticated",
+		(&PayloadError{Event: QueueUp, Reason: "is malformed"}).Error(): "invalid_payload",
+		"Not enough mana":           "not_enough_mana",
+		"Server is busy, try again": "server_is_busy_try_again",
+		"Deck must have 30 cards":   "deck_must_have",
+		"":                          "unknown",
+	}
+
+	for reason, expected := range cases {
+		if code := errorCode(reason); code != expected {
+			t.Errorf("Expected %q to be %v, got %v", reason, expected, code)
+		}
+	}
+}
+
+func TestServesMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	dispatcher := NewDispatcherWithMetrics(metrics, CountEvents(metrics.Events))
+	dispatcher.Register <- NewQueueManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+
+	client.QueueUp(CasualMode.Name)
+	awaitResponse(t, client, Error)
+
+	client.Login(testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+	client.QueueUp(CasualMode.Name)
+	awaitResponse(t, client, WaitForMatch)
+
+	scrape := func() string {
+		response, err := http.Get("http://0.0.0.0:8080/metrics")
+		if err != nil {
+			t.Fatalf("Could not scrape metrics: %v", err)
+		}
+		defer response.Body.Close()
+
+		if kind := response.Header.Get("Content-Type"); !strings.HasPrefix(kind, "text/plain") {
+			t.Errorf("Expected plain text, got %v", kind)
+		}
+		body, _ := io.ReadAll(response.Body)
+		return string(body)
+	}
+
+	awaitMetric(t, scrape, "wingscam_connected_players 1")
+	awaitMetric(t, scrape, `wingscam_queued_players{mode="casual"} 1`)
+	awaitMetric(t, scrape, `wingscam_events_received_total{type="queue_up"} 1`)
+	awaitMetric(t, scrape, `wingscam_errors_total{code="not_authenticated"} 1`)
+	awaitMetric(t, scrape, `wingscam_dispatch_latency_seconds_count{type="queue_up"} 1`)
+
+	client.Close()
+	awaitMetric(t, scrape, "wingscam_connected_players 0")
+	awaitMetric(t, scrape, `wingscam_queued_players{mode="casual"} 0`)
+	awaitMetric(t, scrape, `wingscam_dispatch_latency_seconds_count{type="player_disconnected"} 1`)
+}
+
+// awaitType reads what the player's sent until a response of the given
+// type, forwarded from a goroutine draining everything.
+func awaitType(t *testing.T, responses <-chan Response, kind ResponseType) Response {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case response := <-responses:
+			if response.Type == kind {
+				return response
+			}
+		case <-timeout:
+			t.Fatalf("Expected %v", kind)
+		}
+	}
+}
+
+func drain(player *Player) <-chan Response {
+	responses := make(chan Response, 64)
+	go func() {
+		for {
+			select {
+			case response := <-player.Outgoing:
+				responses <- response
+			case <-player.Done():
+				return
+			}
+		}
+	}()
+	return responses
+}
+
+func TestReportsTurnsAndGames(t *testing.T) {
+	clock := newTestClock()
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewGameManagerWithClock(clock)
+
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+	defer p1.Close()
+	defer p2.Close()
+	first, second := drain(p1), drain(p2)
+
+	dispatcher.Emit(Event{
+		Type:    StartGame,
+		Payload: MatchPayload{Players: []*Player{p1, p2}, Mode: CasualMode},
+	})
+
+	hand := awaitType(t, first, StartingHand).Payload.(StartingHandPayload)
+	scrape := written(dispatcher.Metrics())
+	awaitMetric(t, scrape, "wingscam_active_games 1")
+
+	// nobody chooses their starting hand, so turns begin once the
+	// mulligan timer runs out
+	endTurnInTime(clock, DefaultGameConfig.Mulligan)
+	awaitType(t, first, StartTurn)
+
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	dispatcher.Dispatch <- Event{Type: EndTurn, Player: p1, Payload: hand.GameId.String()}
+	awaitType(t, second, StartTurn)
+
+	dispatcher.Dispatch <- Event{Type: Concede, Player: p2, Payload: hand.GameId.String()}
+	awaitType(t, first, GameOver)
+
+	awaitMetric(t, scrape, `wingscam_turn_duration_seconds_count{mode="casual"} 1`)
+	awaitMetric(t, scrape, `wingscam_turn_duration_seconds_sum{mode="casual"} 10`)
+	awaitMetric(t, scrape, `wingscam_game_duration_seconds_count{mode="casual"} 1`)
+	awaitMetric(t, scrape, `wingscam_game_duration_seconds_sum{mode="casual"} 40`)
+	awaitMetric(t, scrape, "wingscam_active_games 0")
+}
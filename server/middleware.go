@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+var (
+	ErrNotAuthenticated = errors.New("not authenticated")
+	ErrRateLimited      = errors.New("too many events, slow down")
+	ErrUnknownEvent     = errors.New("unknown event")
+	ErrInvalidPayload   = errors.New("invalid payload")
+)
+
+// Next hands an event on to the rest of a chain, returning why it was
+// rejected if it was.
+type Next func(event Event) error
+
+// Middleware wraps the rest of a chain. It rejects an event by returning
+// an error instead of passing the event on to next.
+type Middleware func(next Next) Next
+
+// Chain puts middlewares in front of last, the first of them seeing
+// events first.
+func Chain(last Next, middlewares ...Middleware) Next {
+	next := last
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next = middlewares[i](next)
+	}
+	return next
+}
+
+// reject tells the player an event came from why it won't be handled.
+func reject(event Event, err error) {
+	if event.Player == nil {
+		log.Printf("Rejected %v event: %v\n", event.Type, err)
+		return
+	}
+
+	go event.Fail(err.Error())
+}
+
+// LogEvents logs every event along with the player it came from, and
+// why it was rejected if it was.
+func LogEvents() Middleware {
+	return func(next Next) Next {
+		return func(event Event) error {
+			from := "server"
+			if event.Player != nil {
+				from = event.Player.Id
+			}
+
+			err := next(event)
+			if err != nil {
+				log.Printf("%v from %v rejected: %v\n", event.Type, from, err)
+			} else {
+				log.Printf("%v from %v\n", event.Type, from)
+			}
+			return err
+		}
+	}
+}
+
+// RequireAuthentication rejects events from players who haven't logged
+// in yet.
+func RequireAuthentication() Middleware {
+	return func(next Next) Next {
+		return func(event Event) error {
+			if event.Player != nil && !event.Player.Authenticated() {
+				return ErrNotAuthenticated
+			}
+			return next(event)
+		}
+	}
+}
+
+// Validate rejects events players can't send, and those whose payload
+// doesn't fit the event. What comes after gets the payload decoded into
+// its type in eventSchemas.
+func Validate() Middleware {
+	return func(next Next) Next {
+		return func(event Event) error {
+			payload, err := decodeEventPayload(event.Type, event.Payload)
+			if err != nil {
+				return err
+			}
+
+			event.Payload = payload
+			return next(event)
+		}
+	}
+}
+
+// EventCount is how many events of a type went through a chain, and how
+// many of those were rejected.
+type EventCount struct {
+	Received int
+	Rejected int
+}
+
+// EventMetrics counts the events going through chains.
+type EventMetrics struct {
+	mutex  sync.Mutex
+	counts map[EventType]*EventCount
+}
+
+func NewEventMetrics() *EventMetrics {
+	return &EventMetrics{
+		counts: make(map[EventType]*EventCount),
+	}
+}
+
+// Counts returns a copy of the counts so far, by event type.
+func (m *EventMetrics) Counts() map[EventType]EventCount {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counts := make(map[EventType]EventCount, len(m.counts))
+	for kind, count := range m.counts {
+		counts[kind] = *count
+	}
+	return counts
+}
+
+func (m *EventMetrics) observe(kind EventType, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count, ok := m.counts[kind]
+	if !ok {
+		count = &EventCount{}
+		m.counts[kind] = count
+	}
+
+	count.Received++
+	if err != nil {
+		count.Rejected++
+	}
+}
+
+// CountEvents counts events into metrics. It only sees what's rejected
+// after it, so it's best put first.
+func CountEvents(metrics *EventMetrics) Middleware {
+	return func(next Next) Next {
+		return func(event Event) error {
+			err := next(event)
+			metrics.observe(event.Type, err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func passed(event Event) error {
+	return nil
+}
+
+func TestChainRunsMiddlewaresInOrder(t *testing.T) {
+	order := make([]string, 0)
+
+	record := func(name string) Middleware {
+		return func(next Next) Next {
+			return func(event Event) error {
+				order = append(order, name)
+				return next(event)
+			}
+		}
+	}
+
+	chain := Chain(func(event Event) error {
+		order = append(order, "last")
+		return nil
+	}, record("first"), record("second"))
+
+	chain(Event{Type: QueueUp})
+
+	expected := []string{"first", "second", "last"}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, order)
+	}
+	for idx := range expected {
+		if order[idx] != expected[idx] {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestDispatcherRejectsEventsWithError(t *testing.T) {
+	player := NewTestPlayer()
+	noQueueing := errors.New("no queueing")
+
+	dispatcher := NewDispatcher(func(next Next) Next {
+		return func(event Event) error {
+			if event.Type == QueueUp {
+				return noQueueing
+			}
+			return next(event)
+		}
+	})
+	handler := &SubscribedHandler{
+		Events:        make(chan Event, 2),
+		subscriptions: subscribe("", QueueUp, Dequeue),
+	}
+
+	dispatcher.Register <- handler
+
+	dispatcher.Dispatch <- Event{Type: QueueUp, Player: player}
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error || response.Payload != noQueueing.Error() {
+			t.Errorf("Expected %v, got %v: %v", Error, response.Type, response.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected player to be told the event was rejected")
+	}
+
+	dispatcher.Dispatch <- Event{Type: Dequeue, Player: player}
+
+	select {
+	case event := <-handler.Events:
+		if event.Type != Dequeue {
+			t.Errorf("Expected only %v to get through, got %v", Dequeue, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected event to get through")
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	chain := Chain(passed, RequireAuthentication())
+
+	anonymous := NewTestPlayer()
+	if err := chain(Event{Type: QueueUp, Player: anonymous}); err != ErrNotAuthenticated {
+		t.Errorf("Expected %v, got %v", ErrNotAuthenticated, err)
+	}
+
+	player := NewTestPlayer()
+	player.Identify(Identity{Id: "player"})
+	if err := chain(Event{Type: QueueUp, Player: player}); err != nil {
+		t.Errorf("Expected event from logged in player to pass, got %v", err)
+	}
+
+	if err := chain(Event{Type: GameFinished}); err != nil {
+		t.Errorf("Expected event from the server to pass, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	chain := Chain(passed, Validate())
+
+	cases := []struct {
+		event Event
+		err   error
+	}{
+		{Event{Type: QueueUp, Payload: map[string]interface{}{"Mode": "casual"}}, nil},
+		{Event{Type: QueueUp, Payload: QueueUpPayload{Mode: "casual"}}, nil},
+		{Event{Type: QueueUp}, nil},
+		{Event{Type: Dequeue, Payload: "anything"}, nil},
+		{Event{Type: EndTurn, Payload: uuid.NewString()}, nil},
+		{Event{Type: EndTurn, Payload: "game"}, ErrInvalidPayload},
+		{Event{Type: GetMatchHistory, Payload: map[string]interface{}{"Limit": 5.0}}, nil},
+		{Event{Type: EndTurn}, ErrInvalidPayload},
+		{Event{Type: EndTurn, Payload: 42.0}, ErrInvalidPayload},
+		{Event{Type: PlayCard, Payload: "card"}, ErrInvalidPayload},
+		{Event{Type: PlayCard, Payload: map[string]interface{}{"Card": 1.0}}, ErrInvalidPayload},
+		{Event{Type: Attack, Payload: map[string]interface{}{"Weapon": "axe"}}, ErrInvalidPayload},
+		{Event{Type: "dance"}, ErrUnknownEvent},
+		{Event{Type: StartGame, Payload: map[string]interface{}{}}, ErrUnknownEvent},
+	}
+
+	for _, c := range cases {
+		err := chain(c.event)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Expected %v for %v with %v, got %v", c.err, c.event.Type, c.event.Payload, err)
+		}
+	}
+}
+
+func TestCountEvents(t *testing.T) {
+	metrics := NewEventMetrics()
+	chain := Chain(passed, CountEvents(metrics), RequireAuthentication())
+
+	player := NewTestPlayer()
+	chain(Event{Type: QueueUp, Player: player})
+
+	player.Identify(Identity{Id: "player"})
+	chain(Event{Type: QueueUp, Player: player})
+	chain(Event{Type: Dequeue, Player: player})
+
+	counts := metrics.Counts()
+
+	if counts[QueueUp] != (EventCount{Received: 2, Rejected: 1}) {
+		t.Errorf("Expected 2 %v received and 1 rejected, got %+v", QueueUp, counts[QueueUp])
+	}
+	if counts[Dequeue] != (EventCount{Received: 1}) {
+		t.Errorf("Expected 1 %v received, got %+v", Dequeue, counts[Dequeue])
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import "time"
+
+type Rules struct {
+	DeckSize       int
+	StartingHealth int
+	StartingHand   int
+	TurnDuration   time.Duration
+	// ReconnectGrace is how long players who disconnect have to come
+	// back before they forfeit the game.
+	ReconnectGrace time.Duration
+	// MaxMana and BoardSize cap the mana players build up and the
+	// minions they have out. Zero leaves the default caps.
+	MaxMana   int
+	BoardSize int
+}
+
+var DefaultRules = Rules{
+	DeckSize:       60,
+	StartingHealth: 30,
+	StartingHand:   3,
+	TurnDuration:   75 * time.Second,
+	ReconnectGrace: 30 * time.Second,
+	MaxMana:        DefaultMaxMana,
+	BoardSize:      MaxBoardSize,
+}
+
+// GameMode is a named queue with its own rules. Only ranked modes
+// change the players' rating. Players queued in a mode against bots are
+// matched with one right away, and in other modes a bot takes the place
+// of the opponent once someone has waited longer than Backfill.
+type GameMode struct {
+	Name        string
+	Ranked      bool
+	AgainstBots bool
+	Backfill    time.Duration
+	Rules       Rules
+}
+
+var (
+	CasualMode = GameMode{Name: "casual", Backfill: time.Minute, Rules: DefaultRules}
+	RankedMode = GameMode{Name: "ranked", Ranked: true, Rules: DefaultRules}
+	AIMode     = GameMode{Name: "ai", AgainstBots: true, Rules: DefaultRules}
+)
+
+var DefaultModes = []GameMode{CasualMode, RankedMode, AIMode}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// eventSchemas holds the payload of each event players can send, as a
+// zero value of its type. Events without a payload have nil. Fields of
+// struct payloads are checked as their payload tag says:
+//
+//	required    the field can't be left out or empty
+//	uuid        the field, or each of its elements, has to be a uuid
+//	            if it's there at all
+var eventSchemas = map[EventType]interface{}{
+	Login:            "",
+	QueueUp:          QueueUpPayload{},
+	Dequeue:          nil,
+	MatchConfirmed:   uuid.UUID{},
+	MatchDeclined:    uuid.UUID{},
+	CardsDiscarded:   CardsDiscardedPayload{},
+	EndTurn:          uuid.UUID{},
+	PlayCard:         PlayCardPayload{},
+	Attack:           AttackPayload{},
+	AttackPlayer:     AttackPayload{},
+	Concede:          uuid.UUID{},
+	SaveDeck:         DeckList{},
+	GetDecks:         nil,
+	GetMatchHistory:  MatchHistoryPayload{},
+	CreateLobby:      CreateLobbyPayload{},
+	JoinLobby:        JoinLobbyPayload{},
+	LeaveLobby:       nil,
+	SelectDeck:       SelectDeckPayload{},
+	LobbyReady:       nil,
+	ChallengePlayer:  ChallengePayload{},
+	AcceptChallenge:  ChallengeAnswerPayload{},
+	DeclineChallenge: ChallengeAnswerPayload{},
+	GetReplay:        uuid.UUID{},
+}
+
+// PayloadError is why the payload of an event was rejected. Field is
+// empty when it's the payload as a whole that's wrong.
+type PayloadError struct {
+	Event  EventType
+	Field  string
+	Reason string
+}
+
+func (e *PayloadError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%v for %v: %v", ErrInvalidPayload, e.Event, e.Reason)
+	}
+	return fmt.Sprintf("%v for %v: %v %v", ErrInvalidPayload, e.Event, e.Field, e.Reason)
+}
+
+// Is has payload errors match ErrInvalidPayload.
+func (e *PayloadError) Is(target error) bool {
+	return target == ErrInvalidPayload
+}
+
+// Decode decodes the payload of the event into target, which points to
+// the event's type of payload in eventSchemas, and checks it.
+func (e Event) Decode(target interface{}) error {
+	payload, err := decodeEventPayload(e.Type, e.Payload)
+	if err != nil {
+		return err
+	}
+	return e.assign(payload, target)
+}
+
+// peek decodes the payload like Decode, without checking its fields, for
+// when only some of them are needed.
+func (e Event) peek(target interface{}) error {
+	payload, err := convertPayload(e.Type, e.Payload)
+	if err != nil {
+		return err
+	}
+	if payload.IsValid() {
+		return e.assign(payload.Interface(), target)
+	}
+	return e.assign(nil, target)
+}
+
+func (e Event) assign(payload, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("can't decode %v payload into %T", e.Type, target)
+	}
+	value = value.Elem()
+
+	if payload == nil {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+	if reflect.TypeOf(payload) != value.Type() {
+		return fmt.Errorf("can't decode %v payload into %T", e.Type, target)
+	}
+
+	value.Set(reflect.ValueOf(payload))
+	return nil
+}
+
+// decodeEventPayload turns the payload of an event into its type in
+// eventSchemas and checks it. Payloads can be raw JSON, as they come
+// from clients, already of the right type, or anything that marshals
+// into JSON of the right shape.
+func decodeEventPayload(kind EventType, payload interface{}) (interface{}, error) {
+	value, err := convertPayload(kind, payload)
+	if err != nil || !value.IsValid() {
+		return nil, err
+	}
+
+	if err := checkPayload(kind, value); err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// convertPayload turns the payload into its type in eventSchemas, or an
+// invalid value for events without one.
+func convertPayload(kind EventType, payload interface{}) (reflect.Value, error) {
+	schema, ok := eventSchemas[kind]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("%w %q", ErrUnknownEvent, kind)
+	}
+	if schema == nil {
+		return reflect.Value{}, nil
+	}
+
+	value := reflect.New(reflect.TypeOf(schema))
+
+	switch raw := payload.(type) {
+	case nil:
+		// left empty, for its fields to be checked
+	case json.RawMessage:
+		if err := unmarshalPayload(kind, raw, value.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	default:
+		if reflect.TypeOf(payload) == value.Elem().Type() {
+			value.Elem().Set(reflect.ValueOf(payload))
+			break
+		}
+
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return reflect.Value{}, &PayloadError{Event: kind, Reason: "can't be read"}
+		}
+		if err := unmarshalPayload(kind, data, value.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+
+	return value.Elem(), nil
+}
+
+func unmarshalPayload(kind EventType, data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(target)
+	if err == nil {
+		return nil
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return &PayloadError{Event: kind, Field: typeError.Field, Reason: "must be a " + typeName(typeError.Type)}
+	}
+
+	// the json package has no type for these
+	if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+		return &PayloadError{Event: kind, Field: strings.Trim(field, `"`), Reason: "is not expected"}
+	}
+
+	if _, ok := target.(*uuid.UUID); ok {
+		return &PayloadError{Event: kind, Reason: "must be a uuid"}
+	}
+	return &PayloadError{Event: kind, Reason: "is malformed"}
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t == reflect.TypeOf(uuid.UUID{}) {
+			return "uuid"
+		}
+		return "list"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return t.Kind().String()
+}
+
+// checkPayload checks the fields of a struct payload against their tags.
+// Any other payload is required as a whole.
+func checkPayload(kind EventType, payload reflect.Value) error {
+	if payload.Kind() != reflect.Struct {
+		if payload.IsZero() {
+			return &PayloadError{Event: kind, Reason: "is missing"}
+		}
+		return nil
+	}
+
+	for idx := 0; idx < payload.NumField(); idx++ {
+		field := payload.Type().Field(idx)
+		value := payload.Field(idx)
+
+		for _, rule := range strings.Split(field.Tag.Get("payload"), ",") {
+			switch rule {
+			case "required":
+				if value.IsZero() || (value.Kind() == reflect.Slice && value.Len() == 0) {
+					return &PayloadError{Event: kind, Field: field.Name, Reason: "is missing"}
+				}
+			case "uuid":
+				if !uuids(value) {
+					return &PayloadError{Event: kind, Field: field.Name, Reason: "must be a uuid"}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// uuids tells whether a string, or each string in a slice, is a uuid.
+// Empty strings are left to the required rule.
+func uuids(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		if value.Len() == 0 {
+			return true
+		}
+		_, err := uuid.Parse(value.String())
+		return err == nil
+	case reflect.Slice:
+		for idx := 0; idx < value.Len(); idx++ {
+			if value.Index(idx).Len() == 0 || !uuids(value.Index(idx)) {
+				return false
+			}
+		}
+	}
+	return true
+}
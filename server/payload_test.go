@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDecodeEventPayload(t *testing.T) {
+	id := uuid.New()
+
+	cases := []struct {
+		kind    EventType
+		payload string
+		err     *PayloadError
+	}{
+		{EndTurn, `"` + id.String() + `"`, nil},
+		{EndTurn, `42`, &PayloadError{Event: EndTurn, Reason: "must be a uuid"}},
+		{EndTurn, `"game"`, &PayloadError{Event: EndTurn, Reason: "must be a uuid"}},
+		{EndTurn, `null`, &PayloadError{Event: EndTurn, Reason: "is missing"}},
+		{MatchConfirmed, `{"Id": "match"}`, &PayloadError{Event: MatchConfirmed, Reason: "must be a uuid"}},
+		{Login, `["token"]`, &PayloadError{Event: Login, Reason: "must be a string"}},
+		{QueueUp, `{}`, nil},
+		{QueueUp, `{"Mode": 1}`, &PayloadError{Event: QueueUp, Field: "Mode", Reason: "must be a string"}},
+		{QueueUp, `{"Mode": "casual", "Cheat": true}`, &PayloadError{Event: QueueUp, Field: "Cheat", Reason: "is not expected"}},
+		{QueueUp, `{"Mode": `, &PayloadError{Event: QueueUp, Reason: "is malformed"}},
+		{PlayCard, `{"GameId": "` + id.String() + `"}`, &PayloadError{Event: PlayCard, Field: "Card", Reason: "is missing"}},
+		{PlayCard, `{"GameId": "game", "Card": "` + id.String() + `"}`, &PayloadError{Event: PlayCard, Field: "GameId", Reason: "must be a uuid"}},
+		{CardsDiscarded, `{"GameId": "` + id.String() + `", "Cards": ["card"]}`, &PayloadError{Event: CardsDiscarded, Field: "Cards", Reason: "must be a uuid"}},
+		{CardsDiscarded, `{"GameId": "` + id.String() + `", "Cards": []}`, nil},
+		{AttackPlayer, `{"GameId": "` + id.String() + `", "Attacker": "` + id.String() + `"}`, nil},
+		{AttackPlayer, `{"GameId": "` + id.String() + `", "Attacker": {}}`, &PayloadError{Event: AttackPlayer, Field: "Attacker", Reason: "must be a string"}},
+		{GetMatchHistory, `{"Limit": "all"}`, &PayloadError{Event: GetMatchHistory, Field: "Limit", Reason: "must be a number"}},
+		{JoinLobby, `{"Code": ""}`, &PayloadError{Event: JoinLobby, Field: "Code", Reason: "is missing"}},
+	}
+
+	for _, c := range cases {
+		_, err := decodeEventPayload(c.kind, json.RawMessage(c.payload))
+
+		if c.err == nil {
+			if err != nil {
+				t.Errorf("Expected %v %v to decode, got %v", c.kind, c.payload, err)
+			}
+			continue
+		}
+
+		var payloadErr *PayloadError
+		if !errors.As(err, &payloadErr) {
+			t.Errorf("Expected a payload error for %v %v, got %v", c.kind, c.payload, err)
+			continue
+		}
+		if *payloadErr != *c.err {
+			t.Errorf("Expected %+v for %v %v, got %+v", c.err, c.kind, c.payload, payloadErr)
+		}
+		if !errors.Is(err, ErrInvalidPayload) {
+			t.Errorf("Expected %v to be %v", err, ErrInvalidPayload)
+		}
+	}
+}
+
+func TestDecodeTakesPayloadsOfAnyShape(t *testing.T) {
+	id := uuid.New()
+	expected := PlayCardPayload{GameId: id.String(), Card: id.String()}
+
+	payloads := []interface{}{
+		expected,
+		map[string]interface{}{"GameId": id.String(), "Card": id.String()},
+		json.RawMessage(`{"GameId": "` + id.String() + `", "Card": "` + id.String() + `"}`),
+	}
+
+	for _, payload := range payloads {
+		var data PlayCardPayload
+		if err := (Event{Type: PlayCard, Payload: payload}).Decode(&data); err != nil {
+			t.Fatalf("Could not decode %T: %v", payload, err)
+		}
+		if data != expected {
+			t.Errorf("Expected %+v from %T, got %+v", expected, payload, data)
+		}
+	}
+
+	var data QueueUpPayload
+	if err := (Event{Type: PlayCard, Payload: expected}).Decode(&data); err == nil {
+		t.Error("Expected decoding into another type of payload to fail")
+	}
+}
+
+func FuzzDecodeEventPayload(f *testing.F) {
+	for idx, kind := range eventTypes {
+		payload, _ := json.Marshal(sampleEvents[kind])
+		f.Add(uint8(idx), payload)
+	}
+	f.Add(uint8(0), []byte(`{"Mode": [`))
+
+	f.Fuzz(func(t *testing.T, idx uint8, payload []byte) {
+		kind := eventTypes[int(idx)%len(eventTypes)]
+
+		decoded, err := decodeEventPayload(kind, json.RawMessage(payload))
+		if err != nil {
+			if !errors.Is(err, ErrInvalidPayload) {
+				t.Errorf("Expected %v for %v %q, got %v", ErrInvalidPayload, kind, payload, err)
+			}
+			return
+		}
+
+		if schema := eventSchemas[kind]; decoded != nil && reflect.TypeOf(decoded) != reflect.TypeOf(schema) {
+			t.Errorf("Expected %v payload to be a %T, got %T", kind, schema, decoded)
+		}
+	})
+}
+
+func FuzzDecodeEvent(f *testing.F) {
+	for _, kind := range eventTypes {
+		for _, codec := range Codecs {
+			if data, err := codec.EncodeEvent(Event{Id: "id", Type: kind, Payload: sampleEvents[kind]}); err == nil {
+				f.Add(data)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, codec := range Codecs {
+			event, err := codec.DecodeEvent(data)
+			if err != nil {
+				continue
+			}
+
+			// whatever decodes, the middlewares and handlers can check
+			// without panicking
+			Validate()(func(Event) error { return nil })(event)
+			event.RoutingKey()
+		}
+	})
+}
+
+// FuzzHandlers sends payloads straight to the handlers that take events
+// from players, the ids of the game and match in them filled in so they
+// get past the first check. Each input gets a game and match of its own,
+// and whatever it is, the game has to go on unless it was a concession.
+func FuzzHandlers(f *testing.F) {
+	for idx, kind := range eventTypes {
+		payload, _ := json.Marshal(sampleEvents[kind])
+		f.Add(uint8(idx), payload)
+	}
+	// the first player, whose turn it is, playing what isn't there
+	seed := func(kind EventType, payload string) {
+		f.Add(uint8(eventTag(kind)-1), []byte(payload))
+	}
+	seed(EndTurn, `"$game"`)
+	seed(PlayCard, `{"GameId": "$game", "Card": 7}`)
+	seed(PlayCard, `{"GameId": "$game", "Card": "$match"}`)
+	seed(Attack, `{"GameId": "$game", "Attacker": "$match", "Target": "$game"}`)
+	seed(AttackPlayer, `{"GameId": "$game", "Attacker": "$match"}`)
+
+	dispatcher := NewDispatcher()
+	shared := []Handler{
+		NewQueueManager(),
+		NewLobbyManager(NewMemoryStore()),
+		NewAccountManager(NewMemoryStore()),
+	}
+
+	f.Fuzz(func(t *testing.T, idx uint8, payload []byte) {
+		kind := eventTypes[int(idx)%len(eventTypes)]
+
+		players := []*Player{NewTestPlayer(), NewTestPlayer()}
+		for _, player := range players {
+			player.Id = uuid.NewString()
+			player.Close()
+		}
+
+		game := NewGame(players, CasualMode)
+		game.StartTurns(time.Minute)
+		match := NewMatch(players, CasualMode, time.Minute)
+		defer match.Stop(context.Background())
+
+		raw := strings.NewReplacer("$game", game.Id.String(), "$match", match.Id.String()).Replace(string(payload))
+		sender := players[int(idx)/len(eventTypes)%len(players)]
+		event := Event{Id: "id", Type: kind, Player: sender, Payload: json.RawMessage(raw)}
+
+		for _, handler := range append([]Handler{game, match}, shared...) {
+			processed := make(chan bool)
+			go func() {
+				handler.Process(event, dispatcher)
+				close(processed)
+			}()
+
+			select {
+			case <-processed:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected %T to handle %v %s", handler, kind, raw)
+			}
+		}
+
+		// the game handles events in order, so if it's still going the
+		// second player concedes it
+		go game.Process(Event{Type: Concede, Player: players[1], Payload: game.Id.String()}, dispatcher)
+
+		select {
+		case result := <-game.Over:
+			if result.Aborted {
+				t.Fatalf("Expected the game to go on after %v %s, it was aborted", kind, raw)
+			}
+			if kind != Concede && result.Loser != players[1] {
+				t.Fatalf("Expected the game to go on after %v %s, it was over", kind, raw)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the game to end once conceded, after %v %s", kind, raw)
+		}
+	})
+}
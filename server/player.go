@@ -1,26 +1,100 @@
 package server
 
 import (
+	"log"
+	"sync"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
+// Heartbeat is how often players are pinged, and how long they have to
+// answer before their connection is taken for dead.
+type Heartbeat struct {
+	// Interval is how long between pings
+	Interval time.Duration
+	// Timeout is how long a player can go without sending anything,
+	// pongs included. It should leave room for a ping or two.
+	Timeout time.Duration
+	// WriteTimeout is how long sending a player anything can take
+	WriteTimeout time.Duration
+}
+
+var DefaultHeartbeat = Heartbeat{
+	Interval:     20 * time.Second,
+	Timeout:      45 * time.Second,
+	WriteTimeout: 10 * time.Second,
+}
+
 type Player struct {
+	Id   string
 	Name string
+	Bot  bool
+	// Version is the protocol version settled on when connecting
+	Version int
 
-	Closing  chan bool
 	Incoming chan Event
 	Outgoing chan Response
 
-	socket *websocket.Conn
+	socket    *websocket.Conn
+	codec     Codec
+	heartbeat Heartbeat
+
+	// done is closed once the player's connection is, so nothing
+	// waits on a player who's gone
+	done    chan bool
+	closing sync.Once
+
+	capabilities []Capability
+
+	// metrics counts the errors the player is sent, nil for players
+	// that didn't connect to the server
+	metrics *Metrics
+
+	mutex  sync.Mutex
+	rating int
+	// where the player's waiting for a game, so they're never in the
+	// queue, a match or a lobby at once
+	waiting waiting
+	// requests is the connection's own until the player logs in, and
+	// then their account's
+	requests *requestLog
+}
+
+const (
+	// rememberedRequests is how many of their latest event ids are kept
+	// for each account.
+	rememberedRequests = 64
+	// rememberedRequestsFor is how long an account's event ids are kept
+	// once it's disconnected, for it to retry them on a new connection.
+	rememberedRequestsFor = 10 * time.Minute
+)
+
+// requestLog is how the latest events given ids turned out, nil while
+// they're being handled, so retries aren't applied twice.
+type requestLog struct {
+	mutex    sync.Mutex
+	outcomes map[string]*Response
+	ids      []string
+}
+
+func newRequestLog() *requestLog {
+	return &requestLog{outcomes: make(map[string]*Response)}
 }
 
 func NewPlayer(socket *websocket.Conn) *Player {
+	return NewPlayerWithHeartbeat(socket, DefaultHeartbeat)
+}
+
+func NewPlayerWithHeartbeat(socket *websocket.Conn, heartbeat Heartbeat) *Player {
 	player := &Player{
-		Closing:  make(chan bool),
 		Incoming: make(chan Event),
 		Outgoing: make(chan Response),
 
-		socket: socket,
+		socket:    socket,
+		codec:     codecFor(socket.Subprotocol()),
+		heartbeat: heartbeat,
+		done:      make(chan bool),
 	}
 
 	go player.Read()
@@ -29,32 +103,259 @@ func NewPlayer(socket *websocket.Conn) *Player {
 	return player
 }
 
+// Identify tells who the player is. It can't be changed once told, so
+// nobody logs in as someone else on the same connection.
+func (p *Player) Identify(identity Identity) error {
+	if p.Authenticated() {
+		return ErrAlreadyLoggedIn
+	}
+
+	p.Id = identity.Id
+	p.Name = identity.Name
+	return nil
+}
+
+func (p *Player) Authenticated() bool {
+	return p.Id != ""
+}
+
+// Supports tells whether capability was settled on when the player
+// connected.
+func (p *Player) Supports(capability Capability) bool {
+	for _, supported := range p.capabilities {
+		if supported == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Player) GetRating() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.rating
+}
+
+func (p *Player) SetRating(rating int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rating = rating
+}
+
+// waiting is where a player can wait for a game.
+type waiting string
+
+const (
+	notWaiting waiting = ""
+	inQueue    waiting = "queue"
+	inMatch    waiting = "match"
+	inLobby    waiting = "lobby"
+)
+
+// reason is what players trying to wait somewhere else are told.
+func (w waiting) reason() string {
+	switch w {
+	case inQueue:
+		return "Already in queue"
+	case inMatch:
+		return "Already in a match"
+	case inLobby:
+		return "Already in a lobby"
+	}
+	return ""
+}
+
+// wait has the player wait in place, unless they're waiting somewhere
+// already, which is returned.
+func (p *Player) wait(place waiting) (waiting, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.waiting != notWaiting {
+		return p.waiting, false
+	}
+
+	p.waiting = place
+	return place, true
+}
+
+// stopWaiting lets the player wait somewhere else, if they were still
+// waiting in place.
+func (p *Player) stopWaiting(place waiting) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.waiting == place {
+		p.waiting = notWaiting
+	}
+}
+
+// moveWaiting has the player wait in to instead, if they were still
+// waiting in from.
+func (p *Player) moveWaiting(from, to waiting) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.waiting == from {
+		p.waiting = to
+	}
+}
+
+// request records that the event with the given id is being handled.
+// Retries of it are refused, along with how it turned out if it's been
+// settled yet.
+func (p *Player) request(id string) (*Response, bool) {
+	return p.requestLog().request(id)
+}
+
+// settle sends the player the outcome of an event and keeps it for
+// retries.
+func (p *Player) settle(response Response) {
+	p.requestLog().settle(response)
+	p.Send(response)
+}
+
+func (p *Player) requestLog() *requestLog {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.requests == nil {
+		p.requests = newRequestLog()
+	}
+	return p.requests
+}
+
+// shareRequests has the player keep their requests in their account's
+// log, which their other connections share.
+func (p *Player) shareRequests(log *requestLog) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.requests = log
+}
+
+func (l *requestLog) request(id string) (*Response, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if outcome, seen := l.outcomes[id]; seen {
+		return outcome, false
+	}
+
+	if len(l.ids) == rememberedRequests {
+		delete(l.outcomes, l.ids[0])
+		l.ids = l.ids[1:]
+	}
+
+	l.outcomes[id] = nil
+	l.ids = append(l.ids, id)
+	return nil, true
+}
+
+func (l *requestLog) settle(response Response) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.outcomes[response.RequestId]; ok {
+		l.outcomes[response.RequestId] = &response
+	}
+}
+
 func (p *Player) Send(response Response) {
-	p.Outgoing <- response
+	if p.metrics != nil && (response.Type == Error || response.Type == Nack) {
+		reason, _ := response.Payload.(string)
+		p.metrics.observeError(reason)
+	}
+
+	select {
+	case p.Outgoing <- response:
+	case <-p.done:
+	}
 }
 
+// Close closes the player's connection, once whatever it's writing is
+// written.
 func (p *Player) Close() {
-	p.Closing <- true
+	p.closing.Do(func() {
+		close(p.done)
+	})
 }
 
+// Done is closed once the player is.
+func (p *Player) Done() <-chan bool {
+	return p.done
+}
+
+// Read passes on what the player sends until the connection is closed,
+// or goes quiet for longer than the heartbeat allows, as it does when
+// the other end is gone without saying so.
 func (p *Player) Read() {
+	defer p.Close()
+
+	p.extendDeadline()
+	p.socket.SetPongHandler(func(string) error {
+		p.extendDeadline()
+		return nil
+	})
+
 	for {
-		var event Event
-		err := p.socket.ReadJSON(&event)
+		_, data, err := p.socket.ReadMessage()
+		if err != nil {
+			return
+		}
+		p.extendDeadline()
+
+		// messages that can't be read are passed on as events without
+		// a type, for the server to answer like any other it can't take
+		event, err := p.codec.DecodeEvent(data)
 		if err != nil {
-			break
+			event = Event{}
+		}
+
+		select {
+		case p.Incoming <- event:
+		case <-p.done:
+			return
 		}
-		p.Incoming <- event
 	}
 }
 
+func (p *Player) extendDeadline() {
+	p.socket.SetReadDeadline(time.Now().Add(p.heartbeat.Timeout))
+}
+
+// Write sends the player responses, and pings in between, until the
+// player is closed or a write fails. Either way it closes the socket,
+// which ends Read as well.
 func (p *Player) Write() {
+	ticker := time.NewTicker(p.heartbeat.Interval)
+	defer ticker.Stop()
+	defer p.socket.Close()
+
 	for {
 		select {
 		case msg := <-p.Outgoing:
-			p.socket.WriteJSON(msg)
-		case <-p.Closing:
-			p.socket.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			data, err := p.codec.EncodeResponse(msg)
+			if err != nil {
+				log.Printf("Could not encode %v for %v: %v\n", msg.Type, p.Id, err)
+				continue
+			}
+
+			p.socket.SetWriteDeadline(time.Now().Add(p.heartbeat.WriteTimeout))
+			if err := p.socket.WriteMessage(p.codec.MessageType(), data); err != nil {
+				p.Close()
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(p.heartbeat.WriteTimeout)
+			if err := p.socket.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				p.Close()
+				return
+			}
+		case <-p.done:
+			deadline := time.Now().Add(p.heartbeat.WriteTimeout)
+			p.socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+			return
 		}
 	}
 }
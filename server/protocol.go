@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the version of the protocol this server speaks.
+// Bump it whenever events or responses change in a way that would trip
+// up clients written against the previous one.
+const ProtocolVersion = PayloadRejectedVersion
+
+// PayloadRejectedVersion is the first version told what was wrong with
+// the payloads it sent, with PayloadRejected.
+const PayloadRejectedVersion = 3
+
+// LegacyProtocolVersion is what clients from before the handshake, that
+// don't announce a version, are taken to speak.
+const LegacyProtocolVersion = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrInvalidHandshake   = errors.New("invalid handshake")
+)
+
+// Capability is an optional feature of the protocol, which clients and
+// server both have to support for it to be used. New features come with
+// a capability of their own, so older clients keep working without them.
+type Capability string
+
+const (
+	// ReconnectCapability is picking a game back up after logging in
+	// again from a new connection
+	ReconnectCapability Capability = "reconnect"
+)
+
+// Capabilities are what this server and Client support. Codecs aren't
+// among them, since they're settled on as the connection's subprotocol.
+var Capabilities = []Capability{ReconnectCapability}
+
+// Handshake is what clients announce when connecting, as the version and
+// capabilities query parameters.
+type Handshake struct {
+	Version      int
+	Capabilities []Capability
+}
+
+// WelcomePayload is the server's side of the handshake: the version and
+// capabilities settled on, and the latest version it speaks so clients
+// can tell they were downgraded.
+type WelcomePayload struct {
+	Version      int
+	Latest       int
+	Capabilities []Capability
+}
+
+// Query adds the handshake to the query of a connection url.
+func (h Handshake) Query(query url.Values) {
+	names := make([]string, len(h.Capabilities))
+	for idx, capability := range h.Capabilities {
+		names[idx] = string(capability)
+	}
+
+	query.Set("version", strconv.Itoa(h.Version))
+	query.Set("capabilities", strings.Join(names, ","))
+}
+
+func requestHandshake(r *http.Request) (Handshake, error) {
+	query := r.URL.Query()
+
+	handshake := Handshake{Version: LegacyProtocolVersion}
+
+	if version := query.Get("version"); version != "" {
+		var err error
+		if handshake.Version, err = strconv.Atoi(version); err != nil {
+			return handshake, ErrInvalidHandshake
+		}
+	}
+
+	for _, name := range strings.Split(query.Get("capabilities"), ",") {
+		if name != "" {
+			handshake.Capabilities = append(handshake.Capabilities, Capability(name))
+		}
+	}
+
+	return handshake, nil
+}
+
+// negotiate settles on the version and capabilities to use with a client.
+// Clients newer than the server are downgraded to its version, those
+// older than oldest are refused.
+func negotiate(handshake Handshake, oldest int) (WelcomePayload, error) {
+	if handshake.Version < oldest {
+		return WelcomePayload{}, ErrUnsupportedVersion
+	}
+
+	welcome := WelcomePayload{
+		Version:      handshake.Version,
+		Latest:       ProtocolVersion,
+		Capabilities: []Capability{},
+	}
+	if welcome.Version > ProtocolVersion {
+		welcome.Version = ProtocolVersion
+	}
+
+	for _, capability := range Capabilities {
+		for _, requested := range handshake.Capabilities {
+			if requested == capability {
+				welcome.Capabilities = append(welcome.Capabilities, capability)
+				break
+			}
+		}
+	}
+
+	return welcome, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		handshake Handshake
+		oldest    int
+		welcome   WelcomePayload
+		err       error
+	}{
+		{
+			Handshake{Version: LegacyProtocolVersion},
+			LegacyProtocolVersion,
+			WelcomePayload{Version: LegacyProtocolVersion, Latest: ProtocolVersion, Capabilities: []Capability{}},
+			nil,
+		},
+		{
+			Handshake{Version: ProtocolVersion, Capabilities: []Capability{"teleport", ReconnectCapability}},
+			LegacyProtocolVersion,
+			WelcomePayload{Version: ProtocolVersion, Latest: ProtocolVersion, Capabilities: []Capability{ReconnectCapability}},
+			nil,
+		},
+		{
+			Handshake{Version: ProtocolVersion + 1, Capabilities: []Capability{ReconnectCapability}},
+			LegacyProtocolVersion,
+			WelcomePayload{Version: ProtocolVersion, Latest: ProtocolVersion, Capabilities: []Capability{ReconnectCapability}},
+			nil,
+		},
+		{
+			Handshake{Version: LegacyProtocolVersion},
+			ProtocolVersion,
+			WelcomePayload{},
+			ErrUnsupportedVersion,
+		},
+	}
+
+	for _, c := range cases {
+		welcome, err := negotiate(c.handshake, c.oldest)
+		if err != c.err {
+			t.Errorf("Expected %v for %+v, got %v", c.err, c.handshake, err)
+		}
+		if err == nil && !reflect.DeepEqual(welcome, c.welcome) {
+			t.Errorf("Expected %+v for %+v, got %+v", c.welcome, c.handshake, welcome)
+		}
+	}
+}
+
+func TestWelcomesWithHandshake(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+
+	welcome := awaitResponse(t, client, Welcome).Payload.(WelcomePayload)
+	if welcome.Version != ProtocolVersion || !reflect.DeepEqual(welcome.Capabilities, Capabilities) {
+		t.Errorf("Expected version %v with %v, got %+v", ProtocolVersion, Capabilities, welcome)
+	}
+
+	// the token is still read alongside the handshake
+	awaitResponse(t, client, LoggedIn)
+}
+
+func TestRefusesUnsupportedVersions(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.OldestVersion = ProtocolVersion
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	_, response, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("Expected legacy clients to be refused")
+	}
+
+	_, response, err = websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/?version=two", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid version to be refused")
+	}
+
+	testClient(t, "0.0.0.0:8080")
+}
+
+func TestClientReportsUnsupportedVersion(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.OldestVersion = ProtocolVersion + 1
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	if _, err := Connect(context.Background(), "0.0.0.0:8080"); err != ErrUnsupportedVersion {
+		t.Errorf("Expected %v, got %v", ErrUnsupportedVersion, err)
+	}
+}
+
+func TestLegacyPlayerIsNotResumed(t *testing.T) {
+	listenForGames(t, 100*time.Millisecond)
+
+	gone := queuedClient(t, "gone")
+	other := queuedClient(t, "other")
+	_, ids := startGame(t, gone, other)
+
+	gone.Close()
+	awaitResponse(t, other, OpponentLeft)
+
+	// a client from before the handshake, that couldn't make sense of
+	// the game it's put back in
+	legacy, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/?token="+testToken("gone"), nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer legacy.Close()
+
+	resumed := make(chan bool, 1)
+	go func() {
+		for {
+			var response Response
+			if err := legacy.ReadJSON(&response); err != nil {
+				return
+			}
+			if response.Type == GameResumed {
+				resumed <- true
+			}
+		}
+	}()
+
+	over := awaitResponse(t, other, GameOver).Payload.(GameOverView)
+	if over.Winner.Id != ids[1] {
+		t.Errorf("Expected player who stayed to win, got %+v", over)
+	}
+
+	select {
+	case <-resumed:
+		t.Error("Expected legacy player not to be put back in the game")
+	default:
+	}
+}
@@ -1,47 +1,66 @@
 package server
 
+import "time"
+
 type node struct {
 	Next   *node
 	Player *Player
+	Rating int
+	Joined time.Time
 }
 
 type Queue struct {
-	head    *node
+	head *node
+	tail *node
+
+	// each queued player points to the node before it, so removing
+	// anyone from the queue doesn't require walking it
 	players map[*Player]*node
+
+	clock Clock
 }
 
 func NewQueue() *Queue {
+	return NewQueueWithClock(RealClock)
+}
+
+func NewQueueWithClock(clock Clock) *Queue {
 	return &Queue{
 		head:    nil,
 		players: make(map[*Player]*node),
+
+		clock: clock,
 	}
 }
 
 func (q *Queue) Queue(player *Player) {
-	node := &node{Player: player}
+	if _, ok := q.players[player]; ok {
+		return
+	}
+
+	node := &node{
+		Player: player,
+		Rating: player.GetRating(),
+		Joined: q.clock.Now(),
+	}
 
 	if q.head == nil {
 		q.head = node
 		q.players[player] = nil
 	} else {
-		cur := q.head
-		for cur.Next != nil {
-			cur = cur.Next
-		}
-		cur.Next = node
-		q.players[player] = cur
+		q.tail.Next = node
+		q.players[player] = q.tail
 	}
+
+	q.tail = node
 }
 
 func (q *Queue) Dequeue() *Player {
 	node := q.head
-	if q.head != nil {
-		q.head = q.head.Next
-	}
 	if node == nil {
 		return nil
 	}
-	delete(q.players, node.Player)
+	q.Remove(node.Player)
 	return node.Player
 }
 
@@ -52,12 +71,19 @@ func (q *Queue) Remove(player *Player) bool {
 		return false
 	}
 
+	var removed *node
 	if prev != nil {
-		prev.Next = prev.Next.Next
-	} else if q.head.Next != nil {
-		q.head = q.head.Next
+		removed = prev.Next
+		prev.Next = removed.Next
+	} else {
+		removed = q.head
+		q.head = removed.Next
+	}
+
+	if removed.Next != nil {
+		q.players[removed.Next.Player] = prev
 	} else {
-		q.head = nil
+		q.tail = prev
 	}
 
 	delete(q.players, player)
@@ -67,3 +93,69 @@ func (q *Queue) Remove(player *Player) bool {
 func (q *Queue) Length() int {
 	return len(q.players)
 }
+
+// Pairs removes from the queue every pair of players that are close
+// enough in rating. Players are considered oldest first and matched with
+// the closest rated player whose difference fits the oldest one's window.
+func (q *Queue) Pairs(window MatchWindow) [][]*Player {
+	now := q.clock.Now()
+	pairs := make([][]*Player, 0)
+	paired := make(map[*node]bool)
+
+	for cur := q.head; cur != nil; cur = cur.Next {
+		if paired[cur] {
+			continue
+		}
+
+		size := window.Size(now.Sub(cur.Joined))
+
+		var best *node
+		for other := cur.Next; other != nil; other = other.Next {
+			// an account is never matched against itself, from
+			// whichever connections it queued up on
+			if paired[other] || (cur.Player.Id != "" && other.Player.Id == cur.Player.Id) {
+				continue
+			}
+
+			diff := abs(cur.Rating - other.Rating)
+			if diff <= size && (best == nil || diff < abs(cur.Rating-best.Rating)) {
+				best = other
+			}
+		}
+
+		if best != nil {
+			paired[cur] = true
+			paired[best] = true
+			pairs = append(pairs, []*Player{cur.Player, best.Player})
+		}
+	}
+
+	for _, pair := range pairs {
+		for _, player := range pair {
+			q.Remove(player)
+		}
+	}
+
+	return pairs
+}
+
+// Overdue lists the players that have been waiting for at least wait.
+func (q *Queue) Overdue(wait time.Duration) []*Player {
+	now := q.clock.Now()
+	overdue := make([]*Player, 0)
+
+	for cur := q.head; cur != nil; cur = cur.Next {
+		if now.Sub(cur.Joined) >= wait {
+			overdue = append(overdue, cur.Player)
+		}
+	}
+
+	return overdue
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -1,66 +1,267 @@
 package server
 
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+type QueueRequest struct {
+	Player     *Player
+	Mode       string
+	Dispatcher *Dispatcher
+	// Event is the one the player queued up with, to answer
+	Event Event
+}
+
 type QueueManager struct {
-	queue *Queue
+	modes      []GameMode
+	queues     map[string]*Queue
+	queued     map[*Player]string
+	window     MatchWindow
+	dispatcher *Dispatcher
+	strategy   func() Strategy
+	// store is where ratings are read from when players queue up, if
+	// anywhere
+	store Store
 
-	Unregister chan *Player
-	Register   chan WithDispatcher
+	Unregister chan Event
+	Register   chan QueueRequest
 	Players    chan []*Player
+
+	done     chan bool
+	stop     chan bool
+	stopping sync.Once
+}
+
+func NewQueueManager(modes ...GameMode) *QueueManager {
+	return NewQueueManagerWithClock(RealClock, modes...)
+}
+
+func NewQueueManagerWithClock(clock Clock, modes ...GameMode) *QueueManager {
+	config := DefaultQueueConfig()
+	if len(modes) > 0 {
+		config.Modes = modes
+	}
+	return NewQueueManagerWithConfig(config, clock)
 }
 
-func NewQueueManager() *QueueManager {
+func NewQueueManagerWithConfig(config QueueConfig, clock Clock) *QueueManager {
+	return NewQueueManagerWithStore(nil, config, clock)
+}
+
+func NewQueueManagerWithStore(store Store, config QueueConfig, clock Clock) *QueueManager {
+	modes := config.Modes
+
 	manager := &QueueManager{
-		queue: NewQueue(),
+		store:  store,
+		modes:  modes,
+		queues: make(map[string]*Queue),
+		queued: make(map[*Player]string),
+		window: config.Window,
+		strategy: func() Strategy {
+			return NewGreedyStrategy()
+		},
 
-		Unregister: make(chan *Player),
-		Register:   make(chan WithDispatcher),
+		Unregister: make(chan Event),
+		Register:   make(chan QueueRequest),
 		Players:    make(chan []*Player),
+
+		done: make(chan bool),
+		stop: make(chan bool),
+	}
+
+	for _, mode := range modes {
+		manager.queues[mode.Name] = NewQueueWithClock(clock)
 	}
 
 	go func() {
+		// waiting players are matched again periodically, since their
+		// rating windows keep widening while no one new joins
+		ticker := clock.NewTicker(config.Rematch)
+		defer ticker.Stop()
+		defer close(manager.done)
+
 		for {
 			select {
-			case player := <-manager.Unregister:
-				manager.queue.Remove(player)
+			case <-manager.stop:
+				for player, mode := range manager.queued {
+					manager.queues[mode].Remove(player)
+					player.stopWaiting(inQueue)
+					player.Send(Response{
+						Type: Dequeued,
+					})
+				}
+				manager.queued = make(map[*Player]string)
+				return
+			case event := <-manager.Unregister:
+				if mode, ok := manager.queued[event.Player]; ok {
+					manager.queues[mode].Remove(event.Player)
+					delete(manager.queued, event.Player)
+					event.Player.stopWaiting(inQueue)
+				}
 
-				player.Send(Response{
+				event.Ack(Response{
 					Type: Dequeued,
 				})
 			case data := <-manager.Register:
-				manager.queue.Queue(data.Player)
+				queue, ok := manager.queues[data.Mode]
 
-				data.Player.Send(Response{
-					Type: WaitForMatch,
-				})
+				if !ok {
+					data.Event.Fail("Unknown game mode")
+					continue
+				}
+
+				// lobbies share where players wait, so nobody's
+				// queued while in one
+				if place, ok := data.Player.wait(inQueue); !ok {
+					data.Event.Fail(place.reason())
+					continue
+				}
 
-				if manager.queue.Length() == 2 {
-					players := make([]*Player, 0)
+				manager.dispatcher = data.Dispatcher
+				manager.queued[data.Player] = data.Mode
+				queue.Queue(data.Player)
 
-					for i := 0; i < 2; i++ {
-						player := manager.queue.Dequeue()
-						players = append(players, player)
-					}
+				data.Event.Ack(Response{
+					Type:    WaitForMatch,
+					Payload: data.Mode,
+				})
 
-					data.Dispatcher.Dispatch <- Event{
-						Type:    CreateMatch,
-						Payload: players,
-					}
-				}
+				manager.match()
+			case <-ticker.C():
+				manager.match()
 			}
+
+			manager.report()
 		}
 	}()
 
 	return manager
 }
 
+func (qm *QueueManager) match() {
+	if qm.dispatcher == nil {
+		return
+	}
+
+	for _, mode := range qm.modes {
+		queue := qm.queues[mode.Name]
+
+		if !mode.AgainstBots {
+			for _, players := range queue.Pairs(qm.window) {
+				qm.createMatch(players, mode)
+			}
+		}
+
+		if !mode.AgainstBots && mode.Backfill == 0 {
+			continue
+		}
+
+		for _, player := range queue.Overdue(mode.Backfill) {
+			queue.Remove(player)
+
+			bot := NewBot(qm.strategy(), qm.dispatcher)
+			bot.Player.SetRating(player.GetRating())
+
+			qm.createMatch([]*Player{player, bot.Player}, mode)
+		}
+	}
+}
+
+// report reports how many players are waiting in each mode's queue.
+func (qm *QueueManager) report() {
+	if qm.dispatcher == nil {
+		return
+	}
+
+	for _, mode := range qm.modes {
+		qm.dispatcher.Metrics().queuedPlayers.Set(mode.Name, float64(qm.queues[mode.Name].Length()))
+	}
+}
+
+func (qm *QueueManager) createMatch(players []*Player, mode GameMode) {
+	for _, player := range players {
+		delete(qm.queued, player)
+		// the match has them until it's confirmed or canceled, so
+		// they can't queue up for another meanwhile
+		player.moveWaiting(inQueue, inMatch)
+	}
+
+	qm.dispatcher.Emit(Event{
+		Type: CreateMatch,
+		Payload: MatchPayload{
+			Players: players,
+			Mode:    mode,
+		},
+	})
+}
+
+func (qm *QueueManager) Subscriptions() []Subscription {
+	return subscribe("", QueueUp, Dequeue, PlayerDisconnected)
+}
+
 func (qm *QueueManager) Process(event Event, dispatcher *Dispatcher) {
 	switch event.Type {
 	case QueueUp:
-		qm.Register <- WithDispatcher{
+		var data QueueUpPayload
+		if err := event.Decode(&data); err != nil {
+			go event.Fail(err.Error())
+			return
+		}
+
+		if data.Mode == "" {
+			data.Mode = CasualMode.Name
+		}
+
+		// players can queue up before their account is loaded, so
+		// they're matched on the rating that's stored rather than
+		// whatever they've been told so far
+		if qm.store != nil {
+			rating, err := qm.rating(event.Player)
+			if err != nil {
+				log.Printf("Could not load rating of %v: %v\n", event.Player.Id, err)
+				go event.Fail("Could not load rating")
+				return
+			}
+			event.Player.SetRating(rating)
+		}
+
+		select {
+		case qm.Register <- QueueRequest{
 			Player:     event.Player,
+			Mode:       data.Mode,
 			Dispatcher: dispatcher,
+			Event:      event,
+		}:
+		case <-qm.done:
 		}
-	case Dequeue:
-		qm.Unregister <- event.Player
+	case Dequeue, PlayerDisconnected:
+		select {
+		case qm.Unregister <- event:
+		case <-qm.done:
+		}
+	}
+}
+
+// rating is the player's stored rating, DefaultRating for those who
+// haven't got an account yet.
+func (qm *QueueManager) rating(player *Player) (int, error) {
+	account, err := qm.store.GetAccount(player.Id)
+	if err == ErrNotFound {
+		return DefaultRating, nil
+	}
+	return account.Rating, err
+}
+
+// Stop takes everyone out of the queue and stops matching players.
+func (qm *QueueManager) Stop(ctx context.Context) {
+	qm.stopping.Do(func() {
+		close(qm.stop)
+	})
+
+	select {
+	case <-qm.done:
+	case <-ctx.Done():
 	}
 }
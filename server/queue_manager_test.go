@@ -3,12 +3,16 @@ package server
 import (
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 func NewTestPlayer() *Player {
 	return &Player{
 		Incoming: make(chan Event),
 		Outgoing: make(chan Response),
+
+		done: make(chan bool),
 	}
 }
 
@@ -66,7 +70,7 @@ func TestOthersRemainInQueue(t *testing.T) {
 		if event.Type != CreateMatch {
 			t.Errorf("Expected %v, got %v", CreateMatch, event.Type)
 		}
-		players := event.Payload.([]*Player)
+		players := event.Payload.(MatchPayload).Players
 		if len(players) != 2 {
 			t.Errorf("Expected 2 players, got %v", len(players))
 		}
@@ -105,7 +109,7 @@ func TestDequeue(t *testing.T) {
 			t.Errorf("Expected %v, got %v", Dequeued, response.Type)
 		}
 
-		length := len(manager.queue.players)
+		length := len(manager.queues[CasualMode.Name].players)
 		if length != 0 {
 			t.Errorf("Expected empty queue, got %v", length)
 		}
@@ -138,7 +142,7 @@ func TestDispatchesCreateMatchEvent(t *testing.T) {
 		if event.Type != CreateMatch {
 			t.Errorf("Expected %v, got %v", CreateMatch, event.Type)
 		}
-		players := event.Payload.([]*Player)
+		players := event.Payload.(MatchPayload).Players
 		if len(players) != 2 {
 			t.Errorf("Expected 2 players, got %v", len(players))
 		}
@@ -146,3 +150,262 @@ func TestDispatchesCreateMatchEvent(t *testing.T) {
 		t.Error("Expected response from server")
 	}
 }
+
+func TestDoesNotPairDistantRatings(t *testing.T) {
+	clock := newTestClock()
+	manager := NewQueueManagerWithClock(clock)
+	dispatcher := NewTestDispatcher()
+
+	p1 := NewTestPlayer()
+	p1.SetRating(1000)
+
+	p2 := NewTestPlayer()
+	p2.SetRating(2000)
+
+	go manager.Process(Event{
+		Type:   QueueUp,
+		Player: p1,
+	}, dispatcher)
+
+	<-p1.Outgoing // wait for match
+
+	go manager.Process(Event{
+		Type:   QueueUp,
+		Player: p2,
+	}, dispatcher)
+
+	<-p2.Outgoing // wait for match
+
+	// nor when they're matched again a moment later
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+
+	select {
+	case <-dispatcher.Dispatch:
+		t.Error("Should not create match")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestQueuesWithStoredRating(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveAccount(Account{Id: "veteran", Rating: 2000})
+
+	manager := NewQueueManagerWithStore(store, DefaultQueueConfig(), newTestClock())
+	dispatcher := NewTestDispatcher()
+
+	// neither player's account has been loaded yet
+	veteran := NewTestPlayer()
+	veteran.Identify(Identity{Id: "veteran"})
+
+	newcomer := NewTestPlayer()
+	newcomer.Identify(Identity{Id: "newcomer"})
+
+	for _, player := range []*Player{veteran, newcomer} {
+		go manager.Process(Event{
+			Type:   QueueUp,
+			Player: player,
+		}, dispatcher)
+
+		<-player.Outgoing // wait for match
+	}
+
+	if veteran.GetRating() != 2000 || newcomer.GetRating() != DefaultRating {
+		t.Errorf("Expected %v and %v, got %v and %v", 2000, DefaultRating, veteran.GetRating(), newcomer.GetRating())
+	}
+
+	select {
+	case <-dispatcher.Dispatch:
+		t.Error("Should not match the veteran with a newcomer")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPairsPlayersOnceWindowsWiden(t *testing.T) {
+	clock := newTestClock()
+	manager := NewQueueManagerWithClock(clock, RankedMode)
+	dispatcher := NewTestDispatcher()
+
+	p1 := NewTestPlayer()
+	p1.SetRating(1000)
+
+	p2 := NewTestPlayer()
+	p2.SetRating(1200)
+
+	for _, player := range []*Player{p1, p2} {
+		go manager.Process(Event{
+			Type:    QueueUp,
+			Player:  player,
+			Payload: QueueUpPayload{Mode: RankedMode.Name},
+		}, dispatcher)
+
+		<-player.Outgoing // wait for match
+	}
+
+	// the ticker rematches them, nobody else joining
+	clock.BlockUntil(1)
+	clock.Advance(15 * time.Second)
+
+	select {
+	case event := <-dispatcher.Dispatch:
+		if event.Type != CreateMatch {
+			t.Errorf("Expected %v, got %v", CreateMatch, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected players to be paired")
+	}
+}
+
+func TestModesHaveSeparateQueues(t *testing.T) {
+	manager := NewQueueManager()
+	dispatcher := NewTestDispatcher()
+
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  p1,
+		Payload: QueueUpPayload{Mode: "ranked"},
+	}, dispatcher)
+
+	<-p1.Outgoing // wait for match
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  p2,
+		Payload: map[string]interface{}{"Mode": "casual"},
+	}, dispatcher)
+
+	<-p2.Outgoing // wait for match
+
+	select {
+	case <-dispatcher.Dispatch:
+		t.Error("Should not match players from different modes")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMatchCarriesModeRules(t *testing.T) {
+	arena := GameMode{
+		Name: "arena",
+		Rules: Rules{
+			DeckSize:       30,
+			StartingHealth: 20,
+			StartingHand:   4,
+			TurnDuration:   time.Minute,
+		},
+	}
+
+	manager := NewQueueManager(arena)
+	dispatcher := NewTestDispatcher()
+
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	for _, player := range []*Player{p1, p2} {
+		go manager.Process(Event{
+			Type:    QueueUp,
+			Player:  player,
+			Payload: QueueUpPayload{Mode: "arena"},
+		}, dispatcher)
+
+		<-player.Outgoing // wait for match
+	}
+
+	select {
+	case event := <-dispatcher.Dispatch:
+		payload := event.Payload.(MatchPayload)
+		if payload.Mode.Name != "arena" {
+			t.Errorf("Expected %v, got %v", "arena", payload.Mode.Name)
+		}
+		if payload.Mode.Rules.DeckSize != 30 {
+			t.Errorf("Expected %v, got %v", 30, payload.Mode.Rules.DeckSize)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected match to be created")
+	}
+}
+
+func TestUnknownMode(t *testing.T) {
+	manager := NewQueueManager()
+	player := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  player,
+		Payload: QueueUpPayload{Mode: "draft"},
+	}, nil)
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
+
+func TestCannotSitInTwoQueues(t *testing.T) {
+	manager := NewQueueManager()
+	player := NewTestPlayer()
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  player,
+		Payload: QueueUpPayload{Mode: "casual"},
+	}, nil)
+
+	<-player.Outgoing // wait for match
+
+	go manager.Process(Event{
+		Type:    QueueUp,
+		Player:  player,
+		Payload: QueueUpPayload{Mode: "ranked"},
+	}, nil)
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+		if manager.queues["ranked"].Length() != 0 {
+			t.Error("Expected player not to be queued in ranked")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
+
+func TestCannotQueueUpWhileMatched(t *testing.T) {
+	dispatcher := NewDispatcher()
+	dispatcher.Register <- NewQueueManager()
+	dispatcher.Register <- NewMatchmaker()
+
+	p1 := NewTestPlayer()
+	p2 := NewTestPlayer()
+
+	for _, player := range []*Player{p1, p2} {
+		dispatcher.Dispatch <- Event{Type: QueueUp, Player: player}
+		expectResponse(t, player, WaitForMatch)
+	}
+
+	id := expectResponse(t, p1, MatchFound).Payload.(uuid.UUID)
+	expectResponse(t, p2, MatchFound)
+
+	dispatcher.Dispatch <- Event{Type: QueueUp, Player: p1}
+	if reason := expectResponse(t, p1, Error).Payload; reason != "Already in a match" {
+		t.Errorf("Expected %v, got %v", "Already in a match", reason)
+	}
+
+	dispatcher.Dispatch <- Event{Type: MatchConfirmed, Player: p1, Payload: id.String()}
+	expectResponse(t, p1, WaitOtherPlayers)
+
+	dispatcher.Dispatch <- Event{Type: MatchDeclined, Player: p2, Payload: id.String()}
+	expectResponse(t, p1, MatchCanceled)
+	expectResponse(t, p2, MatchCanceled)
+
+	// the match lets go of them before requeueing them
+	expectResponse(t, p1, WaitForMatch)
+}
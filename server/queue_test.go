@@ -1,6 +1,10 @@
 package server
 
-import "testing"
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
 
 func TestDequeueFirstInQueue(t *testing.T) {
 	queue := NewQueue()
@@ -86,3 +90,209 @@ func TestDequeueReducesPlayers(t *testing.T) {
 	}
 
 }
+
+func TestRemoveAfterDequeue(t *testing.T) {
+	queue := NewQueue()
+
+	first := &Player{Name: "first"}
+	second := &Player{Name: "second"}
+	third := &Player{Name: "third"}
+
+	queue.Queue(first)
+	queue.Queue(second)
+	queue.Queue(third)
+
+	queue.Dequeue()
+
+	if !queue.Remove(second) {
+		t.Error("Expected player to be removed")
+	}
+
+	if got := queue.Dequeue(); got != third {
+		t.Errorf("Expected %v, got %v", third, got)
+	}
+}
+
+func TestQueueingTwiceKeepsOneEntry(t *testing.T) {
+	queue := NewQueue()
+	player := &Player{}
+
+	queue.Queue(player)
+	queue.Queue(player)
+
+	if queue.Length() != 1 {
+		t.Errorf("Expected %v, got %v", 1, queue.Length())
+	}
+}
+
+func TestPairsPlayersWithinWindow(t *testing.T) {
+	queue := NewQueue()
+	window := MatchWindow{Base: 100, Max: 100}
+
+	low := &Player{Name: "low"}
+	low.SetRating(1000)
+
+	high := &Player{Name: "high"}
+	high.SetRating(1500)
+
+	close := &Player{Name: "close"}
+	close.SetRating(1050)
+
+	queue.Queue(low)
+	queue.Queue(high)
+
+	if pairs := queue.Pairs(window); len(pairs) != 0 {
+		t.Errorf("Expected no pairs, got %v", len(pairs))
+	}
+
+	queue.Queue(close)
+
+	pairs := queue.Pairs(window)
+	if len(pairs) != 1 {
+		t.Fatalf("Expected %v pair, got %v", 1, len(pairs))
+	}
+	if pairs[0][0] != low || pairs[0][1] != close {
+		t.Errorf("Expected low and close to be paired, got %v", pairs[0])
+	}
+	if queue.Length() != 1 {
+		t.Errorf("Expected %v player left, got %v", 1, queue.Length())
+	}
+}
+
+func TestNeverPairsAnAccountWithItself(t *testing.T) {
+	queue := NewQueue()
+	window := MatchWindow{Base: 100, Max: 100}
+
+	first := &Player{Id: "42"}
+	again := &Player{Id: "42"}
+	other := &Player{Id: "43"}
+
+	queue.Queue(first)
+	queue.Queue(again)
+
+	if pairs := queue.Pairs(window); len(pairs) != 0 {
+		t.Errorf("Expected no pairs, got %v", pairs)
+	}
+
+	queue.Queue(other)
+
+	pairs := queue.Pairs(window)
+	if len(pairs) != 1 || pairs[0][0] != first || pairs[0][1] != other {
+		t.Errorf("Expected the account to be paired with another, got %v", pairs)
+	}
+}
+
+func TestPairsClosestRatedPlayer(t *testing.T) {
+	queue := NewQueue()
+	window := MatchWindow{Base: 500, Max: 500}
+
+	player := &Player{}
+	player.SetRating(1200)
+
+	far := &Player{}
+	far.SetRating(1500)
+
+	near := &Player{}
+	near.SetRating(1250)
+
+	queue.Queue(player)
+	queue.Queue(far)
+	queue.Queue(near)
+
+	pairs := queue.Pairs(window)
+	if len(pairs) != 1 || pairs[0][1] != near {
+		t.Errorf("Expected closest player to be paired, got %v", pairs)
+	}
+}
+
+func TestWindowWidensWhileWaiting(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	queue := NewQueueWithClock(clock)
+
+	window := MatchWindow{Base: 50, Growth: 50, Step: time.Second, Max: 1000}
+
+	low := &Player{}
+	low.SetRating(1000)
+
+	high := &Player{}
+	high.SetRating(1200)
+
+	queue.Queue(low)
+	queue.Queue(high)
+
+	if pairs := queue.Pairs(window); len(pairs) != 0 {
+		t.Errorf("Expected no pairs, got %v", len(pairs))
+	}
+
+	clock.Advance(3 * time.Second)
+
+	if pairs := queue.Pairs(window); len(pairs) != 1 {
+		t.Errorf("Expected %v pair, got %v", 1, len(pairs))
+	}
+}
+
+func TestPairingSimulation(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	clock := NewFakeClock(time.Now())
+	queue := NewQueueWithClock(clock)
+
+	var arrivals []*Player
+	joined := make(map[*Player]time.Time)
+
+	var matched int
+	var totalDiff int
+	var longestWait time.Duration
+
+	// two players join every simulated second for ten minutes
+	for second := 0; second < 600; second++ {
+		for i := 0; i < 2; i++ {
+			player := &Player{}
+			player.SetRating(DefaultRating + int(random.NormFloat64()*250))
+
+			queue.Queue(player)
+			joined[player] = clock.Now()
+			arrivals = append(arrivals, player)
+		}
+
+		for _, pair := range queue.Pairs(DefaultMatchWindow) {
+			first, second := pair[0], pair[1]
+
+			wait := clock.Now().Sub(joined[first])
+			diff := abs(first.GetRating() - second.GetRating())
+
+			if diff > DefaultMatchWindow.Size(wait) {
+				t.Errorf("Paired players %v apart after waiting %v", diff, wait)
+			}
+			if wait > longestWait {
+				longestWait = wait
+			}
+
+			matched += 2
+			totalDiff += diff
+		}
+
+		clock.Advance(time.Second)
+	}
+
+	// what a first come, first served queue would have done
+	var fifoDiff int
+	for i := 0; i+1 < len(arrivals); i += 2 {
+		fifoDiff += abs(arrivals[i].GetRating() - arrivals[i+1].GetRating())
+	}
+
+	average := float64(totalDiff) / float64(matched/2)
+	fifoAverage := float64(fifoDiff) / float64(len(arrivals)/2)
+
+	t.Logf("matched %v of %v, average difference %.1f (fifo %.1f), longest wait %v",
+		matched, len(arrivals), average, fifoAverage, longestWait)
+
+	if matched < len(arrivals)*95/100 {
+		t.Errorf("Expected most players to be matched, got %v of %v", matched, len(arrivals))
+	}
+	if average > fifoAverage/4 {
+		t.Errorf("Expected average difference well below %.1f, got %.1f", fifoAverage, average)
+	}
+	if longestWait > time.Minute {
+		t.Errorf("Expected everyone to be matched within a minute, waited %v", longestWait)
+	}
+}
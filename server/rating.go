@@ -0,0 +1,49 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultRating = 1200
+	RatingFactor  = 32
+)
+
+func ExpectedScore(rating, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
+
+// Elo returns the new ratings for the winner and the loser of a game.
+func Elo(winner, loser int) (int, int) {
+	delta := int(math.Round(RatingFactor * (1 - ExpectedScore(winner, loser))))
+	return winner + delta, loser - delta
+}
+
+// MatchWindow is the maximum rating difference accepted between two
+// queued players. It starts at Base and grows by Growth every Step the
+// oldest of them has been waiting, up to Max.
+type MatchWindow struct {
+	Base   int
+	Growth int
+	Step   time.Duration
+	Max    int
+}
+
+var DefaultMatchWindow = MatchWindow{
+	Base:   50,
+	Growth: 50,
+	Step:   5 * time.Second,
+	Max:    1000,
+}
+
+func (w MatchWindow) Size(wait time.Duration) int {
+	size := w.Base
+	if w.Step > 0 {
+		size += w.Growth * int(wait/w.Step)
+	}
+	if size > w.Max {
+		size = w.Max
+	}
+	return size
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEloEvenPlayers(t *testing.T) {
+	winner, loser := Elo(1200, 1200)
+
+	if winner != 1216 {
+		t.Errorf("Expected %v, got %v", 1216, winner)
+	}
+	if loser != 1184 {
+		t.Errorf("Expected %v, got %v", 1184, loser)
+	}
+}
+
+func TestEloUpsetGivesMorePoints(t *testing.T) {
+	favorite, _ := Elo(1600, 1200)
+	underdog, _ := Elo(1200, 1600)
+
+	if favorite-1600 >= underdog-1200 {
+		t.Errorf("Expected underdog to gain more, got %v and %v", favorite-1600, underdog-1200)
+	}
+}
+
+func TestEloKeepsTotalRating(t *testing.T) {
+	winner, loser := Elo(1432, 1287)
+
+	if winner+loser != 1432+1287 {
+		t.Errorf("Expected total of %v, got %v", 1432+1287, winner+loser)
+	}
+}
+
+func TestMatchWindowWidensWithWait(t *testing.T) {
+	window := MatchWindow{Base: 50, Growth: 25, Step: time.Second, Max: 100}
+
+	if window.Size(0) != 50 {
+		t.Errorf("Expected %v, got %v", 50, window.Size(0))
+	}
+	if window.Size(1500*time.Millisecond) != 75 {
+		t.Errorf("Expected %v, got %v", 75, window.Size(1500*time.Millisecond))
+	}
+	if window.Size(time.Minute) != 100 {
+		t.Errorf("Expected %v, got %v", 100, window.Size(time.Minute))
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replay is a finished game's log: the cards each player started with,
+// in the order they'd draw them, and everything they did with them.
+// Played back against the rules, it gives the game again.
+type Replay struct {
+	Id      string
+	GameId  string
+	Mode    string
+	Players []ReplayPlayer
+	Actions []ReplayAction
+}
+
+// ReplayPlayer is a player as the game began, by account id, the first
+// one to play first.
+type ReplayPlayer struct {
+	Id   string
+	Hand []ReplayCard
+	Deck []ReplayCard
+}
+
+type ReplayCard struct {
+	Id       string
+	ManaCost int
+	Damage   int
+	Health   int
+}
+
+// ReplayAction is something a player did, or had done for them when
+// their turn ran out. Cards are the ones discarded with CardsDiscarded
+// and the one played with PlayCard.
+type ReplayAction struct {
+	// At is how far into the game it happened
+	At       time.Duration
+	Player   string
+	Type     EventType
+	Cards    []string
+	Attacker string
+	Target   string
+}
+
+func newReplay(game *Game, players []*Player) *Replay {
+	replay := &Replay{
+		Id:     uuid.New().String(),
+		GameId: game.Id.String(),
+		Mode:   game.Mode.Name,
+	}
+
+	for _, player := range players {
+		gamePlayer := game.Players[player]
+
+		replay.Players = append(replay.Players, ReplayPlayer{
+			Id:   player.Id,
+			Hand: replayCards(gamePlayer.Hand),
+			Deck: replayCards(gamePlayer.Deck.cards),
+		})
+	}
+
+	return replay
+}
+
+func replayCards(cards []HasManaCost) []ReplayCard {
+	replayed := make([]ReplayCard, 0, len(cards))
+	for _, card := range cards {
+		replayed = append(replayed, replayCard(card))
+	}
+	return replayed
+}
+
+func replayCard(card HasManaCost) ReplayCard {
+	replayed := ReplayCard{Id: card.GetId(), ManaCost: card.GetManaCost()}
+	if minion, ok := card.(Defender); ok {
+		replayed.Damage = minion.GetDamage()
+		replayed.Health = minion.GetHealth()
+	}
+	return replayed
+}
+
+func (r Replay) playedBy(accountId string) bool {
+	for _, player := range r.Players {
+		if player.Id == accountId {
+			return true
+		}
+	}
+	return false
+}
+
+// record logs what a player did, as the game goes.
+func (g *Game) record(player *GamePlayer, action ReplayAction) {
+	action.At = g.clock.Now().Sub(g.Created)
+	action.Player = player.player.Id
+	g.replay.Actions = append(g.replay.Actions, action)
+}
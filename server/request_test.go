@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAcksAppliedEvents(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Send(context.Background(), Event{Id: "queue", Type: QueueUp, Payload: QueueUpPayload{Mode: CasualMode.Name}})
+
+	if response := awaitResponse(t, client, WaitForMatch); response.RequestId != "queue" {
+		t.Errorf("Expected response to carry the request id, got %q", response.RequestId)
+	}
+	if response := awaitResponse(t, client, Ack); response.RequestId != "queue" {
+		t.Errorf("Expected queueing up to be acknowledged, got %q", response.RequestId)
+	}
+}
+
+func TestNacksEventsThatFail(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Send(context.Background(), Event{Id: "queue", Type: QueueUp, Payload: QueueUpPayload{Mode: "chess"}})
+
+	response := awaitResponse(t, client, Nack)
+	if response.RequestId != "queue" || response.Payload != "Unknown game mode" {
+		t.Errorf("Expected queueing up to fail, got %q: %v", response.RequestId, response.Payload)
+	}
+
+	// events nothing handles fail too, rather than leave the player
+	// waiting
+	client.Send(context.Background(), Event{Id: "end", Type: EndTurn, Payload: uuid.NewString()})
+
+	response = awaitResponse(t, client, Nack)
+	if response.RequestId != "end" || response.Payload != ErrUnhandled.Error() {
+		t.Errorf("Expected %v, got %q: %v", ErrUnhandled, response.RequestId, response.Payload)
+	}
+}
+
+func TestNacksGameActionsThatFail(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	first := queuedClient(t, "first")
+	second := queuedClient(t, "second")
+	gameId, _ := startGame(t, first, second)
+
+	// nobody's turn has begun while they choose their starting hands
+	first.Send(context.Background(), Event{
+		Id:      "play",
+		Type:    PlayCard,
+		Payload: PlayCardPayload{GameId: gameId.String(), Card: uuid.NewString()},
+	})
+
+	response := awaitResponse(t, first, Nack)
+	if response.RequestId != "play" || response.Payload != "Not your turn" {
+		t.Errorf("Expected playing a card to fail, got %q: %v", response.RequestId, response.Payload)
+	}
+
+	second.Send(context.Background(), Event{Id: "concede", Type: Concede, Payload: gameId.String()})
+
+	if response := awaitResponse(t, second, Ack); response.RequestId != "concede" {
+		t.Errorf("Expected conceding to be acknowledged, got %q", response.RequestId)
+	}
+}
+
+func TestRetriesAreNotAppliedTwice(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	queueUp := Event{Id: "queue", Type: QueueUp, Payload: QueueUpPayload{Mode: CasualMode.Name}}
+
+	client.Send(context.Background(), queueUp)
+	awaitResponse(t, client, Ack)
+
+	// had it been queued up again, it'd fail for already being queued
+	client.Send(context.Background(), queueUp)
+
+	if response := awaitResponse(t, client, Ack); response.RequestId != "queue" {
+		t.Errorf("Expected the retry to be acknowledged again, got %q", response.RequestId)
+	}
+
+	client.Send(context.Background(), Event{Id: "dequeue", Type: Dequeue})
+
+	response := awaitResponse(t, client, Dequeued)
+	if response.RequestId != "dequeue" {
+		t.Errorf("Expected a new request to be applied, got %v %q", response.Type, response.RequestId)
+	}
+}
+
+func TestRetriesAfterReconnectingAreNotAppliedTwice(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	queueUp := Event{Id: "queue", Type: QueueUp, Payload: QueueUpPayload{Mode: CasualMode.Name}}
+
+	client.Send(context.Background(), queueUp)
+	awaitResponse(t, client, Ack)
+	client.Close()
+
+	// the retry gets how it turned out the first time, rather than
+	// being applied again on the new connection
+	client = testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Send(context.Background(), queueUp)
+
+	if response := awaitResponse(t, client, Ack); response.RequestId != "queue" {
+		t.Errorf("Expected the retry to be acknowledged again, got %q", response.RequestId)
+	}
+
+	// had the retry queued the player up, this would fail
+	client.Send(context.Background(), Event{Id: "again", Type: QueueUp, Payload: QueueUpPayload{Mode: CasualMode.Name}})
+
+	if response := awaitResponse(t, client, WaitForMatch); response.RequestId != "again" {
+		t.Errorf("Expected a new request to be applied, got %q", response.RequestId)
+	}
+
+	// other accounts have requests of their own
+	other := testClient(t, "0.0.0.0:8080/?token="+testToken("other"))
+	awaitResponse(t, other, LoggedIn)
+
+	other.Send(context.Background(), queueUp)
+
+	if response := awaitResponse(t, other, WaitForMatch); response.RequestId != "queue" {
+		t.Errorf("Expected the other account's request to be applied, got %q", response.RequestId)
+	}
+}
+
+func TestPlayerForgetsOldRequests(t *testing.T) {
+	player := NewTestPlayer()
+
+	for i := 0; i <= rememberedRequests; i++ {
+		if _, fresh := player.request(fmt.Sprint(i)); !fresh {
+			t.Fatalf("Expected request %v to be new", i)
+		}
+	}
+
+	if _, fresh := player.request(fmt.Sprint(rememberedRequests)); fresh {
+		t.Error("Expected the latest request to be remembered")
+	}
+	if _, fresh := player.request("0"); !fresh {
+		t.Error("Expected the oldest request to be forgotten")
+	}
+}
@@ -9,26 +9,52 @@ import (
 type Response struct {
 	Type    ResponseType
 	Payload interface{}
+	// RequestId is the Id of the event the response is to, if it had one
+	RequestId string `json:",omitempty"`
 }
 
 type ResponseType string
 
 const (
-	Welcome          ResponseType = "welcome"
-	WaitForMatch     ResponseType = "wait_for_match"
-	MatchFound       ResponseType = "match_found"
-	Dequeued         ResponseType = "dequeued"
-	WaitOtherPlayers ResponseType = "wait_other_players"
-	MatchCanceled    ResponseType = "match_canceled"
-	StartingHand     ResponseType = "starting_hand"
-	StartTurn        ResponseType = "start_turn"
-	WaitTurn         ResponseType = "wait_turn"
-	CardPlayed       ResponseType = "card_played"
-	AttackResult     ResponseType = "attack_result"
-	DamageTaken      ResponseType = "damage_taken"
-	GameOver         ResponseType = "game_over"
+	Welcome           ResponseType = "welcome"
+	LoggedIn          ResponseType = "logged_in"
+	WaitForMatch      ResponseType = "wait_for_match"
+	MatchFound        ResponseType = "match_found"
+	Dequeued          ResponseType = "dequeued"
+	WaitOtherPlayers  ResponseType = "wait_other_players"
+	MatchCanceled     ResponseType = "match_canceled"
+	StartingHand      ResponseType = "starting_hand"
+	StartTurn         ResponseType = "start_turn"
+	WaitTurn          ResponseType = "wait_turn"
+	CardPlayed        ResponseType = "card_played"
+	AttackResult      ResponseType = "attack_result"
+	DamageTaken       ResponseType = "damage_taken"
+	GameOver          ResponseType = "game_over"
+	GameAborted       ResponseType = "game_aborted"
+	GameResumed       ResponseType = "game_resumed"
+	OpponentLeft      ResponseType = "opponent_left"
+	OpponentReturned  ResponseType = "opponent_returned"
+	DeckSaved         ResponseType = "deck_saved"
+	Decks             ResponseType = "decks"
+	MatchHistory      ResponseType = "match_history"
+	LobbyUpdated      ResponseType = "lobby_updated"
+	LobbyClosed       ResponseType = "lobby_closed"
+	ChallengeSent     ResponseType = "challenge_sent"
+	ChallengeReceived ResponseType = "challenge_received"
+	ChallengeDeclined ResponseType = "challenge_declined"
+	Maintenance       ResponseType = "maintenance"
+	GameReplay        ResponseType = "game_replay"
+
+	// Ack and Nack tell players whether an event they gave an id was
+	// applied, Nack with the reason it wasn't
+	Ack  ResponseType = "ack"
+	Nack ResponseType = "nack"
 
 	Error ResponseType = "error"
+	// PayloadRejected tells players what was wrong with the payload of
+	// an event, as a PayloadError, ahead of the Error or Nack for it.
+	// It's only sent from PayloadRejectedVersion on.
+	PayloadRejected ResponseType = "payload_rejected"
 )
 
 type TurnPayload struct {
@@ -49,7 +75,10 @@ type StartingHandPayload struct {
 	Id       uuid.UUID
 	GameId   uuid.UUID
 	Cards    []HasManaCost
+	Health   int
 	Duration time.Duration
+	// BoardSize is how many minions fit on each player's board
+	BoardSize int
 }
 
 type GameOverPayload struct {
@@ -57,9 +86,64 @@ type GameOverPayload struct {
 	Loser  *GamePlayer
 }
 
+// GameResumedPayload is what players who reconnect need to pick their
+// game back up. Choosing is set while they still have to keep or
+// mulligan their starting hand.
+type GameResumedPayload struct {
+	GameId      uuid.UUID
+	Id          uuid.UUID
+	Hand        []HasManaCost
+	Health      int
+	Mana        int
+	Board       *Board
+	EnemyHealth int
+	EnemyBoard  *Board
+	Current     bool
+	Choosing    bool
+}
+
+// OpponentLeftPayload tells players their opponent disconnected, and
+// how long they have to come back before forfeiting.
+type OpponentLeftPayload struct {
+	GameId uuid.UUID
+	Grace  time.Duration
+}
+
 type CardPlayedPayload struct {
 	Mana   int
 	Player uuid.UUID
 	Card   ActiveDefender
 	GameId uuid.UUID
 }
+
+// MatchCanceledPayload tells players whether they were put back in the
+// queue, so they don't queue up again themselves.
+type MatchCanceledPayload struct {
+	Requeued bool
+}
+
+type LobbyPlayerPayload struct {
+	Id      string
+	Name    string
+	HasDeck bool
+	Ready   bool
+}
+
+type LobbyPayload struct {
+	Code    string
+	Mode    string
+	Players []LobbyPlayerPayload
+}
+
+// MaintenancePayload tells players the server is going down, and when
+// games still running will be ended. Deadline is zero when there's no
+// telling.
+type MaintenancePayload struct {
+	Deadline time.Time
+}
+
+type ChallengeReceivedPayload struct {
+	ChallengeId string
+	Mode        string
+	From        Identity
+}
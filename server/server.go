@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,29 +14,187 @@ import (
 
 type Server struct {
 	Status chan int
+	// Heartbeat is what players connecting from now on are pinged with
+	Heartbeat Heartbeat
+	// OldestVersion is the oldest protocol version players can connect
+	// with
+	OldestVersion int
+	// Limits is how much players connecting from now on can send
+	Limits Limits
 
-	server     *http.Server
-	dispatcher *Dispatcher
-	upgrader   websocket.Upgrader
+	server        *http.Server
+	dispatcher    *Dispatcher
+	dispatch      Next
+	authenticator Authenticator
+	upgrader      websocket.Upgrader
+	clock         Clock
+
+	// connected players, each with a channel closed once they're gone
+	// and everyone's been told, and the goroutines reading from them
+	mutex       sync.Mutex
+	players     map[*Player]chan bool
+	connections sync.WaitGroup
+	closing     bool
+	// sessions is the connection each logged in account plays on
+	sessions map[string]*Player
+	// requests are the accounts' request logs, kept a while after
+	// they disconnect
+	requests map[string]*accountRequests
+}
+
+// accountRequests is an account's request log, and when its last
+// connection went, zero while it's connected.
+type accountRequests struct {
+	log  *requestLog
+	left time.Time
 }
 
-func NewServer(dispatcher *Dispatcher) *Server {
-	return &Server{
-		Status: make(chan int),
+func NewServer(dispatcher *Dispatcher, authenticator Authenticator) *Server {
+	return NewServerWithConfig(dispatcher, authenticator, DefaultServerConfig)
+}
 
-		dispatcher: dispatcher,
-		server:     &http.Server{},
-		upgrader:   websocket.Upgrader{},
+func NewServerWithConfig(dispatcher *Dispatcher, authenticator Authenticator, config ServerConfig) *Server {
+	server := &Server{
+		Status:        make(chan int, 1),
+		Heartbeat:     config.Heartbeat,
+		OldestVersion: config.OldestVersion,
+		Limits:        config.Limits,
+
+		dispatcher:    dispatcher,
+		authenticator: authenticator,
+		server:        &http.Server{},
+		upgrader:      websocket.Upgrader{Subprotocols: subprotocols()},
+		players:       make(map[*Player]chan bool),
+		sessions:      make(map[string]*Player),
+		requests:      make(map[string]*accountRequests),
+		clock:         RealClock,
 	}
+
+	// players have to log in before anything they send gets through,
+	// can only send events meant for them, with payloads that fit, and
+	// can't start anything new once the server is shutting down
+	server.dispatch = Chain(func(event Event) error {
+		select {
+		case dispatcher.Dispatch <- event:
+			return nil
+		case <-dispatcher.Done():
+			return ErrShuttingDown
+		}
+	}, RequireAuthentication(), Validate(), server.refuseWhileClosing())
+
+	return server
 }
 
+// Close shuts the server down without waiting for anything.
 func (s *Server) Close() {
-	s.server.Shutdown(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.Shutdown(ctx)
+}
+
+// Shutdown stops accepting connections and anything that would start
+// something new, tells players the server is going down for maintenance
+// and gives the dispatcher until ctx is done to let games finish. Then
+// it closes every connection, whether or not they did.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closing = true
+	s.mutex.Unlock()
+
+	s.server.Shutdown(ctx)
+
+	s.noticeMaintenance(ctx)
+
+	err := s.dispatcher.Shutdown(ctx)
+
+	for _, player := range s.connected() {
+		player.Close()
+	}
+	s.connections.Wait()
+
+	return err
+}
+
+// noticeMaintenance tells everyone connected the server is going down,
+// all at once so a player slow to read doesn't hold up the rest. It
+// waits for the notices to be on their way, so they come ahead of
+// whatever shutting down sends, unless ctx is done first.
+func (s *Server) noticeMaintenance(ctx context.Context) {
+	deadline, _ := ctx.Deadline()
+
+	var sent sync.WaitGroup
+	for _, player := range s.connected() {
+		sent.Add(1)
+		go func(player *Player) {
+			defer sent.Done()
+			player.Send(Response{
+				Type:    Maintenance,
+				Payload: MaintenancePayload{Deadline: deadline},
+			})
+		}(player)
+	}
+
+	all := make(chan bool)
+	go func() {
+		sent.Wait()
+		close(all)
+	}()
+
+	select {
+	case <-all:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Server) connected() []*Player {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	players := make([]*Player, 0, len(s.players))
+	for player := range s.players {
+		players = append(players, player)
+	}
+	return players
+}
+
+// reportConnected reports how many players are connected. It's called
+// with the mutex held.
+func (s *Server) reportConnected() {
+	s.dispatcher.Metrics().connectedPlayers.Set("", float64(len(s.players)))
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.closing
+}
+
+// refuseWhileClosing rejects events that would get players into a game
+// once the server is shutting down.
+func (s *Server) refuseWhileClosing() Middleware {
+	return func(next Next) Next {
+		return func(event Event) error {
+			switch event.Type {
+			case QueueUp, CreateLobby, JoinLobby, ChallengePlayer, AcceptChallenge:
+				if s.isClosing() {
+					return ErrShuttingDown
+				}
+			}
+			return next(event)
+		}
+	}
 }
 
 func (s *Server) Listen(addr string) {
+	// metrics are served alongside the players' connections
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.dispatcher.Metrics())
+	mux.HandleFunc("/", s.handleConnection)
+
 	s.server.Addr = addr
-	s.server.Handler = http.HandlerFunc(s.handleConnection)
+	s.server.Handler = mux
 
 	err := s.server.ListenAndServe()
 
@@ -48,6 +209,35 @@ func (s *Server) ListenQuietly(addr string) {
 }
 
 func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
+	if s.isClosing() {
+		http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	handshake, err := requestHandshake(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	welcome, err := negotiate(handshake, s.OldestVersion)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUpgradeRequired)
+		return
+	}
+
+	var identity Identity
+
+	if token := requestToken(r); token != "" {
+		var err error
+		identity, err = s.authenticator.Authenticate(token)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
 	s.upgrader.CheckOrigin = func(r *http.Request) bool {
 		return true
 	}
@@ -57,21 +247,217 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 		log.Println("Could not upgrade connection")
 		return
 	}
+	socket.SetReadLimit(s.Limits.MaxMessageSize)
+
+	player := NewPlayerWithHeartbeat(socket, s.Heartbeat)
+	player.Version = welcome.Version
+	player.capabilities = welcome.Capabilities
+	player.metrics = s.dispatcher.Metrics()
+
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		player.Close()
+		return
+	}
+	gone := make(chan bool)
+	s.players[player] = gone
+	s.connections.Add(1)
+	s.reportConnected()
+	s.mutex.Unlock()
 
-	player := NewPlayer(socket)
 	player.Send(Response{
-		Type: Welcome,
+		Type:    Welcome,
+		Payload: welcome,
 	})
 
+	if identity.Id != "" {
+		s.identify(player, identity)
+	}
+
+	// each connection has limits of its own, so nobody spamming the
+	// server gets anywhere near the dispatcher
+	limiter := newLimiter(s.Limits, s.clock)
+
 	go func() {
+		defer s.connections.Done()
+		defer func() {
+			s.mutex.Lock()
+			delete(s.players, player)
+			if s.sessions[player.Id] == player {
+				delete(s.sessions, player.Id)
+				s.requests[player.Id].left = s.clock.Now()
+			}
+			s.reportConnected()
+			s.mutex.Unlock()
+
+			// whatever the player was in the middle of has to go on
+			// without them
+			s.dispatcher.Emit(Event{
+				Type:   PlayerDisconnected,
+				Player: player,
+			})
+			close(gone)
+		}()
 		defer player.Close()
 
 		for {
 			select {
+			case <-player.Done():
+				return
 			case event := <-player.Incoming:
 				event.Player = player
-				s.dispatcher.Dispatch <- event
+
+				switch limiter.judge(event.Type) {
+				case warn:
+					event.Fail(ErrRateLimited.Error())
+					continue
+				case mute:
+					event.Fail(ErrMuted.Error())
+					continue
+				case drop:
+					continue
+				case disconnect:
+					event.Fail(ErrAbusiveRate.Error())
+					log.Printf("Disconnected %v for sending too many events\n", r.RemoteAddr)
+					return
+				}
+
+				if event.Id != "" {
+					// retries aren't applied again, they get the
+					// outcome once there is one
+					if outcome, fresh := player.request(event.Id); !fresh {
+						if outcome != nil {
+							player.Send(*outcome)
+						}
+						continue
+					}
+				}
+
+				if event.Type == Login {
+					s.login(event)
+					continue
+				}
+
+				if event.Type == "" {
+					event.Fail(ErrMalformedMessage.Error())
+					continue
+				}
+
+				if err := s.dispatch(event); err != nil {
+					s.reject(event, err)
+				}
 			}
 		}
 	}()
 }
+
+func (s *Server) login(event Event) {
+	var token string
+	if err := event.Decode(&token); err != nil {
+		event.Fail(ErrInvalidToken.Error())
+		return
+	}
+
+	identity, err := s.authenticator.Authenticate(token)
+
+	if err != nil {
+		event.Fail(err.Error())
+		return
+	}
+
+	if err := s.identify(event.Player, identity); err != nil {
+		event.Fail(err.Error())
+		return
+	}
+	event.Ack()
+}
+
+func (s *Server) identify(player *Player, identity Identity) error {
+	if err := player.Identify(identity); err != nil {
+		return err
+	}
+
+	// an account plays on one connection at a time, so it's never
+	// matched against itself. The latest takes over, once the one
+	// before is gone as if it had disconnected, which lets players
+	// whose old connection hasn't timed out yet get back to their game.
+	s.mutex.Lock()
+	previous := s.sessions[identity.Id]
+	s.sessions[identity.Id] = player
+	gone := s.players[previous]
+	requests := s.accountRequests(identity.Id)
+	s.mutex.Unlock()
+
+	// retries sent after reconnecting aren't applied twice either
+	player.shareRequests(requests)
+
+	if previous != nil {
+		previous.Send(Response{Type: Error, Payload: ErrLoggedInElsewhere.Error()})
+		previous.Close()
+		if gone != nil {
+			<-gone
+		}
+	}
+
+	// emitted, since players can't send it themselves and wouldn't
+	// get it past the dispatcher's middlewares
+	s.dispatcher.Emit(Event{
+		Type:   PlayerLoggedIn,
+		Player: player,
+	})
+
+	player.Send(Response{
+		Type:    LoggedIn,
+		Payload: identity,
+	})
+	return nil
+}
+
+// reject tells the player why the event they sent was refused, and what
+// was wrong with its payload if they can be told.
+func (s *Server) reject(event Event, err error) {
+	var payloadErr *PayloadError
+	if errors.As(err, &payloadErr) && event.Player.Version >= PayloadRejectedVersion {
+		event.Player.Send(Response{
+			Type:      PayloadRejected,
+			Payload:   *payloadErr,
+			RequestId: event.Id,
+		})
+	}
+
+	event.Fail(err.Error())
+}
+
+// accountRequests is the request log of the account, kept while it's
+// connected. Those of accounts gone for long enough are forgotten. It's
+// called with the mutex held.
+func (s *Server) accountRequests(id string) *requestLog {
+	now := s.clock.Now()
+	for other, requests := range s.requests {
+		if !requests.left.IsZero() && now.Sub(requests.left) > rememberedRequestsFor {
+			delete(s.requests, other)
+		}
+	}
+
+	requests, ok := s.requests[id]
+	if !ok {
+		requests = &accountRequests{log: newRequestLog()}
+		s.requests[id] = requests
+	}
+	requests.left = time.Time{}
+	return requests.log
+}
+
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	return ""
+}
@@ -1,16 +1,44 @@
 package server
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+var testAuthenticator = NewHMACAuthenticator([]byte("secret"))
+
+func testToken(id string) string {
+	token, _ := testAuthenticator.Sign(Identity{Id: id, Name: id}, time.Hour)
+	return token
+}
+
+func testClient(t *testing.T, addr string) *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	client, err := Connect(ctx, addr)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client
+}
+
 func TestAcceptsConnections(t *testing.T) {
-	server := NewServer(NewDispatcher())
+	server := NewServer(NewDispatcher(), testAuthenticator)
 	defer server.Close()
 
 	server.ListenQuietly("0.0.0.0:8080")
-	client := NewClient("0.0.0.0:8080")
+	client := testClient(t, "0.0.0.0:8080")
 
 	select {
 	case <-client.Incoming:
@@ -20,17 +48,13 @@ func TestAcceptsConnections(t *testing.T) {
 }
 
 func TestClosesServer(t *testing.T) {
-	server := NewServer(NewDispatcher())
+	server := NewServer(NewDispatcher(), testAuthenticator)
 	server.ListenQuietly("0.0.0.0:8080")
 
 	server.Close()
 
-	client := NewClient("0.0.0.0:8080")
-
-	select {
-	case <-client.Incoming:
+	if _, err := Connect(context.Background(), "0.0.0.0:8080"); err == nil {
 		t.Error("Expected server to be closed")
-	case <-time.After(time.Millisecond):
 	}
 }
 
@@ -43,16 +67,17 @@ func TestDispatchesEvents(t *testing.T) {
 
 	dispatcher.Register <- handler
 
-	server := NewServer(dispatcher)
+	server := NewServer(dispatcher, testAuthenticator)
 	server.ListenQuietly("0.0.0.0:8080")
 
 	defer server.Close()
 
-	client := NewClient("0.0.0.0:8080")
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
 
-	client.Outgoing <- Event{
-		Type: QueueUp,
-	}
+	<-client.Incoming // welcome
+	<-client.Incoming // logged in
+
+	client.QueueUp(CasualMode.Name)
 
 	select {
 	case executed := <-handler.Executed:
@@ -63,3 +88,476 @@ func TestDispatchesEvents(t *testing.T) {
 		t.Error("Expected response from server")
 	}
 }
+
+func TestLoginEvent(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	<-client.Incoming // welcome
+
+	client.Login(testToken("account-id"))
+
+	select {
+	case response := <-client.Incoming:
+		if response.Type != LoggedIn {
+			t.Errorf("Expected %v, got %v", LoggedIn, response.Type)
+		}
+
+		identity := response.Payload.(Identity)
+		if identity.Id != "account-id" {
+			t.Errorf("Expected %v, got %v", "account-id", identity.Id)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected logged in response")
+	}
+}
+
+func TestRejectsInvalidLogin(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	<-client.Incoming // welcome
+
+	client.Login("not a token")
+
+	select {
+	case response := <-client.Incoming:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
+
+func TestRejectsLoggingInTwice(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+
+	client.Login(testToken("first"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Login(testToken("second"))
+	response := awaitResponse(t, client, Error)
+
+	if response.Payload != ErrAlreadyLoggedIn.Error() {
+		t.Errorf("Expected %v, got %v", ErrAlreadyLoggedIn, response.Payload)
+	}
+}
+
+func TestRequiresAuthenticationToDispatch(t *testing.T) {
+	dispatcher := NewDispatcher()
+
+	handler := &TestHandler{
+		make(chan bool),
+	}
+
+	dispatcher.Register <- handler
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080")
+	<-client.Incoming // welcome
+
+	client.QueueUp(CasualMode.Name)
+
+	select {
+	case response := <-client.Incoming:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-handler.Executed:
+		t.Error("Should not dispatch events from anonymous players")
+	case <-time.After(time.Second):
+		t.Error("Expected error response")
+	}
+}
+
+func TestRejectsInvalidQueryToken(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	if _, err := Connect(context.Background(), "0.0.0.0:8080/?token=invalid"); err == nil {
+		t.Error("Expected connection to be refused")
+	}
+}
+
+var testHeartbeat = Heartbeat{
+	Interval:     10 * time.Millisecond,
+	Timeout:      50 * time.Millisecond,
+	WriteTimeout: 50 * time.Millisecond,
+}
+
+func listenForDisconnects(t *testing.T) (*Server, *SubscribedHandler) {
+	dispatcher := NewDispatcher()
+	handler := &SubscribedHandler{
+		Events:        make(chan Event, 1),
+		subscriptions: subscribe("", PlayerDisconnected),
+	}
+	dispatcher.Register <- handler
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.Heartbeat = testHeartbeat
+	server.ListenQuietly("0.0.0.0:8080")
+
+	t.Cleanup(server.Close)
+
+	return server, handler
+}
+
+func TestEmitsPlayerDisconnected(t *testing.T) {
+	_, handler := listenForDisconnects(t)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Close()
+
+	select {
+	case event := <-handler.Events:
+		if event.Player == nil || event.Player.Id != "player" {
+			t.Errorf("Expected player to have disconnected, got %+v", event.Player)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected player disconnected event")
+	}
+}
+
+func TestLoggingInAgainTakesOverTheSession(t *testing.T) {
+	_, handler := listenForDisconnects(t)
+
+	first := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, first, LoggedIn)
+
+	second := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+
+	// the older connection goes as if it had disconnected, before the
+	// new one is logged in
+	select {
+	case event := <-handler.Events:
+		if event.Player == nil || event.Player.Id != "player" {
+			t.Errorf("Expected the older connection to be gone, got %+v", event.Player)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected player disconnected event")
+	}
+
+	if response := awaitResponse(t, first, Error); response.Payload != ErrLoggedInElsewhere.Error() {
+		t.Errorf("Expected %v, got %v", ErrLoggedInElsewhere, response.Payload)
+	}
+	awaitResponse(t, second, LoggedIn)
+
+	// and the new one is the account's session from now on
+	second.Close()
+
+	select {
+	case <-handler.Events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected player disconnected event")
+	}
+}
+
+func TestDisconnectsUnresponsivePlayers(t *testing.T) {
+	_, handler := listenForDisconnects(t)
+
+	// a socket nobody reads from never answers pings, like one whose
+	// other end went away without closing it
+	socket, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/?token="+testToken("player"), nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer socket.Close()
+
+	select {
+	case <-handler.Events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected unresponsive player to be disconnected")
+	}
+}
+
+func TestKeepsRespondingPlayersConnected(t *testing.T) {
+	_, handler := listenForDisconnects(t)
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	select {
+	case <-handler.Events:
+		t.Fatal("Expected player answering pings to stay connected")
+	case <-time.After(4 * testHeartbeat.Timeout):
+	}
+
+	client.QueueUp(CasualMode.Name)
+	if err := client.Err(); err != nil {
+		t.Errorf("Expected client to still be connected, got %v", err)
+	}
+}
+
+// listenForGames runs a server that matches players, giving those who
+// disconnect from a game grace to come back.
+func listenForGames(t *testing.T, grace time.Duration) *SubscribedHandler {
+	mode := CasualMode
+	mode.Rules.ReconnectGrace = grace
+
+	dispatcher := NewDispatcher()
+	disconnects := &SubscribedHandler{
+		Events:        make(chan Event, 4),
+		subscriptions: subscribe("", PlayerDisconnected),
+	}
+
+	dispatcher.Register <- disconnects
+	dispatcher.Register <- NewQueueManager(mode)
+	dispatcher.Register <- NewMatchmaker()
+	dispatcher.Register <- NewGameManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	t.Cleanup(server.Close)
+
+	return disconnects
+}
+
+func TestRejectsEventsPlayersCantSend(t *testing.T) {
+	dispatcher := NewDispatcher()
+	internal := &SubscribedHandler{
+		Events:        make(chan Event, 4),
+		subscriptions: subscribe("", StartGame, GameFinished, QueueUp),
+	}
+	dispatcher.Register <- internal
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Send(context.Background(), Event{Id: "start", Type: StartGame, Payload: "not a match"})
+	if response := awaitResponse(t, client, Nack); !strings.HasPrefix(response.Payload.(string), ErrUnknownEvent.Error()) {
+		t.Errorf("Expected %v, got %v", ErrUnknownEvent, response.Payload)
+	}
+
+	client.Send(context.Background(), Event{Id: "queue", Type: QueueUp, Payload: QueueUpPayload{Mode: "casual"}})
+
+	// only the event players can send gets through, with its payload
+	// decoded
+	select {
+	case event := <-internal.Events:
+		if event.Type != QueueUp {
+			t.Fatalf("Expected only %v to get through, got %v", QueueUp, event.Type)
+		}
+		if _, ok := event.Payload.(QueueUpPayload); !ok {
+			t.Errorf("Expected a decoded payload, got %T", event.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the player's event to get through")
+	}
+}
+
+func TestAnswersMalformedMessagesAndKeepsReading(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:8080/?token="+testToken("player"), nil)
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer socket.Close()
+
+	next := func() Response {
+		t.Helper()
+
+		socket.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := socket.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected a response, got %v", err)
+		}
+		response, err := jsonCodec{}.DecodeResponse(data)
+		if err != nil {
+			t.Fatalf("Could not decode %s: %v", data, err)
+		}
+		return response
+	}
+
+	next() // welcome
+	next() // logged in
+
+	socket.WriteMessage(websocket.TextMessage, []byte(`{"Type": 5}`))
+
+	if response := next(); response.Type != Error || response.Payload != ErrMalformedMessage.Error() {
+		t.Errorf("Expected %v %q, got %v %v", Error, ErrMalformedMessage, response.Type, response.Payload)
+	}
+
+	// the connection is still there for what comes next, and those on
+	// older versions aren't told about payloads in a way they don't know
+	socket.WriteMessage(websocket.TextMessage, []byte(`{"Type": "queue_up", "Payload": {"Mode": 1}}`))
+
+	if response := next(); response.Type != Error {
+		t.Errorf("Expected %v, got %v %v", Error, response.Type, response.Payload)
+	}
+}
+
+func TestTellsWhatWasWrongWithPayloads(t *testing.T) {
+	server := NewServer(NewDispatcher(), testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	defer server.Close()
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	client.Send(context.Background(), Event{Id: "queue", Type: QueueUp, Payload: map[string]interface{}{"Mode": 1}})
+
+	response := awaitResponse(t, client, PayloadRejected)
+	expected := PayloadError{Event: QueueUp, Field: "Mode", Reason: "must be a string"}
+	if response.RequestId != "queue" || response.Payload != expected {
+		t.Errorf("Expected %+v, got %q: %+v", expected, response.RequestId, response.Payload)
+	}
+
+	if response := awaitResponse(t, client, Nack); response.RequestId != "queue" {
+		t.Errorf("Expected the event to be refused, got %q", response.RequestId)
+	}
+}
+
+func queuedClient(t *testing.T, id string) *Client {
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken(id))
+	awaitResponse(t, client, LoggedIn)
+
+	client.QueueUp(CasualMode.Name)
+	awaitResponse(t, client, WaitForMatch)
+
+	return client
+}
+
+// startGame has both clients confirm the match they were found, and
+// returns the game's id along with their ids in it.
+func startGame(t *testing.T, clients ...*Client) (uuid.UUID, []uuid.UUID) {
+	for _, client := range clients {
+		client.ConfirmMatch(awaitResponse(t, client, MatchFound).Payload.(uuid.UUID))
+	}
+
+	var gameId uuid.UUID
+	ids := make([]uuid.UUID, 0, len(clients))
+	for _, client := range clients {
+		hand := awaitResponse(t, client, StartingHand).Payload.(StartingHandView)
+		gameId = hand.GameId
+		ids = append(ids, hand.Id)
+	}
+
+	return gameId, ids
+}
+
+func TestDisconnectedPlayerLeavesQueue(t *testing.T) {
+	disconnects := listenForGames(t, time.Minute)
+
+	gone := queuedClient(t, "gone")
+	gone.Close()
+
+	select {
+	case <-disconnects.Events:
+	case <-time.After(time.Second):
+		t.Fatal("Expected player to disconnect")
+	}
+
+	first := queuedClient(t, "first")
+	second := queuedClient(t, "second")
+
+	// had the first been matched with whoever left, the second would
+	// still be waiting
+	firstMatch := awaitResponse(t, first, MatchFound).Payload.(uuid.UUID)
+	secondMatch := awaitResponse(t, second, MatchFound).Payload.(uuid.UUID)
+
+	if firstMatch != secondMatch {
+		t.Errorf("Expected players still queued to be matched together")
+	}
+}
+
+func TestMatchIsCanceledWhenPlayerDisconnects(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	gone := queuedClient(t, "gone")
+	other := queuedClient(t, "other")
+
+	awaitResponse(t, gone, MatchFound)
+	awaitResponse(t, other, MatchFound)
+
+	gone.Close()
+
+	awaitResponse(t, other, MatchCanceled)
+	if mode := awaitResponse(t, other, WaitForMatch).Payload.(string); mode != CasualMode.Name {
+		t.Errorf("Expected other player to be queued for %v again, got %v", CasualMode.Name, mode)
+	}
+}
+
+func TestDisconnectedPlayerCanReturnToGame(t *testing.T) {
+	listenForGames(t, time.Minute)
+
+	gone := queuedClient(t, "gone")
+	other := queuedClient(t, "other")
+	gameId, ids := startGame(t, gone, other)
+
+	gone.Close()
+
+	left := awaitResponse(t, other, OpponentLeft).Payload.(OpponentLeftPayload)
+	if left.GameId != gameId || left.Grace != time.Minute {
+		t.Errorf("Expected a minute's grace in game %v, got %+v", gameId, left)
+	}
+
+	back := testClient(t, "0.0.0.0:8080/?token="+testToken("gone"))
+
+	resumed := awaitResponse(t, back, GameResumed).Payload.(GameResumedView)
+	if resumed.GameId != gameId || resumed.Id != ids[0] {
+		t.Errorf("Expected to be back in game %v as %v, got %+v", gameId, ids[0], resumed)
+	}
+	if !resumed.Choosing || len(resumed.Hand) != CasualMode.Rules.StartingHand {
+		t.Errorf("Expected to still be choosing a starting hand, got %+v", resumed)
+	}
+
+	if returned := awaitResponse(t, other, OpponentReturned).Payload.(uuid.UUID); returned != gameId {
+		t.Errorf("Expected %v, got %v", gameId, returned)
+	}
+
+	// the new connection plays in place of the old one
+	back.Concede(gameId)
+
+	over := awaitResponse(t, other, GameOver).Payload.(GameOverView)
+	if over.Winner.Id != ids[1] {
+		t.Errorf("Expected player who stayed to win, got %v", over.Winner.Id)
+	}
+}
+
+func TestDisconnectedPlayerForfeitsAfterGrace(t *testing.T) {
+	listenForGames(t, 50*time.Millisecond)
+
+	gone := queuedClient(t, "gone")
+	other := queuedClient(t, "other")
+	_, ids := startGame(t, gone, other)
+
+	gone.Close()
+
+	awaitResponse(t, other, OpponentLeft)
+
+	over := awaitResponse(t, other, GameOver).Payload.(GameOverView)
+	if over.Winner.Id != ids[1] || over.Loser.Id != ids[0] {
+		t.Errorf("Expected player who left to lose, got %+v", over)
+	}
+}
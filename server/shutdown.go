@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Stopper is a handler with goroutines of its own, which it stops when
+// the dispatcher shuts down. Stop returns once they're stopped, or once
+// ctx is done if that comes first.
+type Stopper interface {
+	Handler
+	Stop(ctx context.Context)
+}
+
+// wait waits for group, or until ctx is done if that comes first.
+func wait(ctx context.Context, group *sync.WaitGroup) error {
+	done := make(chan bool)
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
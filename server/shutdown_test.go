@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestQueueManagerStopDequeuesPlayers(t *testing.T) {
+	manager := NewQueueManager()
+	player := NewTestPlayer()
+
+	go manager.Process(Event{Type: QueueUp, Player: player}, nil)
+	<-player.Outgoing // wait for match
+
+	go manager.Stop(context.Background())
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Dequeued {
+			t.Errorf("Expected %v, got %v", Dequeued, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected player to be dequeued")
+	}
+
+	done := make(chan bool)
+	go func() {
+		manager.Process(Event{Type: QueueUp, Player: player}, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected stopped queue not to hold up handlers")
+	}
+}
+
+func TestMatchStopCancelsMatch(t *testing.T) {
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	match := NewMatch([]*Player{p1, p2}, CasualMode, time.Minute)
+
+	go match.Stop(context.Background())
+
+	for _, player := range []*Player{p1, p2} {
+		select {
+		case response := <-player.Outgoing:
+			if response.Type != MatchCanceled {
+				t.Errorf("Expected %v, got %v", MatchCanceled, response.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected match to be canceled")
+		}
+	}
+}
+
+func startTestGame(manager *GameManager, dispatcher *Dispatcher, players ...*Player) {
+	dispatcher.Emit(Event{
+		Type:    StartGame,
+		Payload: MatchPayload{Players: players, Mode: CasualMode},
+	})
+
+	for _, player := range players {
+		<-player.Outgoing // starting hand
+	}
+}
+
+func TestGameManagerStopWaitsForGames(t *testing.T) {
+	dispatcher := NewDispatcher()
+	manager := NewGameManager()
+	dispatcher.Register <- manager
+
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	startTestGame(manager, dispatcher, p1, p2)
+
+	stopped := make(chan bool)
+	go func() {
+		manager.Stop(context.Background())
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected stop to wait for the game")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// new games are refused in the meantime
+	p3, p4 := NewTestPlayer(), NewTestPlayer()
+	dispatcher.Emit(Event{
+		Type:    StartGame,
+		Payload: MatchPayload{Players: []*Player{p3, p4}, Mode: CasualMode},
+	})
+	for _, player := range []*Player{p3, p4} {
+		select {
+		case response := <-player.Outgoing:
+			if response.Type != Error || response.Payload != ErrShuttingDown.Error() {
+				t.Errorf("Expected %v, got %v: %v", Error, response.Type, response.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected game to be refused")
+		}
+	}
+
+	go func() {
+		for range p1.Outgoing {
+		}
+	}()
+	go func() {
+		for range p2.Outgoing {
+		}
+	}()
+
+	// conceding ends the game, and with it the wait
+	var game *Game
+	manager.mutex.Lock()
+	for running := range manager.games {
+		game = running
+	}
+	manager.mutex.Unlock()
+
+	dispatcher.Emit(Event{Type: Concede, Player: p1, Payload: game.Id.String()})
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected stop to return once the game was over")
+	}
+}
+
+func TestGameManagerStopAbortsGamesPastDeadline(t *testing.T) {
+	dispatcher := NewDispatcher()
+	manager := NewGameManager()
+	dispatcher.Register <- manager
+
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	startTestGame(manager, dispatcher, p1, p2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	go manager.Stop(ctx)
+
+	for _, player := range []*Player{p1, p2} {
+		select {
+		case response := <-player.Outgoing:
+			if response.Type != GameAborted {
+				t.Errorf("Expected %v, got %v", GameAborted, response.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected game to be aborted")
+		}
+	}
+}
+
+func TestDispatcherShutdown(t *testing.T) {
+	dispatcher := NewDispatcher()
+	manager := NewQueueManager()
+	handler := &TestHandler{Executed: make(chan bool, 1)}
+
+	dispatcher.Register <- manager
+	dispatcher.Register <- handler
+
+	if err := dispatcher.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected clean shutdown, got %v", err)
+	}
+
+	select {
+	case <-dispatcher.Done():
+	default:
+		t.Error("Expected dispatcher to be done")
+	}
+
+	select {
+	case <-manager.done:
+	default:
+		t.Error("Expected queue manager to be stopped")
+	}
+
+	dispatcher.Emit(Event{Type: QueueUp})
+
+	select {
+	case <-handler.Executed:
+		t.Error("Expected events after shutdown to be ignored")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// blockingStopper holds up shutdown until it's released.
+type blockingStopper struct {
+	release chan bool
+}
+
+func (b *blockingStopper) Process(event Event, dispatcher *Dispatcher) {}
+
+func (b *blockingStopper) Subscriptions() []Subscription {
+	return subscribe("", GameFinished)
+}
+
+func (b *blockingStopper) Stop(ctx context.Context) {
+	<-b.release
+}
+
+func TestServerRefusesNewGamesWhileShuttingDown(t *testing.T) {
+	dispatcher := NewDispatcher()
+	stopper := &blockingStopper{release: make(chan bool)}
+	dispatcher.Register <- NewQueueManager()
+	dispatcher.Register <- stopper
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	client := testClient(t, "0.0.0.0:8080/?token="+testToken("player"))
+	awaitResponse(t, client, LoggedIn)
+
+	shutdown := make(chan bool)
+	go func() {
+		server.Shutdown(context.Background())
+		close(shutdown)
+	}()
+
+	awaitResponse(t, client, Maintenance)
+
+	client.QueueUp(CasualMode.Name)
+	if response := awaitResponse(t, client, Error); response.Payload != ErrShuttingDown.Error() {
+		t.Errorf("Expected %v, got %v", ErrShuttingDown, response.Payload)
+	}
+
+	if _, err := Connect(context.Background(), "0.0.0.0:8080"); err == nil {
+		t.Error("Expected new connections to be refused")
+	}
+
+	close(stopper.release)
+
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("Expected shutdown to finish")
+	}
+}
+
+func TestServerShutdownNoticeIsNotHeldUpBySlowPlayers(t *testing.T) {
+	dispatcher := NewDispatcher()
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	// nothing ever reads what's sent to this one
+	stuck := NewTestPlayer()
+	server.mutex.Lock()
+	server.players[stuck] = make(chan bool)
+	server.mutex.Unlock()
+
+	var clients []*Client
+	for _, id := range []string{"first", "second", "third"} {
+		client := testClient(t, "0.0.0.0:8080/?token="+testToken(id))
+		awaitResponse(t, client, LoggedIn)
+		clients = append(clients, client)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shutdown := make(chan bool)
+	go func() {
+		server.Shutdown(ctx)
+		close(shutdown)
+	}()
+
+	for _, client := range clients {
+		awaitResponse(t, client, Maintenance)
+	}
+
+	cancel()
+
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("Expected shutdown to finish")
+	}
+}
+
+func TestServerShutdownLeavesNoGoroutines(t *testing.T) {
+	baseline := goroutines()
+
+	store := NewMemoryStore()
+	dispatcher := NewDispatcher()
+
+	dispatcher.Register <- NewAccountManager(store)
+	dispatcher.Register <- NewQueueManager()
+	dispatcher.Register <- NewLobbyManager(store)
+	dispatcher.Register <- NewMatchmaker()
+	dispatcher.Register <- NewGameManager()
+
+	server := NewServer(dispatcher, testAuthenticator)
+	server.ListenQuietly("0.0.0.0:8080")
+
+	clients := make(map[string]*Client)
+	for _, id := range []string{"first", "second", "queued", "lobby"} {
+		client, err := Connect(context.Background(), "0.0.0.0:8080/?token="+testToken(id))
+		if err != nil {
+			t.Fatalf("Could not connect: %v", err)
+		}
+		awaitResponse(t, client, LoggedIn)
+		clients[id] = client
+	}
+
+	for _, id := range []string{"first", "second"} {
+		clients[id].QueueUp(CasualMode.Name)
+		awaitResponse(t, clients[id], WaitForMatch)
+	}
+	for _, id := range []string{"first", "second"} {
+		clients[id].ConfirmMatch(awaitResponse(t, clients[id], MatchFound).Payload.(uuid.UUID))
+	}
+	for _, id := range []string{"first", "second"} {
+		awaitResponse(t, clients[id], StartingHand)
+	}
+
+	clients["queued"].QueueUp(CasualMode.Name)
+	awaitResponse(t, clients["queued"], WaitForMatch)
+
+	clients["lobby"].CreateLobby(CasualMode.Name)
+	awaitResponse(t, clients["lobby"], LobbyUpdated)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the game to run past the deadline, got %v", err)
+	}
+
+	expected := map[string]ResponseType{
+		"first":  GameAborted,
+		"second": GameAborted,
+		"queued": Dequeued,
+		"lobby":  LobbyClosed,
+	}
+	for id, kind := range expected {
+		awaitResponse(t, clients[id], Maintenance)
+		awaitResponse(t, clients[id], kind)
+
+		// and then the server hangs up
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		for {
+			if _, err := clients[id].Next(ctx); err != nil {
+				if err == context.DeadlineExceeded {
+					t.Errorf("Expected %v to be disconnected", id)
+				}
+				break
+			}
+		}
+		cancel()
+
+		clients[id].Close()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		leaked := startedSince(baseline)
+		if len(leaked) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected no goroutines left, got %v:\n\n%v", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// goroutine is a running goroutine's stack, along with the id of the
+// goroutine that started it.
+type goroutine struct {
+	parent int
+	stack  string
+}
+
+// goroutines returns every running goroutine, by id.
+func goroutines() map[int]goroutine {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	running := make(map[int]goroutine)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		var id, parent int
+		fmt.Sscanf(stack, "goroutine %d", &id)
+		if idx := strings.LastIndex(stack, " in goroutine "); idx >= 0 {
+			fmt.Sscanf(stack[idx:], " in goroutine %d", &parent)
+		}
+		running[id] = goroutine{parent: parent, stack: stack}
+	}
+	return running
+}
+
+// startedSince returns the stacks of goroutines started by the current
+// one, or by those it started, since baseline was taken. Whatever other
+// tests left running is ignored, along with anything it starts.
+func startedSince(baseline map[int]goroutine) []string {
+	var current int
+	buf := make([]byte, 64)
+	fmt.Sscanf(string(buf[:runtime.Stack(buf, false)]), "goroutine %d", &current)
+
+	running := goroutines()
+
+	ours := func(id int) bool {
+		for {
+			parent := running[id].parent
+			if parent == current {
+				return true
+			}
+			if _, old := baseline[parent]; old || parent == 0 {
+				return false
+			}
+			if _, alive := running[parent]; !alive {
+				// whatever started it has ended, so it's ours unless
+				// it's older than the baseline
+				for old := range baseline {
+					if old > parent {
+						return false
+					}
+				}
+				return true
+			}
+			id = parent
+		}
+	}
+
+	var leaked []string
+	for id, routine := range running {
+		if _, old := baseline[id]; !old && id != current && ours(id) {
+			leaked = append(leaked, routine.stack)
+		}
+	}
+	return leaked
+}
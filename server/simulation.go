@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoDecks = errors.New("no decks to simulate")
+var ErrUnknownStrategy = errors.New("unknown strategy")
+
+type SimulationConfig struct {
+	// Decks play every other deck, or themselves when there's only one.
+	Decks []DeckList
+	// Games is the number of games played per pairing of decks.
+	Games    int
+	Seed     int64
+	Strategy string
+	Mode     GameMode
+	Workers  int
+}
+
+type DeckStats struct {
+	Deck         string
+	Games        int
+	Wins         int
+	WinRate      float64
+	AverageTurns float64
+}
+
+type CardStats struct {
+	Deck              string
+	Card              string
+	Games             int
+	Played            int
+	PlayRate          float64
+	WinsWhenPlayed    int
+	WinRateWhenPlayed float64
+}
+
+type SimulationReport struct {
+	Games           int
+	AverageTurns    float64
+	AverageDuration time.Duration
+	Decks           []DeckStats
+	Cards           []CardStats
+}
+
+// NewStrategy builds a strategy by name, seeding it where it's random.
+func NewStrategy(name string, seed int64) (Strategy, error) {
+	switch name {
+	case "greedy":
+		return NewGreedyStrategy(), nil
+	case "random":
+		return NewRandomStrategy(seed), nil
+	}
+	return nil, ErrUnknownStrategy
+}
+
+// Simulate plays bots against each other with the given decks and
+// aggregates the results. Each game is seeded from config.Seed, which
+// fixes the shuffles, the card ids and the random strategies.
+func Simulate(config SimulationConfig) (SimulationReport, error) {
+	if len(config.Decks) == 0 {
+		return SimulationReport{}, ErrNoDecks
+	}
+
+	if _, err := NewStrategy(config.Strategy, 0); err != nil {
+		return SimulationReport{}, err
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+
+	pairings := make([][2]int, 0)
+	for i := range config.Decks {
+		for j := i + 1; j < len(config.Decks); j++ {
+			pairings = append(pairings, [2]int{i, j})
+		}
+	}
+	if len(pairings) == 0 {
+		pairings = append(pairings, [2]int{0, 0})
+	}
+
+	jobs := make(chan simulationJob)
+	results := make(chan simulatedGame)
+
+	go func() {
+		number := 0
+		for _, pairing := range pairings {
+			for i := 0; i < config.Games; i++ {
+				decks := pairing
+				// both decks get to go first equally often
+				if i%2 == 1 {
+					decks[0], decks[1] = decks[1], decks[0]
+				}
+
+				jobs <- simulationJob{
+					Decks: decks,
+					Seed:  config.Seed + int64(number),
+				}
+				number++
+			}
+		}
+		close(jobs)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			simulator := newSimulator(config)
+			defer simulator.dispatcher.Shutdown(context.Background())
+
+			for job := range jobs {
+				results <- simulator.play(job)
+			}
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	return aggregate(config.Decks, results), nil
+}
+
+type simulationJob struct {
+	Decks [2]int
+	Seed  int64
+}
+
+type simulatedSide struct {
+	Deck   int
+	Won    bool
+	Cards  map[string]bool
+	Played map[string]bool
+}
+
+type simulatedGame struct {
+	Sides    [2]simulatedSide
+	Turns    int
+	Duration time.Duration
+}
+
+type simulator struct {
+	config     SimulationConfig
+	dispatcher *Dispatcher
+}
+
+func newSimulator(config SimulationConfig) *simulator {
+	return &simulator{
+		config:     config,
+		dispatcher: NewDispatcher(),
+	}
+}
+
+func (s *simulator) play(job simulationJob) simulatedGame {
+	random := rand.New(rand.NewSource(job.Seed))
+
+	bots := make([]*Bot, 0)
+	players := make([]*Player, 0)
+	decks := make(map[*Player]*Deck)
+	labels := make(map[string]string)
+
+	for _, idx := range job.Decks {
+		strategy, _ := NewStrategy(s.config.Strategy, random.Int63())
+		bot := NewBot(strategy, s.dispatcher)
+
+		bots = append(bots, bot)
+		players = append(players, bot.Player)
+		decks[bot.Player] = seededDeck(s.config.Decks[idx], random, labels)
+	}
+
+	game := NewGameWithDecks(players, s.config.Mode, decks)
+
+	s.dispatcher.Register <- game
+	game.Start(s.config.Mode.Rules.TurnDuration)
+
+	result := <-game.Over
+
+	// once the bots stop, nothing else gets played in this game
+	for _, bot := range bots {
+		<-bot.Done()
+	}
+	s.dispatcher.Unregister <- game
+
+	simulated := simulatedGame{
+		Turns:    result.Turns,
+		Duration: result.Duration,
+	}
+
+	for idx, player := range players {
+		side := simulatedSide{
+			Deck:   job.Decks[idx],
+			Won:    result.Winner == player,
+			Cards:  make(map[string]bool),
+			Played: make(map[string]bool),
+		}
+
+		for _, spec := range s.config.Decks[side.Deck].Cards {
+			side.Cards[spec.Label()] = true
+		}
+
+		for _, card := range bots[idx].Played() {
+			side.Played[labels[card]] = true
+		}
+
+		simulated.Sides[idx] = side
+	}
+
+	return simulated
+}
+
+// seededDeck builds a shuffled deck drawing card ids from random, since
+// strategies order cards by id. labels is filled with each card's label.
+func seededDeck(list DeckList, random *rand.Rand, labels map[string]string) *Deck {
+	var cards []HasManaCost
+	for _, spec := range list.Cards {
+		id, _ := uuid.NewRandomFromReader(random)
+
+		card := &MinionCard{
+			Card: Card{
+				Id:       id,
+				ManaCost: spec.ManaCost,
+			},
+			Damage: spec.Damage,
+			Health: spec.Health,
+		}
+
+		labels[card.GetId()] = spec.Label()
+		cards = append(cards, card)
+	}
+
+	random.Shuffle(len(cards), func(i, j int) {
+		cards[i], cards[j] = cards[j], cards[i]
+	})
+
+	return &Deck{cards: cards}
+}
+
+func aggregate(lists []DeckList, results chan simulatedGame) SimulationReport {
+	report := SimulationReport{}
+
+	decks := make([]DeckStats, len(lists))
+	deckTurns := make([]int, len(lists))
+	cards := make(map[[2]string]*CardStats)
+
+	turns := 0
+	var duration time.Duration
+
+	for game := range results {
+		report.Games++
+		turns += game.Turns
+		duration += game.Duration
+
+		for _, side := range game.Sides {
+			name := deckName(lists, side.Deck)
+
+			decks[side.Deck].Games++
+			deckTurns[side.Deck] += game.Turns
+			if side.Won {
+				decks[side.Deck].Wins++
+			}
+
+			for label := range side.Cards {
+				key := [2]string{name, label}
+
+				stats, ok := cards[key]
+				if !ok {
+					stats = &CardStats{Deck: name, Card: label}
+					cards[key] = stats
+				}
+
+				stats.Games++
+				if side.Played[label] {
+					stats.Played++
+					if side.Won {
+						stats.WinsWhenPlayed++
+					}
+				}
+			}
+		}
+	}
+
+	if report.Games > 0 {
+		report.AverageTurns = float64(turns) / float64(report.Games)
+		report.AverageDuration = duration / time.Duration(report.Games)
+	}
+
+	for idx := range decks {
+		decks[idx].Deck = deckName(lists, idx)
+		if decks[idx].Games > 0 {
+			decks[idx].WinRate = float64(decks[idx].Wins) / float64(decks[idx].Games)
+			decks[idx].AverageTurns = float64(deckTurns[idx]) / float64(decks[idx].Games)
+		}
+	}
+	report.Decks = decks
+
+	report.Cards = make([]CardStats, 0, len(cards))
+	for _, stats := range cards {
+		stats.PlayRate = float64(stats.Played) / float64(stats.Games)
+		if stats.Played > 0 {
+			stats.WinRateWhenPlayed = float64(stats.WinsWhenPlayed) / float64(stats.Played)
+		}
+		report.Cards = append(report.Cards, *stats)
+	}
+
+	sort.Slice(report.Cards, func(i, j int) bool {
+		if report.Cards[i].Deck != report.Cards[j].Deck {
+			return report.Cards[i].Deck < report.Cards[j].Deck
+		}
+		return report.Cards[i].Card < report.Cards[j].Card
+	})
+
+	return report
+}
+
+func deckName(lists []DeckList, idx int) string {
+	if lists[idx].Name != "" {
+		return lists[idx].Name
+	}
+	return lists[idx].Id
+}
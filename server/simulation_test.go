@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+)
+
+func simulationDeck(name string, spec CardSpec) DeckList {
+	cards := make([]CardSpec, quickMode.Rules.DeckSize)
+	for i := range cards {
+		cards[i] = spec
+	}
+	return DeckList{Name: name, Cards: cards}
+}
+
+func TestSimulate(t *testing.T) {
+	report, err := Simulate(SimulationConfig{
+		Decks: []DeckList{
+			simulationDeck("strong", CardSpec{Name: "Giant", ManaCost: 1, Damage: 5, Health: 5}),
+			simulationDeck("weak", CardSpec{ManaCost: 1, Damage: 1, Health: 1}),
+		},
+		Games:    6,
+		Seed:     1,
+		Strategy: "greedy",
+		Mode:     quickMode,
+		Workers:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Games != 6 {
+		t.Fatalf("Expected 6 games, got %v", report.Games)
+	}
+
+	if report.AverageTurns == 0 {
+		t.Error("Expected turns to be played")
+	}
+
+	wins := 0
+	for _, deck := range report.Decks {
+		if deck.Games != 6 {
+			t.Errorf("Expected %v to play 6 games, got %v", deck.Deck, deck.Games)
+		}
+		wins += deck.Wins
+	}
+
+	if wins != 6 {
+		t.Errorf("Expected a winner for every game, got %v wins", wins)
+	}
+
+	if report.Decks[0].WinRate <= report.Decks[1].WinRate {
+		t.Errorf("Expected strong deck to win more, got %+v", report.Decks)
+	}
+
+	if len(report.Cards) != 2 {
+		t.Fatalf("Expected stats for 2 cards, got %+v", report.Cards)
+	}
+
+	giant := report.Cards[0]
+	if giant.Card != "Giant" || giant.Deck != "strong" {
+		t.Fatalf("Expected Giant from strong deck first, got %+v", giant)
+	}
+
+	if giant.Played != 6 || giant.PlayRate != 1 {
+		t.Errorf("Expected Giant to be played every game, got %+v", giant)
+	}
+
+	if report.Cards[1].Card != "1/1/1" {
+		t.Errorf("Expected unnamed card to be labeled by stats, got %v", report.Cards[1].Card)
+	}
+}
+
+func TestSimulateMirrorMatch(t *testing.T) {
+	report, err := Simulate(SimulationConfig{
+		Decks:    []DeckList{simulationDeck("mirror", CardSpec{ManaCost: 2, Damage: 2, Health: 3})},
+		Games:    2,
+		Strategy: "random",
+		Mode:     quickMode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Decks[0].Games != 4 || report.Decks[0].Wins != 2 {
+		t.Errorf("Expected both sides to count for the deck, got %+v", report.Decks[0])
+	}
+}
+
+func TestSimulateRejectsBadConfig(t *testing.T) {
+	if _, err := Simulate(SimulationConfig{Strategy: "greedy"}); err != ErrNoDecks {
+		t.Errorf("Expected ErrNoDecks, got %v", err)
+	}
+
+	_, err := Simulate(SimulationConfig{
+		Decks:    []DeckList{simulationDeck("deck", CardSpec{})},
+		Strategy: "clever",
+	})
+	if err != ErrUnknownStrategy {
+		t.Errorf("Expected ErrUnknownStrategy, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Account struct {
+	Id      string
+	Name    string
+	Rating  int
+	Created time.Time
+}
+
+type GameRecord struct {
+	Id       string
+	Mode     string
+	Players  []string
+	Winner   string
+	Started  time.Time
+	Duration time.Duration
+	Turns    int
+	// Replay is the id of the game's replay, empty if it wasn't kept
+	Replay string
+}
+
+type Store interface {
+	SaveAccount(account Account) error
+	GetAccount(id string) (Account, error)
+
+	SaveDeck(deck DeckList) error
+	GetDeck(id string) (DeckList, error)
+	GetDecks(owner string) ([]DeckList, error)
+
+	SaveGame(record GameRecord) error
+	GetMatchHistory(accountId string, limit int) ([]GameRecord, error)
+
+	SaveReplay(replay Replay) error
+	GetReplay(id string) (Replay, error)
+
+	Close() error
+}
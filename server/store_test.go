@@ -0,0 +1,155 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		bolt.Close()
+	})
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   bolt,
+	}
+}
+
+func TestSavesAccounts(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.GetAccount("42"); err != ErrNotFound {
+				t.Errorf("Expected %v, got %v", ErrNotFound, err)
+			}
+
+			store.SaveAccount(Account{Id: "42", Name: "douglas"})
+
+			account, err := store.GetAccount("42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if account.Name != "douglas" {
+				t.Errorf("Expected %v, got %v", "douglas", account.Name)
+			}
+		})
+	}
+}
+
+func TestSavesDecks(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.SaveDeck(DeckList{Id: "a", Owner: "42", Cards: []CardSpec{{ManaCost: 1, Damage: 1, Health: 1}}})
+			store.SaveDeck(DeckList{Id: "b", Owner: "42", Cards: []CardSpec{{ManaCost: 2, Damage: 2, Health: 2}}})
+			store.SaveDeck(DeckList{Id: "c", Owner: "other"})
+
+			decks, err := store.GetDecks("42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decks) != 2 {
+				t.Errorf("Expected %v decks, got %v", 2, len(decks))
+			}
+
+			deck, err := store.GetDeck("b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deck.Cards[0].ManaCost != 2 {
+				t.Errorf("Expected %v, got %v", 2, deck.Cards[0].ManaCost)
+			}
+
+			if _, err := store.GetDeck("d"); err != ErrNotFound {
+				t.Errorf("Expected %v, got %v", ErrNotFound, err)
+			}
+		})
+	}
+}
+
+func TestMatchHistoryIsMostRecentFirst(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.SaveGame(GameRecord{Id: "1", Players: []string{"42", "43"}, Winner: "42"})
+			store.SaveGame(GameRecord{Id: "2", Players: []string{"43", "44"}, Winner: "44"})
+			store.SaveGame(GameRecord{Id: "3", Players: []string{"42", "44"}, Winner: "44", Turns: 10, Duration: time.Minute})
+
+			history, err := store.GetMatchHistory("42", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 2 {
+				t.Fatalf("Expected %v games, got %v", 2, len(history))
+			}
+			if history[0].Id != "3" || history[1].Id != "1" {
+				t.Errorf("Expected games 3 and 1, got %v and %v", history[0].Id, history[1].Id)
+			}
+			if history[0].Turns != 10 || history[0].Duration != time.Minute {
+				t.Errorf("Expected turns and duration to be saved, got %+v", history[0])
+			}
+
+			limited, _ := store.GetMatchHistory("44", 1)
+			if len(limited) != 1 || limited[0].Id != "3" {
+				t.Errorf("Expected only game 3, got %+v", limited)
+			}
+		})
+	}
+}
+
+func TestSavesReplays(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.SaveReplay(Replay{
+				Id:      "replay",
+				GameId:  "game",
+				Players: []ReplayPlayer{{Id: "42", Deck: []ReplayCard{{Id: "card", ManaCost: 1, Damage: 2, Health: 3}}}},
+				Actions: []ReplayAction{{At: time.Second, Player: "42", Type: PlayCard, Cards: []string{"card"}}},
+			})
+
+			replay, err := store.GetReplay("replay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replay.Players[0].Deck[0].Health != 3 || replay.Actions[0].Type != PlayCard {
+				t.Errorf("Expected the replay to be saved as it was, got %+v", replay)
+			}
+
+			if _, err := store.GetReplay("other"); err != ErrNotFound {
+				t.Errorf("Expected %v, got %v", ErrNotFound, err)
+			}
+		})
+	}
+}
+
+func TestBoltStorePersistsBetweenOpens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.SaveAccount(Account{Id: "42", Name: "douglas"})
+	store.SaveGame(GameRecord{Id: "1", Players: []string{"42", "43"}})
+	store.Close()
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.GetAccount("42"); err != nil {
+		t.Errorf("Expected account to be persisted, got %v", err)
+	}
+
+	history, _ := store.GetMatchHistory("43", 0)
+	if len(history) != 1 {
+		t.Errorf("Expected %v game, got %v", 1, len(history))
+	}
+}
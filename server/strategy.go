@@ -0,0 +1,190 @@
+package server
+
+import (
+	"math/rand"
+	"sort"
+)
+
+type Action struct {
+	Type     EventType
+	Card     string
+	Attacker string
+	Target   string
+}
+
+var EndTurnAction = Action{Type: EndTurn}
+
+type Strategy interface {
+	// Mulligan returns the ids of the starting hand cards to put back.
+	Mulligan(hand []HasManaCost) []string
+	// Act returns the next action for the bot's turn. The turn only
+	// ends once it returns EndTurnAction.
+	Act(state BotState) Action
+}
+
+// LegalActions lists every action the game would currently accept,
+// ending the turn included.
+func LegalActions(state BotState) []Action {
+	actions := []Action{EndTurnAction}
+
+	boardSize := state.BoardSize
+	if boardSize == 0 {
+		boardSize = MaxBoardSize
+	}
+
+	if len(state.Board) < boardSize {
+		for _, card := range state.Hand {
+			if _, ok := card.(Defender); ok && card.GetManaCost() <= state.Mana {
+				actions = append(actions, Action{Type: PlayCard, Card: card.GetId()})
+			}
+		}
+	}
+
+	for _, attacker := range sortedDefenders(state.Board) {
+		if !attacker.CanAttack() {
+			continue
+		}
+
+		if len(state.Enemy) == 0 {
+			actions = append(actions, Action{Type: AttackPlayer, Attacker: attacker.GetId()})
+			continue
+		}
+
+		for _, target := range sortedDefenders(state.Enemy) {
+			actions = append(actions, Action{
+				Type:     Attack,
+				Attacker: attacker.GetId(),
+				Target:   target.GetId(),
+			})
+		}
+	}
+
+	return actions
+}
+
+type RandomStrategy struct {
+	random *rand.Rand
+}
+
+func NewRandomStrategy(seed int64) *RandomStrategy {
+	return &RandomStrategy{random: rand.New(rand.NewSource(seed))}
+}
+
+func (s *RandomStrategy) Mulligan(hand []HasManaCost) []string {
+	discarded := make([]string, 0)
+	for _, card := range hand {
+		if s.random.Intn(2) == 0 {
+			discarded = append(discarded, card.GetId())
+		}
+	}
+	return discarded
+}
+
+func (s *RandomStrategy) Act(state BotState) Action {
+	actions := LegalActions(state)
+	return actions[s.random.Intn(len(actions))]
+}
+
+// GreedyStrategy plays the most expensive cards it can afford, goes
+// face whenever the enemy board is empty and otherwise makes the attack
+// that leaves the best difference in board value.
+type GreedyStrategy struct{}
+
+func NewGreedyStrategy() *GreedyStrategy {
+	return &GreedyStrategy{}
+}
+
+func (s *GreedyStrategy) Mulligan(hand []HasManaCost) []string {
+	discarded := make([]string, 0)
+	for _, card := range hand {
+		if card.GetManaCost() > 3 {
+			discarded = append(discarded, card.GetId())
+		}
+	}
+	return discarded
+}
+
+func (s *GreedyStrategy) Act(state BotState) Action {
+	var best *Action
+	bestCost := -1
+	bestValue := 0
+
+	for _, action := range LegalActions(state) {
+		action := action
+
+		switch action.Type {
+		case PlayCard:
+			cost := cardById(state.Hand, action.Card).GetManaCost()
+			if cost > bestCost {
+				best, bestCost = &action, cost
+			}
+		}
+	}
+
+	if best != nil {
+		return *best
+	}
+
+	for _, action := range LegalActions(state) {
+		action := action
+
+		switch action.Type {
+		case AttackPlayer:
+			return action
+		case Attack:
+			value := tradeValue(state.Board[action.Attacker], state.Enemy[action.Target])
+			if best == nil || value > bestValue {
+				best, bestValue = &action, value
+			}
+		}
+	}
+
+	if best != nil {
+		return *best
+	}
+
+	return EndTurnAction
+}
+
+// tradeValue is how much the difference in board value between both
+// players changes if attacker hits target.
+func tradeValue(attacker, target Defender) int {
+	value := 0
+
+	targetHealth := target.GetHealth() - attacker.GetDamage()
+	if targetHealth <= 0 {
+		value += target.GetDamage() + target.GetHealth()
+	} else {
+		value += attacker.GetDamage()
+
+		attackerHealth := attacker.GetHealth() - target.GetDamage()
+		if attackerHealth <= 0 {
+			value -= attacker.GetDamage() + attacker.GetHealth()
+		} else {
+			value -= target.GetDamage()
+		}
+	}
+
+	return value
+}
+
+func cardById(cards []HasManaCost, id string) HasManaCost {
+	for _, card := range cards {
+		if card.GetId() == id {
+			return card
+		}
+	}
+	return nil
+}
+
+// sortedDefenders keeps the actions deterministic for seeded strategies.
+func sortedDefenders(defenders map[string]ActiveDefender) []ActiveDefender {
+	sorted := make([]ActiveDefender, 0, len(defenders))
+	for _, defender := range defenders {
+		sorted = append(sorted, defender)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].GetId() < sorted[j].GetId()
+	})
+	return sorted
+}
@@ -0,0 +1,170 @@
+package server
+
+import "testing"
+
+func activeMinion(manaCost, damage, health int, status Status) ActiveDefender {
+	return &ActiveMinion{
+		Defender: NewMinion(manaCost, damage, health),
+		Status:   status,
+	}
+}
+
+func boardOf(defenders ...ActiveDefender) map[string]ActiveDefender {
+	board := make(map[string]ActiveDefender)
+	for _, defender := range defenders {
+		board[defender.GetId()] = defender
+	}
+	return board
+}
+
+func TestLegalActionsOnlyIncludeAffordableCards(t *testing.T) {
+	cheap := NewMinion(1, 1, 1)
+	expensive := NewMinion(5, 1, 1)
+
+	actions := LegalActions(BotState{
+		Mana:  3,
+		Hand:  []HasManaCost{cheap, expensive},
+		Board: boardOf(),
+		Enemy: boardOf(),
+	})
+
+	if len(actions) != 2 {
+		t.Fatalf("Expected %v actions, got %v", 2, actions)
+	}
+	if actions[1].Card != cheap.GetId() {
+		t.Errorf("Expected %v to be playable, got %v", cheap.GetId(), actions[1].Card)
+	}
+}
+
+func TestLegalActionsCannotPlayOnFullBoard(t *testing.T) {
+	board := boardOf()
+	for i := 0; i < MaxBoardSize; i++ {
+		minion := activeMinion(1, 1, 1, &Exhausted{})
+		board[minion.GetId()] = minion
+	}
+
+	actions := LegalActions(BotState{
+		Mana:  10,
+		Hand:  []HasManaCost{NewMinion(1, 1, 1)},
+		Board: board,
+		Enemy: boardOf(),
+	})
+
+	if len(actions) != 1 || actions[0] != EndTurnAction {
+		t.Errorf("Expected only to end turn, got %v", actions)
+	}
+}
+
+func TestLegalActionsUseTheGamesBoardSize(t *testing.T) {
+	minion := activeMinion(1, 1, 1, &Exhausted{})
+
+	actions := LegalActions(BotState{
+		Mana:      10,
+		Hand:      []HasManaCost{NewMinion(1, 1, 1)},
+		Board:     boardOf(minion),
+		Enemy:     boardOf(),
+		BoardSize: 1,
+	})
+
+	if len(actions) != 1 || actions[0] != EndTurnAction {
+		t.Errorf("Expected only to end turn, got %v", actions)
+	}
+}
+
+func TestLegalActionsCannotGoFaceThroughMinions(t *testing.T) {
+	attacker := activeMinion(1, 1, 1, &Ready{})
+	defender := activeMinion(1, 1, 1, &Exhausted{})
+
+	for _, action := range LegalActions(BotState{
+		Board: boardOf(attacker),
+		Enemy: boardOf(defender),
+	}) {
+		if action.Type == AttackPlayer {
+			t.Error("Should not attack player with minions on board")
+		}
+	}
+}
+
+func TestGreedyPlaysMostExpensiveCard(t *testing.T) {
+	cheap := NewMinion(1, 1, 1)
+	expensive := NewMinion(3, 1, 1)
+	unaffordable := NewMinion(4, 1, 1)
+
+	action := NewGreedyStrategy().Act(BotState{
+		Mana:  3,
+		Hand:  []HasManaCost{cheap, expensive, unaffordable},
+		Board: boardOf(),
+		Enemy: boardOf(),
+	})
+
+	if action.Type != PlayCard || action.Card != expensive.GetId() {
+		t.Errorf("Expected to play %v, got %+v", expensive.GetId(), action)
+	}
+}
+
+func TestGreedyGoesFace(t *testing.T) {
+	attacker := activeMinion(1, 1, 1, &Ready{})
+
+	action := NewGreedyStrategy().Act(BotState{
+		Board: boardOf(attacker),
+		Enemy: boardOf(),
+	})
+
+	if action.Type != AttackPlayer || action.Attacker != attacker.GetId() {
+		t.Errorf("Expected to attack player, got %+v", action)
+	}
+}
+
+func TestGreedyMakesBestTrade(t *testing.T) {
+	attacker := activeMinion(1, 3, 5, &Ready{})
+	big := activeMinion(1, 8, 8, &Exhausted{})
+	killable := activeMinion(1, 2, 3, &Exhausted{})
+
+	action := NewGreedyStrategy().Act(BotState{
+		Board: boardOf(attacker),
+		Enemy: boardOf(big, killable),
+	})
+
+	if action.Type != Attack || action.Target != killable.GetId() {
+		t.Errorf("Expected to attack %v, got %+v", killable.GetId(), action)
+	}
+}
+
+func TestGreedyEndsTurnWithNothingToDo(t *testing.T) {
+	action := NewGreedyStrategy().Act(BotState{
+		Hand:  []HasManaCost{NewMinion(5, 1, 1)},
+		Board: boardOf(activeMinion(1, 1, 1, &Exhausted{})),
+		Enemy: boardOf(),
+	})
+
+	if action != EndTurnAction {
+		t.Errorf("Expected to end turn, got %+v", action)
+	}
+}
+
+func TestRandomStrategyPicksLegalActions(t *testing.T) {
+	strategy := NewRandomStrategy(1)
+
+	state := BotState{
+		Mana:  2,
+		Hand:  []HasManaCost{NewMinion(1, 1, 1), NewMinion(9, 1, 1)},
+		Board: boardOf(activeMinion(1, 1, 1, &Ready{})),
+		Enemy: boardOf(activeMinion(1, 1, 1, &Exhausted{})),
+	}
+
+	legal := LegalActions(state)
+
+	for i := 0; i < 50; i++ {
+		action := strategy.Act(state)
+
+		found := false
+		for _, candidate := range legal {
+			if candidate == action {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("Expected a legal action, got %+v", action)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// Supervised is a handler with cleaning up to do when it panics, beyond
+// the panic being logged and the event's player being told.
+type Supervised interface {
+	Handler
+	Crashed(event Event, reason interface{})
+}
+
+// logPanic logs a recovered panic along with the stack it came from, so
+// it has to be called from the deferred function that recovered it.
+func logPanic(reason interface{}, context string) {
+	log.Printf("Recovered from panic in %v: %v\n%s", context, reason, debug.Stack())
+}
+
+// handleSafely has handler process event, recovering if it panics, so
+// one broken handler or event doesn't take the whole server down.
+func handleSafely(handler Handler, event Event, dispatcher *Dispatcher) {
+	defer func() {
+		reason := recover()
+		if reason == nil {
+			return
+		}
+
+		logPanic(reason, fmt.Sprintf("%T handling %v for %q", handler, event.Type, event.RoutingKey()))
+
+		if supervised, ok := handler.(Supervised); ok {
+			supervised.Crashed(event, reason)
+
+			// the handler tells players what became of it, but not
+			// how the event turned out
+			if event.Id != "" {
+				go event.Fail("Something went wrong")
+			}
+			return
+		}
+
+		go event.Fail("Something went wrong, try again")
+	}()
+
+	handler.Process(event, dispatcher)
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PanickingHandler panics on QueueUp and passes anything else on.
+type PanickingHandler struct {
+	Events chan Event
+}
+
+func (p *PanickingHandler) Process(event Event, dispatcher *Dispatcher) {
+	if event.Type == QueueUp {
+		var player *Player
+		_ = player.Id
+	}
+	p.Events <- event
+}
+
+func TestRecoversFromPanickingHandler(t *testing.T) {
+	player := NewTestPlayer()
+	dispatcher := NewDispatcher()
+	panicking := &PanickingHandler{Events: make(chan Event, 1)}
+	handler := &TestHandler{Executed: make(chan bool, 2)}
+
+	dispatcher.Register <- panicking
+	dispatcher.Register <- handler
+
+	dispatcher.Dispatch <- Event{Type: QueueUp, Player: player}
+
+	select {
+	case response := <-player.Outgoing:
+		if response.Type != Error {
+			t.Errorf("Expected %v, got %v", Error, response.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected player to be told something went wrong")
+	}
+
+	dispatcher.Dispatch <- Event{Type: Dequeue, Player: player}
+
+	select {
+	case event := <-panicking.Events:
+		if event.Type != Dequeue {
+			t.Errorf("Expected %v, got %v", Dequeue, event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected handler to keep handling events after a panic")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handler.Executed:
+		case <-time.After(time.Second):
+			t.Fatal("Expected other handlers to get every event")
+		}
+	}
+}
+
+// unplayableCard is in a hand but isn't a minion, which the game
+// doesn't expect.
+type unplayableCard struct {
+	Id string
+}
+
+func (c *unplayableCard) GetId() string        { return c.Id }
+func (c *unplayableCard) GetManaCost() int     { return 0 }
+func (c *unplayableCard) ReduceManaCost(int)   {}
+func (c *unplayableCard) IncreaseManaCost(int) {}
+
+func expectAborted(t *testing.T, game *Game, players ...*Player) {
+	t.Helper()
+
+	for _, player := range players {
+		select {
+		case response := <-player.Outgoing:
+			if response.Type != GameAborted {
+				t.Errorf("Expected %v, got %v", GameAborted, response.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected players to be told the game was aborted")
+		}
+	}
+
+	select {
+	case result := <-game.Over:
+		if !result.Aborted || result.Winner != nil {
+			t.Errorf("Expected game to end without a winner, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected game result")
+	}
+}
+
+func TestAbortsGameWhenItsLoopPanics(t *testing.T) {
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	p3, p4 := NewTestPlayer(), NewTestPlayer()
+
+	broken := NewGame([]*Player{p1, p2}, CasualMode)
+	other := NewGame([]*Player{p3, p4}, CasualMode)
+
+	for _, game := range []*Game{broken, other} {
+		go game.StartTurns(time.Minute)
+	}
+	for _, player := range []*Player{p1, p2, p3, p4} {
+		<-player.Outgoing // start or wait turn
+	}
+
+	// a card that can't go on the board has the game loop panic when
+	// it's played
+	card := &unplayableCard{Id: uuid.NewString()}
+	broken.Players[p1].Hand = append(broken.Players[p1].Hand, card)
+
+	go broken.Process(Event{
+		Type:   PlayCard,
+		Player: p1,
+		Payload: PlayCardPayload{
+			GameId: broken.Id.String(),
+			Card:   card.Id,
+		},
+	}, nil)
+
+	expectAborted(t, broken, p1, p2)
+
+	go other.Process(Event{
+		Type:    Concede,
+		Player:  p4,
+		Payload: other.Id.String(),
+	}, nil)
+
+	for _, player := range []*Player{p3, p4} {
+		select {
+		case response := <-player.Outgoing:
+			if response.Type != GameOver {
+				t.Errorf("Expected %v, got %v", GameOver, response.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected other game to keep running")
+		}
+	}
+}
+
+// brokenGame is a game that panics handling anything.
+type brokenGame struct {
+	*Game
+}
+
+func (b *brokenGame) Process(event Event, dispatcher *Dispatcher) {
+	panic("broken game")
+}
+
+func TestAbortsGameWhenItsHandlerPanics(t *testing.T) {
+	p1, p2 := NewTestPlayer(), NewTestPlayer()
+	dispatcher := NewDispatcher()
+	game := NewGame([]*Player{p1, p2}, CasualMode)
+
+	dispatcher.Register <- &brokenGame{game}
+
+	dispatcher.Dispatch <- Event{
+		Type:    EndTurn,
+		Player:  p1,
+		Payload: game.Id.String(),
+	}
+
+	expectAborted(t, game, p1, p2)
+}